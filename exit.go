@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fiam/msp-tool/fc"
+)
+
+// Exit codes, so wrapper scripts can tell why msp-tool failed
+const (
+	exitOK               = 0
+	exitFailure          = 1 // Any error not covered by the codes below
+	exitUsage            = 2
+	exitPortNotFound     = 3
+	exitPermissionDenied = 4
+	exitNoResponse       = 5
+	exitBuildFailed      = 6
+	exitDFUFailed        = 7
+	exitVariantMismatch  = 8
+)
+
+// usageError is returned when a flag has an invalid value
+type usageError struct {
+	flag string
+	err  error
+}
+
+func (e *usageError) Error() string {
+	return fmt.Sprintf("invalid -%s: %v", e.flag, e.err)
+}
+
+// exitCode returns the exit code corresponding to err
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	switch err.(type) {
+	case *usageError:
+		return exitUsage
+	case *fc.BuildError:
+		return exitBuildFailed
+	case *fc.DFUError:
+		return exitDFUFailed
+	case *fc.IdentityMismatchError:
+		return exitVariantMismatch
+	}
+	switch {
+	case err == fc.ErrNoIdentity || err == fc.ErrNoFrames:
+		return exitNoResponse
+	case os.IsNotExist(err):
+		return exitPortNotFound
+	case os.IsPermission(err):
+		return exitPermissionDenied
+	}
+	return exitFailure
+}
+
+// fatal restores the terminal, prints err and exits with the
+// exit code corresponding to it.
+func fatal(km *keyboardMonitor, err error) {
+	if km != nil {
+		km.Close()
+		if km.log != nil {
+			fmt.Fprintf(km.log, "%v\n", err)
+			km.log.Close()
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,66 @@
+package fc
+
+import (
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Maximum accelerometer trim, in either direction
+const maxAccTrim = 300
+
+// GetAccTrim returns the accelerometer trim for pitch and roll
+func (f *FC) GetAccTrim() (pitch int16, roll int16, err error) {
+	fr, err := f.request(msp.MspAccTrim)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := fr.Read(&pitch); err != nil {
+		return 0, 0, err
+	}
+	if err := fr.Read(&roll); err != nil {
+		return 0, 0, err
+	}
+	return pitch, roll, nil
+}
+
+// SetAccTrim sets the accelerometer trim for pitch and roll and then
+// writes it to the EEPROM.
+func (f *FC) SetAccTrim(pitch int16, roll int16) error {
+	if err := f.setAccTrim(pitch, roll); err != nil {
+		return err
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err := m.WriteCmd(msp.MspEepromWrite)
+	return err
+}
+
+// NudgeAccTrim adds the given deltas to the accelerometer trim and
+// returns the new values. Changes take effect immediately, but they're
+// not written to the EEPROM.
+func (f *FC) NudgeAccTrim(deltaPitch int16, deltaRoll int16) (pitch int16, roll int16, err error) {
+	if pitch, roll, err = f.GetAccTrim(); err != nil {
+		return 0, 0, err
+	}
+	pitch += deltaPitch
+	roll += deltaRoll
+	if err := f.setAccTrim(pitch, roll); err != nil {
+		return 0, 0, err
+	}
+	return pitch, roll, nil
+}
+
+func (f *FC) setAccTrim(pitch int16, roll int16) error {
+	if pitch > maxAccTrim || pitch < -maxAccTrim || roll > maxAccTrim || roll < -maxAccTrim {
+		return fmt.Errorf("invalid accelerometer trim %d/%d, must be within [-%d, %d]", pitch, roll, maxAccTrim, maxAccTrim)
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err := m.WriteCmd(msp.MspSetAccTrim, pitch, roll)
+	return err
+}
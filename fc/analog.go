@@ -0,0 +1,97 @@
+package fc
+
+import (
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// AnalogState contains the battery and RSSI readings reported by
+// MSP_ANALOG, which is supported by all the variants.
+type AnalogState struct {
+	Voltage  float64 // V
+	MAhDrawn uint16
+	RSSI     uint16  // 0-1023
+	Current  float64 // A
+}
+
+func (a *AnalogState) String() string {
+	return fmt.Sprintf("%.2fV, %.2fA, %dmAh drawn, RSSI %d%%",
+		a.Voltage, a.Current, a.MAhDrawn, int(a.RSSI)*100/1023)
+}
+
+func decodeAnalogState(fr *msp.MSPFrame) (*AnalogState, error) {
+	var analog struct {
+		VBat     uint8 // 0.1V
+		MAhDrawn uint16
+		RSSI     uint16
+		Amperage int16 // 0.01A
+	}
+	if err := fr.Read(&analog); err != nil {
+		return nil, err
+	}
+	a := &AnalogState{
+		Voltage:  float64(analog.VBat) / 10,
+		MAhDrawn: analog.MAhDrawn,
+		RSSI:     analog.RSSI,
+		Current:  float64(analog.Amperage) / 100,
+	}
+	// BF 4.0+ appends the voltage with more precision
+	if fr.BytesRemaining() >= 2 {
+		var vbat uint16
+		if err := fr.Read(&vbat); err != nil {
+			return nil, err
+		}
+		a.Voltage = float64(vbat) / 100
+	}
+	return a, nil
+}
+
+func decodeAnalog(fr *msp.MSPFrame) string {
+	a, err := decodeAnalogState(fr)
+	if err != nil {
+		return ""
+	}
+	return a.String()
+}
+
+// updateAnalog stores the readings in a MSP_ANALOG frame, so they're
+// returned by Battery().
+func (f *FC) updateAnalog(fr *msp.MSPFrame) (*AnalogState, error) {
+	a, err := decodeAnalogState(fr)
+	if err != nil {
+		return nil, err
+	}
+	f.analogMu.Lock()
+	f.analog = *a
+	f.analogMu.Unlock()
+	return a, nil
+}
+
+// Battery returns the last readings from MSP_ANALOG. Unlike
+// BatteryState(), it doesn't request them from the board. If they
+// haven't been received yet, it returns the zero AnalogState.
+func (f *FC) Battery() AnalogState {
+	f.analogMu.Lock()
+	defer f.analogMu.Unlock()
+	return f.analog
+}
+
+// readAnalog requests MSP_ANALOG from the board
+func (f *FC) readAnalog() (*AnalogState, error) {
+	fr, err := f.request(msp.MspAnalog)
+	if err != nil {
+		return nil, err
+	}
+	return f.updateAnalog(fr)
+}
+
+// PrintAnalog prints a one line summary of the battery and RSSI
+func (f *FC) PrintAnalog() error {
+	a, err := f.readAnalog()
+	if err != nil {
+		return err
+	}
+	f.printf("Battery: %s\n", a)
+	return nil
+}
@@ -0,0 +1,125 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+	"github.com/fiam/msp-tool/rx"
+)
+
+const (
+	defaultArmChannel = 5
+	armTimeout        = 2 * time.Second
+	armPollInterval   = 100 * time.Millisecond
+	// FEATURE_RX_MSP, which makes MSP the receiver of the board
+	featureRXMSP FeatureMask = 1 << 14
+)
+
+// mspOverrideBoxes are the names of the modes which make the board use
+// the RC channels sent via MSP instead of the ones from its receiver,
+// for Betaflight and INAV.
+var mspOverrideBoxes = []string{"MSP OVERRIDE", "MSP RC OVERRIDE"}
+
+func (f *FC) armChannel() int {
+	if f.opts.ArmChannel > 0 {
+		return f.opts.ArmChannel
+	}
+	return defaultArmChannel
+}
+
+// ArmSequence arms the board using RX simulation. It moves the throttle
+// to its minimum, raises the arm channel (see FCOptions.ArmChannel) and
+// waits for the board to report that it's armed. If the board doesn't
+// arm, the arm channel is lowered again and the returned error includes
+// the reasons reported by the board. RX simulation must be active and
+// the board must be using its channels, either because its receiver is
+// MSP or because the MSP override mode is active.
+func (f *FC) ArmSequence() error {
+	if !f.IsSimulatingRX() {
+		return errors.New("RX simulation must be active to arm")
+	}
+	active, err := f.mspRCActive()
+	if err != nil {
+		return fmt.Errorf("could not check whether the board uses the RC channels sent via MSP: %v", err)
+	}
+	if !active {
+		return errors.New("the board ignores the RC channels sent via MSP, enable the MSP receiver or the MSP override mode")
+	}
+	f.sticks.SetChannel(4, rx.RxLow)
+	// Let the board see the low throttle before arming
+	time.Sleep(armPollInterval)
+	ch := f.armChannel()
+	if err := f.sticks.SetChannel(ch, rx.RxHigh); err != nil {
+		return err
+	}
+	var st *StatusInfo
+	deadline := time.Now().Add(armTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(armPollInterval)
+		s, err := f.readStatus()
+		if err != nil {
+			continue
+		}
+		if s.Armed() {
+			return nil
+		}
+		st = s
+	}
+	f.sticks.SetChannel(ch, rx.RxLow)
+	if st == nil {
+		return errors.New("board didn't report its status")
+	}
+	reasons, err := f.ArmingBlockers()
+	if err != nil {
+		reasons = ArmingDisableReasons(f.Info().Variant, st.ArmingDisableFlags)
+	}
+	if len(reasons) == 0 {
+		return errors.New("board didn't arm")
+	}
+	return fmt.Errorf("board refused to arm: %s", strings.Join(reasons, ", "))
+}
+
+// mspRCActive queries the board for whether it uses the RC channels sent
+// via MSP, because of either FEATURE_RX_MSP or an active override mode.
+func (f *FC) mspRCActive() (bool, error) {
+	fr, err := f.request(msp.MspFeature)
+	if err != nil {
+		return false, err
+	}
+	features, _, err := readFeatures(fr)
+	if err != nil {
+		return false, err
+	}
+	if features.Has(featureRXMSP) {
+		return true, nil
+	}
+	if fr, err = f.request(msp.MspBoxNames); err != nil {
+		return false, err
+	}
+	names := string(fr.Payload)
+	st, err := f.readStatus()
+	if err != nil {
+		return false, err
+	}
+	return overrideBoxActive(names, st.ModeFlags), nil
+}
+
+// overrideBoxActive returns true iff any of mspOverrideBoxes is active.
+// names is the MSP_BOXNAMES payload, whose order matches the bits in
+// modeFlags.
+func overrideBoxActive(names string, modeFlags uint32) bool {
+	for ii, name := range strings.Split(strings.TrimSuffix(names, ";"), ";") {
+		if ii >= 32 {
+			break
+		}
+		for _, box := range mspOverrideBoxes {
+			if name == box && modeFlags&(1<<uint(ii)) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
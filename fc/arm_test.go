@@ -0,0 +1,24 @@
+package fc
+
+import "testing"
+
+func TestOverrideBoxActive(t *testing.T) {
+	const btfl = "ARM;ANGLE;HORIZON;MSP OVERRIDE;"
+	const inav = "ARM;ANGLE;MSP RC OVERRIDE;NAV ALTHOLD;"
+	cases := []struct {
+		names     string
+		modeFlags uint32
+		want      bool
+	}{
+		{btfl, 1<<3 | 1, true},
+		{btfl, 1<<2 | 1, false},
+		{inav, 1 << 2, true},
+		{inav, 1 << 3, false},
+		{"ARM;ANGLE;", 0xffffffff, false},
+	}
+	for _, c := range cases {
+		if got := overrideBoxActive(c.names, c.modeFlags); got != c.want {
+			t.Errorf("overrideBoxActive(%q, 0x%x) = %v, want %v", c.names, c.modeFlags, got, c.want)
+		}
+	}
+}
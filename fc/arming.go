@@ -0,0 +1,158 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Bits in the INAV arming flags which indicate the arming state
+// rather than a reason preventing arming.
+const inavArmingStateMask = 0x7f
+
+// Names of the flags preventing the board from arming, indexed by
+// bit. See armingDisableFlags_e in BF and armingFlag_e in INAV.
+var (
+	betaflightArmingDisableFlags = []string{
+		"NO_GYRO",
+		"FAILSAFE",
+		"RX_FAILSAFE",
+		"BAD_RX_RECOVERY",
+		"BOXFAILSAFE",
+		"RUNAWAY_TAKEOFF",
+		"CRASH_DETECTED",
+		"THROTTLE",
+		"ANGLE",
+		"BOOT_GRACE_TIME",
+		"NOPREARM",
+		"LOAD",
+		"CALIBRATING",
+		"CLI",
+		"CMS_MENU",
+		"BST",
+		"MSP",
+		"PARALYZE",
+		"GPS",
+		"RESC",
+		"RPMFILTER",
+		"REBOOT_REQUIRED",
+		"DSHOT_BITBANG",
+		"ACC_CALIBRATION",
+		"MOTOR_PROTOCOL",
+		"ARM_SWITCH",
+	}
+	inavArmingDisableFlags = []string{
+		7:  "FAILSAFE_SYSTEM",
+		8:  "NOT_LEVEL",
+		9:  "SENSORS_CALIBRATING",
+		10: "SYSTEM_OVERLOADED",
+		11: "NAVIGATION_UNSAFE",
+		12: "COMPASS_NOT_CALIBRATED",
+		13: "ACCELEROMETER_NOT_CALIBRATED",
+		14: "ARM_SWITCH",
+		15: "HARDWARE_FAILURE",
+		16: "BOXFAILSAFE",
+		17: "BOXKILLSWITCH",
+		18: "RC_LINK",
+		19: "THROTTLE",
+		20: "CLI",
+		21: "CMS_MENU",
+		22: "OSD_MENU",
+		23: "ROLLPITCH_NOT_CENTERED",
+		24: "SERVO_AUTOTRIM",
+		25: "OOM",
+		26: "INVALID_SETTING",
+		27: "PWM_OUTPUT_ERROR",
+		28: "NO_PREARM",
+		29: "DSHOT_BEEPER",
+		30: "LANDING_DETECTED",
+	}
+)
+
+// Offset of the arming flags in MSP2_INAV_STATUS
+const inavStatusArmingFlagsOffset = 9
+
+// ArmingBlockers returns the reasons reported by the board that
+// prevent it from arming, using the names from the firmware.
+func (f *FC) ArmingBlockers() ([]string, error) {
+	info := f.Info()
+	var flags uint32
+	// INAV 2.0+ sends all 32 bits of the arming flags in
+	// MSP2_INAV_STATUS, while MSP_STATUS_EX only has 16.
+	if fr, err := f.inavStatus(info); err == nil {
+		var armingFlags uint32
+		if err := fr.Read(make([]uint8, inavStatusArmingFlagsOffset)); err != nil {
+			return nil, err
+		}
+		if err := fr.Read(&armingFlags); err != nil {
+			return nil, err
+		}
+		flags = armingFlags &^ inavArmingStateMask
+	} else {
+		st, err := f.readStatus()
+		if err != nil {
+			return nil, err
+		}
+		flags = st.ArmingDisableFlags
+	}
+	return ArmingDisableReasons(info.Variant, flags), nil
+}
+
+// ClearArmingBlockers clears the arming blockers that can be reset
+// remotely and returns the blockers reported before and after doing
+// so. Only BF 3.4+ supports it, via MSP_ARMING_DISABLE, which clears
+// the MSP flag and the runaway takeoff prevention. Other reasons, like
+// the ones detected after boot, can only be fixed on the craft.
+func (f *FC) ClearArmingBlockers() (before []string, after []string, err error) {
+	info := f.Info()
+	if info.Variant != "BTFL" || !info.atLeast(3, 4) {
+		return nil, nil, errors.New("clearing arming blockers is only supported by Betaflight 3.4+")
+	}
+	if before, err = f.ArmingBlockers(); err != nil {
+		return nil, nil, err
+	}
+	// Enable arming and the runaway takeoff prevention
+	if _, err := f.request(msp.MspArmingDisable, uint8(0), uint8(0)); err != nil {
+		return nil, nil, err
+	}
+	// Give the board a loop iteration to update the flags
+	time.Sleep(armPollInterval)
+	if after, err = f.ArmingBlockers(); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+func (f *FC) inavStatus(info Info) (*msp.MSPFrame, error) {
+	if info.Variant != "INAV" || !info.atLeast(2, 0) {
+		return nil, errors.New("MSP2_INAV_STATUS is only supported by INAV 2.0+")
+	}
+	return f.requestV2(msp.Msp2INAVStatus)
+}
+
+// ArmingDisableReasons returns the names of the flags set in
+// flags, as reported by a board running the given variant. Unknown
+// flags are returned as their bit number.
+func ArmingDisableReasons(variant string, flags uint32) []string {
+	var names []string
+	switch variant {
+	case "BTFL":
+		names = betaflightArmingDisableFlags
+	case "INAV":
+		names = inavArmingDisableFlags
+	}
+	var reasons []string
+	for ii := uint(0); ii < 32; ii++ {
+		if flags&(1<<ii) == 0 {
+			continue
+		}
+		if int(ii) < len(names) && names[ii] != "" {
+			reasons = append(reasons, names[ii])
+		} else {
+			reasons = append(reasons, fmt.Sprintf("FLAG_%d", ii))
+		}
+	}
+	return reasons
+}
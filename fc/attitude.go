@@ -0,0 +1,82 @@
+package fc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// attitude is the last attitude received from the board, in degrees
+type attitude struct {
+	roll, pitch, yaw float64
+}
+
+// decodeAttitudeDegrees returns the roll, pitch and yaw in degrees from a
+// MSP_ATTITUDE frame.
+func decodeAttitudeDegrees(fr *msp.MSPFrame) (roll, pitch, yaw float64, err error) {
+	var att msp.MSPAttitude
+	if err := fr.Read(&att); err != nil {
+		return 0, 0, 0, err
+	}
+	return float64(att.Roll) / 10, float64(att.Pitch) / 10, float64(att.Yaw), nil
+}
+
+func decodeAttitude(fr *msp.MSPFrame) string {
+	roll, pitch, yaw, err := decodeAttitudeDegrees(fr)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("roll=%.1f° pitch=%.1f° yaw=%.0f°", roll, pitch, yaw)
+}
+
+// updateAttitude stores the attitude in a MSP_ATTITUDE frame, so it's
+// returned by Attitude().
+func (f *FC) updateAttitude(fr *msp.MSPFrame) (roll, pitch, yaw float64, err error) {
+	roll, pitch, yaw, err = decodeAttitudeDegrees(fr)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	f.attitudeMu.Lock()
+	f.attitude = attitude{roll: roll, pitch: pitch, yaw: yaw}
+	f.attitudeMu.Unlock()
+	return roll, pitch, yaw, nil
+}
+
+// Attitude returns the last attitude received from the board in
+// degrees. Yaw is the heading (0-359). It's updated every time
+// MSP_ATTITUDE is received, see FCOptions.AttitudePollInterval. If
+// no attitude has been received yet, all the values are zero.
+func (f *FC) Attitude() (roll, pitch, yaw float64) {
+	f.attitudeMu.Lock()
+	defer f.attitudeMu.Unlock()
+	return f.attitude.roll, f.attitude.pitch, f.attitude.yaw
+}
+
+// readAttitude requests the attitude from the board
+func (f *FC) readAttitude() (roll, pitch, yaw float64, err error) {
+	fr, err := f.request(msp.MspAttitude)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return f.updateAttitude(fr)
+}
+
+// pollAttitude requests the attitude every interval, so Attitude()
+// stays up to date, until ctx is cancelled.
+func (f *FC) pollAttitude(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if f.port() == nil || f.isPassthrough() {
+			continue
+		}
+		f.readAttitude()
+	}
+}
@@ -0,0 +1,56 @@
+package fc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+func TestDecodeCapturedAttitude(t *testing.T) {
+	// MSP_ATTITUDE request and response, with the response split
+	// in two reads
+	file, err := os.Open("testdata/attitude.mspcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, _, err := readCapture(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload []byte
+	for _, ev := range events {
+		if ev.err != nil {
+			t.Fatal(ev.err)
+		}
+		if ev.direction == msp.CaptureReceived && ev.frame.Code == msp.MspAttitude {
+			payload = ev.frame.Payload
+		}
+	}
+	if payload == nil {
+		t.Fatal("no MSP_ATTITUDE response in capture")
+	}
+	// Decoding advances the payload position, so each decoder
+	// needs its own frame
+	frame := func() *msp.MSPFrame {
+		return &msp.MSPFrame{Code: msp.MspAttitude, Payload: payload}
+	}
+
+	if s, want := decodeAttitude(frame()), "roll=-12.3° pitch=45.6° yaw=271°"; s != want {
+		t.Errorf("decodeAttitude() = %q, want %q", s, want)
+	}
+	f := &FC{}
+	if _, _, _, err := f.updateAttitude(frame()); err != nil {
+		t.Fatal(err)
+	}
+	roll, pitch, yaw := f.Attitude()
+	if roll != -12.3 || pitch != 45.6 || yaw != 271 {
+		t.Errorf("Attitude() = %v, %v, %v, want -12.3, 45.6, 271", roll, pitch, yaw)
+	}
+
+	short := &msp.MSPFrame{Code: msp.MspAttitude, Payload: payload[:4]}
+	if _, _, _, err := decodeAttitudeDegrees(short); err == nil {
+		t.Error("decoding a short MSP_ATTITUDE payload succeeded")
+	}
+}
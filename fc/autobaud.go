@@ -0,0 +1,48 @@
+package fc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// autoBaudRates are the baud rates tried by connectAutoBaud, most
+// common first
+var autoBaudRates = []int{115200, 230400, 250000, 57600, 1000000}
+
+// autoBaudTimeout is the time to wait for a response at each rate
+const autoBaudTimeout = 300 * time.Millisecond
+
+// connectAutoBaud opens the port at each of autoBaudRates until the
+// board answers MSP_API_VERSION, keeping the rate for reconnections.
+// It's used when FCOptions.BaudRate is zero.
+func (f *FC) connectAutoBaud() (*msp.MSP, error) {
+	for _, rate := range autoBaudRates {
+		m, err := openSerialPort(f.opts.PortName, rate, f.opts.SerialFraming)
+		if err != nil {
+			return nil, err
+		}
+		// Without a read timeout, a silent board would block the
+		// request past autoBaudTimeout
+		if err := m.SetReadTimeout(autoBaudTimeout); err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.SetRequestTimeout(autoBaudTimeout)
+		if _, err := m.Request(msp.MspAPIVersion); err == nil {
+			f.opts.BaudRate = rate
+			m.SetRequestTimeout(0)
+			f.printf("Detected baud rate %dbps on %s\n", rate, f.opts.PortName)
+			return m, nil
+		}
+		m.Close()
+	}
+	return nil, fmt.Errorf("no response from %s at %v bps", f.opts.PortName, autoBaudRates)
+}
+
+// BaudRate returns the baud rate used for the port. If
+// FCOptions.BaudRate was zero, it's the detected one.
+func (f *FC) BaudRate() int {
+	return f.opts.BaudRate
+}
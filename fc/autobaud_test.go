@@ -0,0 +1,122 @@
+package fc
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// fakeSerialBoard answers MSP_API_VERSION at the given baud rate and
+// stays silent at the others. done is closed when the connection
+// opened for each rate is closed.
+type fakeSerialBoard struct {
+	rate   int
+	opened []int
+	done   map[int]chan struct{}
+}
+
+func (b *fakeSerialBoard) open(name string, rate int, framing msp.SerialFraming) (*msp.MSP, error) {
+	local, remote := net.Pipe()
+	b.opened = append(b.opened, rate)
+	done := make(chan struct{})
+	b.done[rate] = done
+	go func() {
+		defer close(done)
+		board := msp.NewWithConn(remote)
+		for {
+			fr, err := board.ReadFrame()
+			if err != nil {
+				return
+			}
+			if rate == b.rate && fr.Code == msp.MspAPIVersion {
+				board.WriteResponse(msp.MspAPIVersion, uint8(0), uint8(2), uint8(4))
+			}
+		}
+	}()
+	return msp.NewWithConn(local), nil
+}
+
+func withFakeSerialBoard(rate int, fn func(b *fakeSerialBoard)) {
+	b := &fakeSerialBoard{rate: rate, done: make(map[int]chan struct{})}
+	prev := openSerialPort
+	openSerialPort = b.open
+	defer func() { openSerialPort = prev }()
+	fn(b)
+}
+
+func TestConnectAutoBaud(t *testing.T) {
+	withFakeSerialBoard(250000, func(b *fakeSerialBoard) {
+		f := &FC{opts: FCOptions{PortName: "/dev/ttyACM0", Stdout: ioutil.Discard}}
+		start := time.Now()
+		m, err := f.connectAutoBaud()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		if want := []int{115200, 230400, 250000}; !reflect.DeepEqual(b.opened, want) {
+			t.Errorf("opened the port at %v, want %v", b.opened, want)
+		}
+		// The silent rates must time out
+		if elapsed := time.Since(start); elapsed < 2*autoBaudTimeout {
+			t.Errorf("detection took %v, want at least %v", elapsed, 2*autoBaudTimeout)
+		}
+		if rate := f.BaudRate(); rate != 250000 {
+			t.Errorf("BaudRate() = %d, want 250000", rate)
+		}
+		for _, rate := range []int{115200, 230400} {
+			select {
+			case <-b.done[rate]:
+			case <-time.After(time.Second):
+				t.Errorf("port opened at %d wasn't closed", rate)
+			}
+		}
+	})
+}
+
+func TestConnectAutoBaudNoResponse(t *testing.T) {
+	withFakeSerialBoard(0, func(b *fakeSerialBoard) {
+		f := &FC{opts: FCOptions{PortName: "/dev/ttyACM0", Stdout: ioutil.Discard}}
+		if _, err := f.connectAutoBaud(); err == nil {
+			t.Fatal("connectAutoBaud() succeeded without a board answering")
+		}
+		if !reflect.DeepEqual(b.opened, autoBaudRates) {
+			t.Errorf("opened the port at %v, want %v", b.opened, autoBaudRates)
+		}
+		if rate := f.BaudRate(); rate != 0 {
+			t.Errorf("BaudRate() = %d, want 0", rate)
+		}
+	})
+}
+
+// closeRecorder is a connection which doesn't support read timeouts
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Read(p []byte) (int, error)  { return 0, nil }
+func (c *closeRecorder) Write(p []byte) (int, error) { return len(p), nil }
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestOpenPortClosesOnError(t *testing.T) {
+	conn := &closeRecorder{}
+	prev := openSerialPort
+	openSerialPort = func(name string, rate int, framing msp.SerialFraming) (*msp.MSP, error) {
+		return msp.NewWithConn(conn), nil
+	}
+	defer func() { openSerialPort = prev }()
+
+	f := &FC{opts: FCOptions{PortName: "/dev/ttyACM0", BaudRate: 115200, Stdout: ioutil.Discard}}
+	if _, err := f.openPort(); err == nil {
+		t.Fatal("openPort() succeeded with a port not supporting read timeouts")
+	}
+	if !conn.closed {
+		t.Error("port wasn't closed after failing to set its read timeout")
+	}
+}
@@ -0,0 +1,132 @@
+package fc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// BatteryStatus is the battery state reported by the firmware, see
+// batteryState_e in BF.
+type BatteryStatus uint8
+
+const (
+	BatteryOK BatteryStatus = iota
+	BatteryWarning
+	BatteryCritical
+	BatteryNotPresent
+	BatteryInit
+)
+
+func (s BatteryStatus) String() string {
+	switch s {
+	case BatteryOK:
+		return "OK"
+	case BatteryWarning:
+		return "WARNING"
+	case BatteryCritical:
+		return "CRITICAL"
+	case BatteryNotPresent:
+		return "NOT PRESENT"
+	case BatteryInit:
+		return "INIT"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+}
+
+// BatteryState contains the battery information reported by
+// MSP_BATTERY_STATE
+type BatteryState struct {
+	CellCount uint8
+	Capacity  uint16  // mAh
+	Voltage   float64 // V
+	MAhDrawn  uint16
+	Current   float64 // A
+	Status    BatteryStatus
+}
+
+// CellVoltage returns the average voltage per cell, or zero if
+// the cell count is unknown.
+func (b *BatteryState) CellVoltage() float64 {
+	if b.CellCount == 0 {
+		return 0
+	}
+	return b.Voltage / float64(b.CellCount)
+}
+
+const batteryMonitorInterval = time.Second
+
+var errBatteryStateUnsupported = errors.New("battery state is only supported by Betaflight")
+
+// BatteryState returns the battery state, as reported by MSP_BATTERY_STATE
+func (f *FC) BatteryState() (*BatteryState, error) {
+	if f.Info().Variant != "BTFL" {
+		return nil, errBatteryStateUnsupported
+	}
+	fr, err := f.request(msp.MspBatteryState)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		CellCount     uint8
+		Capacity      uint16
+		LegacyVoltage uint8 // 0.1V
+		MAhDrawn      uint16
+		Amperage      uint16 // 0.01A
+		Status        uint8
+	}
+	if err := fr.Read(&data); err != nil {
+		return nil, err
+	}
+	b := &BatteryState{
+		CellCount: data.CellCount,
+		Capacity:  data.Capacity,
+		Voltage:   float64(data.LegacyVoltage) / 10,
+		MAhDrawn:  data.MAhDrawn,
+		Current:   float64(data.Amperage) / 100,
+		Status:    BatteryStatus(data.Status),
+	}
+	// Newer versions append the voltage with more precision
+	var voltage uint16
+	if err := fr.Read(&voltage); err == nil {
+		b.Voltage = float64(voltage) / 100
+	}
+	return b, nil
+}
+
+// monitorBattery polls the battery state and prints an alert when
+// the cell voltage drops below FCOptions.LowCellVoltage or when the
+// firmware reports a battery warning, until ctx is cancelled.
+func (f *FC) monitorBattery(ctx context.Context) {
+	ticker := time.NewTicker(batteryMonitorInterval)
+	defer ticker.Stop()
+	var low bool
+	var lastStatus BatteryStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if f.port() == nil || f.isPassthrough() || f.Info().Variant != "BTFL" {
+			continue
+		}
+		b, err := f.BatteryState()
+		if err != nil || b.Status == BatteryNotPresent {
+			continue
+		}
+		// Only alert when crossing the threshold or the status changes
+		isLow := b.CellCount > 0 && b.CellVoltage() < f.opts.LowCellVoltage
+		if isLow && !low {
+			f.notifyf(NotifyBattery, "Battery voltage is low: %.2fV (%.2fV per cell)\n", b.Voltage, b.CellVoltage())
+		}
+		low = isLow
+		if b.Status != lastStatus && (b.Status == BatteryWarning || b.Status == BatteryCritical) {
+			f.notifyf(NotifyBattery, "Battery status is %s: %.2fV\n", b.Status, b.Voltage)
+		}
+		lastStatus = b.Status
+	}
+}
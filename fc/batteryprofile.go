@@ -0,0 +1,86 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Setting stored per battery profile, used for retrieving the
+// current profile and the number of them
+const batteryProfileSetting = "bat_cells"
+
+var errBatteryProfilesUnsupported = errors.New("battery profiles are only supported by INAV 2.0+")
+
+// settingInfo returns the setting with the given name
+func (f *FC) settingInfo(name string) (*Setting, error) {
+	fr, err := f.requestV2(msp.Msp2CommonSettingInfo, append([]byte(name), 0))
+	if _, ok := err.(rejectedError); ok {
+		return nil, fmt.Errorf("unknown setting %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSetting(fr)
+}
+
+// BatteryProfile returns the index of the active battery profile and
+// the number of them.
+func (f *FC) BatteryProfile() (index uint8, count uint8, err error) {
+	if info := f.Info(); info.Variant != "INAV" || !info.atLeast(2, 0) {
+		return 0, 0, errBatteryProfilesUnsupported
+	}
+	s, err := f.settingInfo(batteryProfileSetting)
+	if err != nil {
+		return 0, 0, err
+	}
+	if s.ProfileCount == 0 {
+		return 0, 0, fmt.Errorf("%s is not stored per battery profile", batteryProfileSetting)
+	}
+	return s.ProfileIndex, s.ProfileCount, nil
+}
+
+// SelectBatteryProfile switches to the battery profile with the given
+// index, starting at zero. The board writes the EEPROM after switching.
+func (f *FC) SelectBatteryProfile(index uint8) error {
+	_, count, err := f.BatteryProfile()
+	if err != nil {
+		return err
+	}
+	if index >= count {
+		return fmt.Errorf("invalid battery profile %d, board has %d", index+1, count)
+	}
+	if _, err := f.requestV2(msp.Msp2INAVSelectBatteryProfile, index); err != nil {
+		return err
+	}
+	current, _, err := f.BatteryProfile()
+	if err != nil {
+		return err
+	}
+	if current != index {
+		return fmt.Errorf("board is still using battery profile %d", current+1)
+	}
+	return nil
+}
+
+// PrintBattery prints the battery state (BF) or the active battery
+// profile (INAV).
+func (f *FC) PrintBattery() error {
+	switch f.Info().Variant {
+	case "INAV":
+		index, count, err := f.BatteryProfile()
+		if err != nil {
+			return err
+		}
+		f.printf("Battery profile %d of %d\n", index+1, count)
+	default:
+		b, err := f.BatteryState()
+		if err != nil {
+			return err
+		}
+		f.printf("Battery %s: %.2fV (%d cells, %.2fV per cell), %.2fA, %d/%dmAh drawn\n",
+			b.Status, b.Voltage, b.CellCount, b.CellVoltage(), b.Current, b.MAhDrawn, b.Capacity)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+	"github.com/fiam/msp-tool/rx"
+)
+
+const (
+	// Permanent ID of the BEEPER mode, the same in BF and INAV
+	beeperModeID = 13
+	beepDuration = time.Second
+	// Mode range steps go from 900 to 2100 in 25us increments
+	modeRangeStepMin   = 900
+	modeRangeStepWidth = 25
+)
+
+var errBeeperConfigUnsupported = errors.New("beeper configuration is only available via MSP in Betaflight")
+
+// beeperConditions are the conditions that can make the beeper sound
+// in BF, by bit in the MSP_BEEPER_CONFIG mask.
+var beeperConditions = []string{
+	"GYRO_CALIBRATED",
+	"RX_LOST",
+	"RX_LOST_LANDING",
+	"DISARMING",
+	"ARMING",
+	"ARMING_GPS_FIX",
+	"BAT_CRIT_LOW",
+	"BAT_LOW",
+	"GPS_STATUS",
+	"RX_SET",
+	"ACC_CALIBRATION",
+	"ACC_CALIBRATION_FAIL",
+	"READY_BEEP",
+	"MULTI_BEEPS",
+	"DISARM_REPEAT",
+	"ARMED",
+	"SYSTEM_INIT",
+	"USB",
+	"BLACKBOX_ERASE",
+	"CRASH_FLIP",
+	"CAM_CONNECTION_OPEN",
+	"CAM_CONNECTION_CLOSE",
+	"RC_SMOOTHING_INIT_FAIL",
+}
+
+// BeeperConfig is the beeper configuration reported by the board
+type BeeperConfig struct {
+	// Conditions which make the beeper sound
+	Enabled []string
+	// Conditions which have been turned off
+	Disabled []string
+}
+
+// BeeperConfig returns the beeper configuration. It's only available
+// in BF.
+func (f *FC) BeeperConfig() (*BeeperConfig, error) {
+	if f.Info().Variant != "BTFL" {
+		return nil, errBeeperConfigUnsupported
+	}
+	fr, err := f.request(msp.MspBeeperConfig)
+	if err != nil {
+		return nil, err
+	}
+	var offFlags uint32
+	if err := fr.Read(&offFlags); err != nil {
+		return nil, err
+	}
+	return decodeBeeperConfig(offFlags), nil
+}
+
+func decodeBeeperConfig(offFlags uint32) *BeeperConfig {
+	var c BeeperConfig
+	for ii, name := range beeperConditions {
+		if offFlags&(1<<uint(ii)) != 0 {
+			c.Disabled = append(c.Disabled, name)
+		} else {
+			c.Enabled = append(c.Enabled, name)
+		}
+	}
+	return &c
+}
+
+// PrintBeeperConfig prints the beeper conditions enabled in the board
+func (f *FC) PrintBeeperConfig() error {
+	c, err := f.BeeperConfig()
+	if err != nil {
+		return err
+	}
+	f.printf("Beeper enabled for: %s\n", formatConditions(c.Enabled))
+	f.printf("Beeper disabled for: %s\n", formatConditions(c.Disabled))
+	return nil
+}
+
+func formatConditions(conditions []string) string {
+	if len(conditions) == 0 {
+		return "none"
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// beeperChannel returns the RC channel (starting at 1) and the value
+// which activate the BEEPER mode, as configured in the modes tab.
+func (f *FC) beeperChannel() (int, uint16, error) {
+	fr, err := f.request(msp.MspModeRanges)
+	if err != nil {
+		return 0, 0, err
+	}
+	for fr.BytesRemaining() >= 4 {
+		var r struct {
+			ModeID    uint8
+			AuxIndex  uint8
+			StartStep uint8
+			EndStep   uint8
+		}
+		if err := fr.Read(&r); err != nil {
+			return 0, 0, err
+		}
+		if r.ModeID == beeperModeID && r.EndStep > r.StartStep {
+			mid := (int(r.StartStep) + int(r.EndStep)) / 2
+			return int(r.AuxIndex) + 5, uint16(modeRangeStepMin + mid*modeRangeStepWidth), nil
+		}
+	}
+	return 0, 0, errors.New("the BEEPER mode is not assigned to any AUX channel, configure it in the modes tab")
+}
+
+// Beep makes the board beep for a moment by activating the BEEPER
+// mode via RX simulation, which must be active. The mode must be
+// assigned to an AUX channel. Boards without a beeper accept the
+// mode but stay silent, which can't be detected.
+func (f *FC) Beep() error {
+	if !f.IsSimulatingRX() {
+		return errors.New("RX simulation must be active to beep")
+	}
+	ch, value, err := f.beeperChannel()
+	if err != nil {
+		return err
+	}
+	prev := f.sticks.Channel(ch)
+	if err := f.sticks.SetChannel(ch, value); err != nil {
+		return err
+	}
+	f.printf("Beeping via %s=%d\n", f.sticks.ChannelName(ch), value)
+	time.Sleep(beepDuration)
+	if prev == 0 {
+		prev = rx.RxLow
+	}
+	if err := f.sticks.SetChannel(ch, prev); err != nil {
+		return fmt.Errorf("could not restore %s: %v", f.sticks.ChannelName(ch), err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package fc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Size of the MSPv1 header and checksum
+const mspV1Overhead = 6
+
+// BenchmarkResult contains the results of FC.Benchmark
+type BenchmarkResult struct {
+	Duration   time.Duration
+	Requests   int
+	Timeouts   int
+	Bytes      int // Received bytes, including framing
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	AvgLatency time.Duration
+	// Frames received during the benchmark that were not responses,
+	// e.g. debug messages when DEBUG_TRACE is enabled. They take up
+	// bandwidth, lowering the results.
+	OtherFrames uint64
+}
+
+// FramesPerSecond returns the number of responses received per second
+func (r *BenchmarkResult) FramesPerSecond() float64 {
+	return float64(r.Requests-r.Timeouts) / r.Duration.Seconds()
+}
+
+// BytesPerSecond returns the number of bytes received per second
+func (r *BenchmarkResult) BytesPerSecond() float64 {
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Benchmark measures the link throughput by requesting MSP_BOXNAMES,
+// which has a relatively large response, as fast as possible during
+// the given duration. Requests are sent one at a time, so the results
+// include the latency of each round trip.
+func (f *FC) Benchmark(duration time.Duration) (*BenchmarkResult, error) {
+	if f.port() == nil {
+		return nil, errNotConnected
+	}
+	res := &BenchmarkResult{}
+	var responses uint64
+	var total time.Duration
+	framesBefore := f.Stats().Frames
+	start := time.Now()
+	for time.Since(start) < duration {
+		reqStart := time.Now()
+		fr, err := f.request(msp.MspBoxNames)
+		res.Requests++
+		if err != nil {
+			if f.port() == nil {
+				return nil, err
+			}
+			res.Timeouts++
+			continue
+		}
+		latency := time.Since(reqStart)
+		responses++
+		total += latency
+		if res.MinLatency == 0 || latency < res.MinLatency {
+			res.MinLatency = latency
+		}
+		if latency > res.MaxLatency {
+			res.MaxLatency = latency
+		}
+		res.Bytes += len(fr.Payload) + mspV1Overhead
+	}
+	res.Duration = time.Since(start)
+	if responses == 0 {
+		return nil, errors.New("the board didn't respond to any request")
+	}
+	res.AvgLatency = total / time.Duration(responses)
+	if frames := f.Stats().Frames - framesBefore; frames > responses {
+		res.OtherFrames = frames - responses
+	}
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package fc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BuildSystem describes how to build the firmware in a source tree
+// and where to find the resulting binaries.
+type BuildSystem struct {
+	Name string
+	// Variant is the firmware built by it, as in Info.Variant
+	Variant string
+	// Args are passed to make, with TARGET set in the environment
+	Args []string
+	// BinaryDir is the directory with the binaries, relative to the
+	// source tree.
+	BinaryDir string
+	// BinaryPrefix is the beginning of the binary names, which end
+	// with the target name.
+	BinaryPrefix string
+}
+
+// Build systems for the supported firmwares
+var (
+	INAVBuildSystem = &BuildSystem{
+		Name:         "inav",
+		Variant:      "INAV",
+		Args:         []string{"binary"},
+		BinaryDir:    "obj",
+		BinaryPrefix: "inav_",
+	}
+	BetaflightBuildSystem = &BuildSystem{
+		Name:         "betaflight",
+		Variant:      "BTFL",
+		Args:         []string{"binary"},
+		BinaryDir:    "obj",
+		BinaryPrefix: "betaflight_",
+	}
+)
+
+var buildSystems = []*BuildSystem{INAVBuildSystem, BetaflightBuildSystem}
+
+// genericBuildSystem is used for finding existing binaries when the
+// source tree can't be identified.
+var genericBuildSystem = &BuildSystem{Name: "generic", BinaryDir: "obj"}
+
+// ParseBuildSystem returns the build system with the given name, or
+// nil for "auto" or an empty name, meaning it should be detected
+// from the source tree.
+func ParseBuildSystem(name string) (*BuildSystem, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		return nil, nil
+	case "bf", "btfl":
+		return BetaflightBuildSystem, nil
+	}
+	var names []string
+	for _, bs := range buildSystems {
+		if strings.EqualFold(bs.Name, name) {
+			return bs, nil
+		}
+		names = append(names, bs.Name)
+	}
+	return nil, fmt.Errorf("unknown build system %q, must be auto, %s", name, strings.Join(names, ", "))
+}
+
+// DetectBuildSystem returns the build system for the source tree at
+// srcDir, see DetectSourceTree.
+func DetectBuildSystem(srcDir string) (*BuildSystem, error) {
+	variant, err := DetectSourceTree(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, bs := range buildSystems {
+		if bs.Variant == variant {
+			return bs, nil
+		}
+	}
+	return nil, &SourceTreeError{Dir: srcDir, Reason: fmt.Sprintf("no build system for %s", variant)}
+}
+
+// command returns the command which builds the given target
+func (bs *BuildSystem) command(srcDir string, targetName string) *exec.Cmd {
+	cmd := exec.Command("make", bs.Args...)
+	cmd.Env = append(os.Environ(), "TARGET="+targetName)
+	cmd.Dir = srcDir
+	return cmd
+}
+
+// findBinary returns the most recent binary for the given target
+func (bs *BuildSystem) findBinary(srcDir string, targetName string) (string, os.FileInfo, error) {
+	dir := filepath.Join(srcDir, bs.BinaryDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	var binary os.FileInfo
+	for _, f := range files {
+		name := f.Name()
+		if filepath.Ext(name) != ".bin" || !strings.HasPrefix(name, bs.BinaryPrefix) {
+			continue
+		}
+		// Binaries end with the target name
+		if strings.HasSuffix(strings.TrimSuffix(name, ".bin"), targetName) {
+			if binary == nil || binary.ModTime().Before(f.ModTime()) {
+				binary = f
+			}
+		}
+	}
+	if binary == nil {
+		return "", nil, fmt.Errorf("could not find binary for target %s in %s", targetName, dir)
+	}
+	return filepath.Join(dir, binary.Name()), binary, nil
+}
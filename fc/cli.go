@@ -0,0 +1,182 @@
+package fc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// cliPrompt is printed by the CLI when it's ready for a command
+	cliPrompt = "\n# "
+	// cliTimeout is the maximum time to wait for the CLI prompt
+	cliTimeout = 5 * time.Second
+)
+
+// CLIRejection is a line rejected by the CLI while replaying a file
+type CLIRejection struct {
+	Line     int
+	Command  string
+	Response string
+}
+
+func (r *CLIRejection) String() string {
+	return fmt.Sprintf("line %d: %s: %s", r.Line, r.Command, r.Response)
+}
+
+// isPassthrough returns true iff the port is talking a protocol other
+// than MSP (the 4-way interface or the CLI), so StartUpdating must not
+// read from it.
+func (f *FC) isPassthrough() bool {
+	f.modeMu.Lock()
+	defer f.modeMu.Unlock()
+	return f.in4WayMode || f.inCLI
+}
+
+func (f *FC) setCLIMode(inCLI bool) {
+	f.modeMu.Lock()
+	f.inCLI = inCLI
+	f.modeMu.Unlock()
+}
+
+// takeCLIPending returns true if enterCLI() is waiting for the reader
+// to stop, clearing the flag.
+func (f *FC) takeCLIPending() bool {
+	f.modeMu.Lock()
+	defer f.modeMu.Unlock()
+	pending := f.cliPending
+	f.cliPending = false
+	return pending
+}
+
+// enterCLI stops StartUpdating from reading the port and switches the
+// board to its CLI. Since the reader might be blocked waiting for a
+// frame, a request is sent first and the reader stops right after
+// receiving its response.
+func (f *FC) enterCLI() (*msp.MSP, error) {
+	if f.isPassthrough() {
+		return nil, errors.New("port is not in MSP mode")
+	}
+	f.modeMu.Lock()
+	f.cliPending = true
+	f.modeMu.Unlock()
+	if _, err := f.request(msp.MspAPIVersion); err != nil {
+		f.takeCLIPending()
+		return nil, err
+	}
+	m := f.port()
+	if m == nil {
+		f.setCLIMode(false)
+		return nil, errNotConnected
+	}
+	if _, err := m.Write([]byte{'#'}); err != nil {
+		f.exitCLI(m)
+		return nil, err
+	}
+	if _, err := f.readCLIResponse(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readCLIResponse reads from the CLI until the prompt is printed,
+// returning the output before it.
+func (f *FC) readCLIResponse(m *msp.MSP) (string, error) {
+	type result struct {
+		s   string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var buf bytes.Buffer
+		b := make([]byte, 1)
+		for {
+			if _, err := m.Read(b); err != nil {
+				if err == msp.ErrReadTimeout {
+					// Some commands take a while, cliTimeout
+					// applies instead
+					continue
+				}
+				done <- result{err: err}
+				return
+			}
+			buf.WriteByte(b[0])
+			if bytes.HasSuffix(buf.Bytes(), []byte(cliPrompt)) {
+				s := strings.TrimSuffix(buf.String(), cliPrompt)
+				done <- result{s: strings.Replace(s, "\r", "", -1)}
+				return
+			}
+		}
+	}()
+	select {
+	case r := <-done:
+		return r.s, r.err
+	case <-time.After(cliTimeout):
+		// Closing the port unblocks the goroutine and makes
+		// StartUpdating reconnect to the board.
+		f.exitCLI(m)
+		return "", errors.New("timed out waiting for the CLI prompt")
+	}
+}
+
+// exitCLI closes the port, which makes the board leave the CLI and
+// StartUpdating reconnect to it.
+func (f *FC) exitCLI(m *msp.MSP) {
+	f.closePort(m)
+	f.setCLIMode(false)
+}
+
+// isCLIError returns true iff the CLI response indicates an error
+func isCLIError(response string) bool {
+	r := strings.ToLower(response)
+	for _, s := range []string{"error", "invalid", "unknown command"} {
+		if strings.Contains(r, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplayCLI sends the commands in a CLI diff or dump, as produced by
+// the "diff" and "dump" CLI commands, to the board's CLI one at a time,
+// waiting for the prompt after each one. Then, the configuration is
+// saved, which reboots the board. Comments and any save or exit
+// commands in the input are skipped. The returned rejections contain
+// the commands the board reported as invalid.
+func (f *FC) ReplayCLI(config string) ([]*CLIRejection, error) {
+	m, err := f.enterCLI()
+	if err != nil {
+		return nil, err
+	}
+	var rejected []*CLIRejection
+	for ii, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "save" || line == "exit" {
+			continue
+		}
+		if _, err := m.Write([]byte(line + "\n")); err != nil {
+			f.exitCLI(m)
+			return rejected, err
+		}
+		response, err := f.readCLIResponse(m)
+		if err != nil {
+			return rejected, fmt.Errorf("line %d: %v", ii+1, err)
+		}
+		if isCLIError(response) {
+			rejected = append(rejected, &CLIRejection{
+				Line:     ii + 1,
+				Command:  line,
+				Response: strings.TrimSpace(response),
+			})
+		}
+	}
+	_, err = m.Write([]byte("save\n"))
+	// Give the board some time to receive the command
+	time.Sleep(100 * time.Millisecond)
+	f.exitCLI(m)
+	return rejected, err
+}
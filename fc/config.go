@@ -0,0 +1,187 @@
+package fc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// configSnapshotVersion is the version of the ConfigSnapshot schema.
+// It must be increased when making incompatible changes to it.
+const configSnapshotVersion = 1
+
+// ConfigSnapshot contains the settings of a board, captured with
+// FC.CaptureConfig(). It can be stored as JSON to compare other boards
+// against it.
+type ConfigSnapshot struct {
+	Version     int                   `json:"version"`
+	Variant     string                `json:"variant"`
+	FCVersion   string                `json:"fc_version"`
+	TargetName  string                `json:"target_name,omitempty"`
+	CraftName   string                `json:"craft_name"`
+	Features    FeatureMask           `json:"features"`
+	SerialPorts []msp.MSPSerialConfig `json:"serial_ports"`
+	RXMap       []uint8               `json:"rx_map"`
+	RXConfig    *msp.MSPRXConfig      `json:"rx_config"`
+}
+
+// Difference is a setting with different values in two snapshots.
+// Settings missing from one of them have an empty value.
+type Difference struct {
+	Category string
+	Setting  string
+	Value    string
+	Other    string
+}
+
+func (d Difference) String() string {
+	value := func(s string) string {
+		if s == "" {
+			return "<missing>"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s %s: %s => %s", d.Category, d.Setting, value(d.Value), value(d.Other))
+}
+
+// CaptureConfig reads the settings in ConfigSnapshot from the board
+func (f *FC) CaptureConfig() (*ConfigSnapshot, error) {
+	info := f.Info()
+	s := &ConfigSnapshot{
+		Version:    configSnapshotVersion,
+		Variant:    info.Variant,
+		FCVersion:  info.Version(),
+		TargetName: info.TargetName,
+		CraftName:  info.CraftName,
+	}
+	fr, err := f.request(msp.MspFeature)
+	if err != nil {
+		return nil, err
+	}
+	if s.Features, _, err = readFeatures(fr); err != nil {
+		return nil, err
+	}
+	if fr, err = f.request(msp.MspCFSerialConfig); err != nil {
+		return nil, err
+	}
+	if s.SerialPorts, err = readSerialConfigs(fr); err != nil {
+		return nil, err
+	}
+	if fr, err = f.request(msp.MspRXMap); err != nil {
+		return nil, err
+	}
+	s.RXMap = append([]uint8(nil), fr.Payload...)
+	if s.RXConfig, err = f.GetRXConfig(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadConfigSnapshot reads a snapshot stored with ConfigSnapshot.Save()
+func LoadConfigSnapshot(filename string) (*ConfigSnapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var s ConfigSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != configSnapshotVersion {
+		return nil, fmt.Errorf("unsupported config snapshot version %d", s.Version)
+	}
+	return &s, nil
+}
+
+// Save stores the snapshot as JSON in the given file
+func (s *ConfigSnapshot) Save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// settings returns the values in the snapshot, by category and name
+func (s *ConfigSnapshot) settings() map[string]map[string]string {
+	settings := map[string]map[string]string{
+		"board": {
+			"variant": s.Variant,
+			"version": s.FCVersion,
+			"target":  s.TargetName,
+			"craft":   s.CraftName,
+		},
+		"features":  {},
+		"serial":    {},
+		"rx_map":    {},
+		"rx_config": {},
+	}
+	featureBits := uint(32)
+	if s.Features>>32 != 0 {
+		featureBits = 64
+	}
+	for ii := uint(0); ii < featureBits; ii++ {
+		enabled := s.Features&(1<<ii) != 0
+		settings["features"][fmt.Sprintf("bit %02d", ii)] = fmt.Sprint(enabled)
+	}
+	for _, p := range s.SerialPorts {
+		v := reflect.ValueOf(p)
+		for ii := 1; ii < v.NumField(); ii++ {
+			name := fmt.Sprintf("port %d %s", p.Identifier, v.Type().Field(ii).Name)
+			settings["serial"][name] = fmt.Sprint(v.Field(ii).Interface())
+		}
+	}
+	for ii, ch := range s.RXMap {
+		settings["rx_map"][fmt.Sprintf("channel %d", ii+1)] = fmt.Sprint(ch)
+	}
+	if s.RXConfig != nil {
+		v := reflect.ValueOf(*s.RXConfig)
+		for ii := 0; ii < v.NumField(); ii++ {
+			settings["rx_config"][v.Type().Field(ii).Name] = fmt.Sprint(v.Field(ii).Interface())
+		}
+	}
+	return settings
+}
+
+// Diff returns the settings with different values in s and other,
+// sorted by category and name.
+func (s *ConfigSnapshot) Diff(other *ConfigSnapshot) []Difference {
+	a := s.settings()
+	b := other.settings()
+	var diffs []Difference
+	for category, values := range a {
+		for name, value := range values {
+			if otherValue := b[category][name]; value != otherValue {
+				diffs = append(diffs, Difference{category, name, value, otherValue})
+			}
+		}
+		// Settings only present in other
+		for name, otherValue := range b[category] {
+			if _, ok := values[name]; !ok {
+				diffs = append(diffs, Difference{category, name, "", otherValue})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Category != diffs[j].Category {
+			return diffs[i].Category < diffs[j].Category
+		}
+		return diffs[i].Setting < diffs[j].Setting
+	})
+	return diffs
+}
+
+// DiffConfig captures the configuration of the board and compares it
+// against other. In the returned differences, Value is the setting
+// in the board and Other the one in other.
+func (f *FC) DiffConfig(other ConfigSnapshot) ([]Difference, error) {
+	s, err := f.CaptureConfig()
+	if err != nil {
+		return nil, err
+	}
+	return s.Diff(&other), nil
+}
@@ -0,0 +1,22 @@
+package fc
+
+import "github.com/fiam/msp-tool/rx"
+
+// Controller is the interface implemented by FC. Code depending on it
+// rather than on *FC can be tested using the mock in the fctest package.
+type Controller interface {
+	Info() Info
+	WaitForInfo() Info
+	HasDetectedTargetName() bool
+	Reboot() error
+	Flash(srcDir string, targetName string) error
+	FlashExisting(srcDir string, targetName string) error
+	IsSimulatingRX() bool
+	ToggleRXSimulation() (enabled bool, err error)
+	RX() rx.RX
+	GetPIDs() error
+	SetPIDs(pids []uint8) error
+	Close() error
+}
+
+var _ Controller = (*FC)(nil)
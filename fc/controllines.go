@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package fc
+
+import (
+	"time"
+
+	"github.com/pkg/term"
+)
+
+// pulseControlLines asserts DTR and RTS on the given port for a moment
+// and then releases them, which makes some boards reset or enter their
+// bootloader. The serial library used for MSP can't control these lines.
+func pulseControlLines(portName string) error {
+	t, err := term.Open(portName)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	if err := t.SetDTR(true); err != nil {
+		return err
+	}
+	if err := t.SetRTS(true); err != nil {
+		return err
+	}
+	time.Sleep(controlLinesPulse)
+	if err := t.SetDTR(false); err != nil {
+		return err
+	}
+	return t.SetRTS(false)
+}
@@ -0,0 +1,7 @@
+package fc
+
+import "errors"
+
+func pulseControlLines(portName string) error {
+	return errors.New("toggling DTR/RTS is not supported on Windows")
+}
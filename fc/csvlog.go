@@ -0,0 +1,162 @@
+package fc
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	defaultCSVLogInterval = 100 * time.Millisecond
+	csvFlushInterval      = time.Second
+	// Maximum number of RC channels sent by the firmware
+	csvRCChannels = 18
+)
+
+var csvHeader = []string{
+	"time",
+	"roll", "pitch", "yaw",
+	"voltage", "mah_drawn", "rssi", "current",
+	"gps_fix", "gps_sats", "gps_lat", "gps_lon", "gps_alt", "gps_speed",
+}
+
+// csvAttitude returns roll and pitch in degrees and yaw as a heading
+func (f *FC) csvAttitude() ([]string, error) {
+	roll, pitch, yaw, err := f.readAttitude()
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		formatFloat(roll),
+		formatFloat(pitch),
+		strconv.Itoa(int(yaw)),
+	}, nil
+}
+
+// csvAnalog returns the voltage (V), consumed mAh, RSSI and current (A)
+func (f *FC) csvAnalog() ([]string, error) {
+	a, err := f.readAnalog()
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		formatFloat(a.Voltage),
+		strconv.Itoa(int(a.MAhDrawn)),
+		strconv.Itoa(int(a.RSSI)),
+		formatFloat(a.Current),
+	}, nil
+}
+
+// csvGPS returns the fix, satellites, latitude, longitude, altitude (m)
+// and ground speed (m/s).
+func (f *FC) csvGPS() ([]string, error) {
+	fr, err := f.request(msp.MspRawGPS)
+	if err != nil {
+		return nil, err
+	}
+	var gps struct {
+		Fix      uint8
+		NumSat   uint8
+		Lat      int32  // 1e-7 degrees
+		Lon      int32  // 1e-7 degrees
+		Altitude int16  // m
+		Speed    uint16 // cm/s
+	}
+	if err := fr.Read(&gps); err != nil {
+		return nil, err
+	}
+	return []string{
+		strconv.Itoa(int(gps.Fix)),
+		strconv.Itoa(int(gps.NumSat)),
+		strconv.FormatFloat(float64(gps.Lat)/1e7, 'f', 7, 64),
+		strconv.FormatFloat(float64(gps.Lon)/1e7, 'f', 7, 64),
+		strconv.Itoa(int(gps.Altitude)),
+		formatFloat(float64(gps.Speed) / 100),
+	}, nil
+}
+
+func (f *FC) csvRC() ([]string, error) {
+	channels, err := f.readRC()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, csvRCChannels)
+	for ii, v := range channels {
+		if ii < len(values) {
+			values[ii] = strconv.Itoa(int(v))
+		}
+	}
+	return values, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// csvRow returns a row with the current telemetry. Groups of fields
+// that the board doesn't provide are left empty.
+func (f *FC) csvRow(now time.Time) []string {
+	row := []string{now.Format(time.RFC3339Nano)}
+	groups := []struct {
+		size int
+		read func() ([]string, error)
+	}{
+		{3, f.csvAttitude},
+		{4, f.csvAnalog},
+		{6, f.csvGPS},
+		{csvRCChannels, f.csvRC},
+	}
+	for _, g := range groups {
+		values, err := g.read()
+		if err != nil {
+			values = make([]string, g.size)
+		}
+		row = append(row, values...)
+	}
+	return row
+}
+
+// logCSV writes a header and then a row with the telemetry to w every
+// FCOptions.CSVLogInterval, flushing it periodically. It returns when
+// writing fails or ctx is cancelled, flushing the pending rows.
+func (f *FC) logCSV(ctx context.Context, w io.Writer) {
+	interval := f.opts.CSVLogInterval
+	if interval <= 0 {
+		interval = defaultCSVLogInterval
+	}
+	cw := csv.NewWriter(w)
+	header := append([]string(nil), csvHeader...)
+	for ii := 1; ii <= csvRCChannels; ii++ {
+		header = append(header, fmt.Sprintf("rc%d", ii))
+	}
+	cw.Write(header)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastFlush time.Time
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			cw.Flush()
+			return
+		case now = <-ticker.C:
+		}
+		if f.port() == nil || f.isPassthrough() {
+			continue
+		}
+		cw.Write(f.csvRow(now))
+		if time.Since(lastFlush) >= csvFlushInterval {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				f.printf("Error writing CSV log, stopping it: %v\n", err)
+				return
+			}
+			lastFlush = time.Now()
+		}
+	}
+}
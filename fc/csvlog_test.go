@@ -0,0 +1,30 @@
+package fc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCSVStopsWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	f := &FC{opts: FCOptions{CSVLogInterval: time.Millisecond}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.logCSV(ctx, &buf)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logCSV() didn't return after cancelling its context")
+	}
+	// The header is flushed when stopping
+	if !strings.HasPrefix(buf.String(), "time,roll,pitch,yaw,") {
+		t.Errorf("CSV log = %q, want the header", buf.String())
+	}
+}
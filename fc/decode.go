@@ -0,0 +1,156 @@
+package fc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// checksumError is implemented by the errors returned by msp.MSP when
+// a frame with an invalid checksum is received.
+type checksumError interface {
+	Frame() *msp.MSPFrame
+}
+
+// truncatedError is implemented by the errors returned by msp.MSP when
+// a frame payload is shorter than its declared length.
+type truncatedError interface {
+	Partial() []byte
+}
+
+// oobError is implemented by the errors returned by msp.MSP when
+// a byte not belonging to a frame is received.
+type oobError interface {
+	OOBByte() byte
+}
+
+// rejectedError is implemented by the errors returned by msp.MSP when
+// the board answers with an error frame ('!' direction).
+type rejectedError interface {
+	RejectedCode() uint16
+}
+
+// frameDecoders return a short human readable representation of
+// the payload for the frames that have a known layout. They must
+// not panic on short payloads.
+var frameDecoders = map[uint16]func(fr *msp.MSPFrame) string{
+	msp.MspAPIVersion: func(fr *msp.MSPFrame) string {
+		var v struct{ Protocol, Major, Minor uint8 }
+		if fr.Read(&v) != nil {
+			return ""
+		}
+		return fmt.Sprintf("api=%d.%d protocol=%d", v.Major, v.Minor, v.Protocol)
+	},
+	msp.MspFCVersion: func(fr *msp.MSPFrame) string {
+		var v struct{ Major, Minor, Patch uint8 }
+		if fr.Read(&v) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	},
+	msp.MspFeature: func(fr *msp.MSPFrame) string {
+		features, width, err := readFeatures(fr)
+		if err != nil {
+			return ""
+		}
+		return features.format(width)
+	},
+	msp.MspRXMap: func(fr *msp.MSPFrame) string {
+		return fmt.Sprintf("%v", fr.Payload)
+	},
+	msp.MspUID: func(fr *msp.MSPFrame) string {
+		uid := make([]uint32, 3)
+		if fr.Read(uid) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%08x%08x%08x", uid[0], uid[1], uid[2])
+	},
+	msp.MspRawIMU:             decodeRawIMU,
+	msp.MspAttitude:           decodeAttitude,
+	msp.MspAnalog:             decodeAnalog,
+	msp.MspMotor:              decodeMotors,
+	msp.Msp2SensorRangefinder: decodeRangefinder,
+	msp.Msp2SensorOpticFlow:   decodeOpticFlow,
+}
+
+// isPrintable returns true iff b is non empty and only contains
+// printable ASCII characters, ignoring trailing NULs.
+func isPrintable(b []byte) bool {
+	b = []byte(strings.TrimRight(string(b), "\x00"))
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if (c < ' ' && c != '\r' && c != '\n' && c != '\t') || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// describeFrame returns a single line describing the given frame,
+// including its direction, code, name, checksum validity and raw
+// payload, followed by its decoded representation when available.
+func describeFrame(fr *msp.MSPFrame, checksumOK bool) string {
+	crc := "ok"
+	if !checksumOK {
+		crc = "bad"
+	}
+	line := fmt.Sprintf("%c v%d %d %s len=%d crc=%s payload=[% x]", fr.Direction, fr.Version, fr.Code,
+		msp.CommandName(fr.Code), len(fr.Payload), crc, fr.Payload)
+	var decoded string
+	if dec := frameDecoders[fr.Code]; dec != nil {
+		// Decode from a copy, so the frame read position
+		// is not modified.
+		decoded = dec(&msp.MSPFrame{Code: fr.Code, Payload: fr.Payload, Direction: fr.Direction, Version: fr.Version})
+	} else if isPrintable(fr.Payload) {
+		decoded = fmt.Sprintf("%q", strings.TrimRight(string(fr.Payload), "\x00"))
+	}
+	if decoded != "" {
+		line += " decoded=" + decoded
+	}
+	return line
+}
+
+// warnEcho discards a frame which is an echo of one we sent, warning
+// about the wiring once per connection.
+func (f *FC) warnEcho(fr *msp.MSPFrame) {
+	if f.opts.DecodeAll {
+		f.printf("%s (echo, discarded)\n", describeFrame(fr, true))
+	}
+	if f.echoWarned {
+		return
+	}
+	f.echoWarned = true
+	f.printf("WARNING: received our own %s request back. The line might be half-duplex or TX/RX might be shorted, check the wiring.\n", msp.CommandName(fr.Code))
+}
+
+// checkFrameVersion records the framing used by the board for each
+// response, warning once per command and connection if it doesn't
+// match the framing of our request.
+func (f *FC) checkFrameVersion(m *msp.MSP, fr *msp.MSPFrame) {
+	if fr.Direction != '>' {
+		return
+	}
+	f.countResponseVersion(fr.Code, fr.Version)
+	requested := m.RequestVersion(fr.Code)
+	if requested == 0 || requested == fr.Version || f.versionWarned[fr.Code] {
+		return
+	}
+	if f.versionWarned == nil {
+		f.versionWarned = make(map[uint16]bool)
+	}
+	f.versionWarned[fr.Code] = true
+	f.printf("WARNING: %s was requested with MSPv%d framing but the board answered with MSPv%d\n",
+		msp.CommandName(fr.Code), requested, fr.Version)
+}
+
+// printTruncated dumps the partial payload of a truncated frame if
+// verbose output is enabled
+func (f *FC) printTruncated(err truncatedError) {
+	if partial := err.Partial(); f.Verbose() && len(partial) > 0 {
+		f.printf("Received payload:\n%s", hex.Dump(partial))
+	}
+}
@@ -0,0 +1,291 @@
+package fc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dfuDevicePrefix     = "Found DFU: "
+	internalFlashMarker = "@Internal Flash"
+)
+
+// DFUDevice is a device (or rather, an alternate setting of it) in
+// DFU mode.
+type DFUDevice struct {
+	// ID uniquely identifies the device and alternate setting while
+	// it stays connected. For dfu-util, it's the --list line.
+	ID string
+	// VendorProduct is the USB vendor and product IDs, e.g. "0483:df11"
+	VendorProduct string
+	// Serial might be empty or "UNKNOWN" with some drivers
+	Serial string
+	// Path is the USB bus path, e.g. "20-1"
+	Path string
+	Alt  int
+	// Name is the alternate setting name, which describes the memory
+	// layout, e.g. "@Internal Flash  /0x08000000/04*016Kg,01*064Kg"
+	Name string
+}
+
+// IsInternalFlash returns true iff the device alternate setting
+// corresponds to the MCU internal flash.
+func (d DFUDevice) IsInternalFlash() bool {
+	return strings.HasPrefix(d.Name, internalFlashMarker)
+}
+
+// FlashOffset returns the start address of the internal flash, as
+// given by the alternate setting name.
+func (d DFUDevice) FlashOffset() (uint32, error) {
+	// The number of spaces after "Internal Flash" varies between MCUs
+	s := regexpFind(`Internal Flash\s+/(0[xX][[:xdigit:]]+)/`, d.Name)
+	if s == "" {
+		return 0, fmt.Errorf("could not determine flash offset from %q", d.Name)
+	}
+	offset, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid flash offset %q: %v", s, err)
+	}
+	return uint32(offset), nil
+}
+
+// DFUBackend lists and flashes devices in DFU mode
+type DFUBackend interface {
+	// List returns the devices currently in DFU mode
+	List() ([]DFUDevice, error)
+	// Download writes data to dev at the given offset, then makes
+	// the device leave DFU mode.
+	Download(dev DFUDevice, offset uint32, data []byte) error
+}
+
+// NewDFUUtilBackend returns a DFUBackend which uses the dfu-util
+// found in $PATH. Its output is written to stdout.
+func NewDFUUtilBackend(stdout io.Writer) (DFUBackend, error) {
+	path, err := exec.LookPath("dfu-util")
+	if err != nil {
+		return nil, err
+	}
+	return &dfuUtilBackend{path: path, stdout: stdout}, nil
+}
+
+type dfuUtilBackend struct {
+	path   string
+	stdout io.Writer
+}
+
+func (b *dfuUtilBackend) List() ([]DFUDevice, error) {
+	cmd := exec.Command(b.path, "--list")
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Run()
+	lines := strings.Split(buf.String(), "\n")
+	var devices []DFUDevice
+	for _, ll := range lines {
+		ll = strings.Trim(ll, "\n\r\t ")
+		if strings.HasPrefix(ll, dfuDevicePrefix) {
+			devices = append(devices, parseDFUUtilDevice(ll[len(dfuDevicePrefix):]))
+		}
+	}
+	return devices, nil
+}
+
+// parseDFUUtilDevice parses a line from dfu-util --list, which looks like:
+// [0483:df11] ver=2200, devnum=17, cfg=1, intf=0, path="20-1", alt=0, name="@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg", serial="3276365D3336"
+func parseDFUUtilDevice(line string) DFUDevice {
+	dev := DFUDevice{
+		ID:            line,
+		VendorProduct: regexpFind(`^\[([[:xdigit:]]{4}:[[:xdigit:]]{4})\]`, line),
+		Serial:        regexpFind(`serial="(.*?)"`, line),
+		Path:          regexpFind(`path="(.*?)"`, line),
+		Name:          regexpFind(`name="(.*?)"`, line),
+		Alt:           -1,
+	}
+	if alt, err := strconv.Atoi(regexpFind(`alt=(\d+)`, line)); err == nil {
+		dev.Alt = alt
+	}
+	return dev
+}
+
+// deviceArgs returns the dfu-util arguments for selecting the device.
+// The serial number is preferred but some drivers (e.g. on Windows)
+// report it as "UNKNOWN", so fall back to the path and then to the
+// vendor and product IDs.
+func (b *dfuUtilBackend) deviceArgs(dev DFUDevice) []string {
+	if dev.Serial != "" && dev.Serial != "UNKNOWN" {
+		return []string{"-S", dev.Serial}
+	}
+	if dev.Path != "" {
+		return []string{"-p", dev.Path}
+	}
+	if dev.VendorProduct != "" {
+		return []string{"-d", dev.VendorProduct}
+	}
+	return nil
+}
+
+func (b *dfuUtilBackend) Download(dev DFUDevice, offset uint32, data []byte) error {
+	deviceArgs := b.deviceArgs(dev)
+	if dev.Alt < 0 || deviceArgs == nil {
+		return fmt.Errorf("could not determine flash parameters from %q", dev.ID)
+	}
+	tmp, err := ioutil.TempFile("", "msp-tool-*.bin")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-a", strconv.Itoa(dev.Alt)}, deviceArgs...)
+	args = append(args, "-s", fmt.Sprintf("0x%08x:leave", offset), "-D", tmp.Name())
+	cmd := exec.Command(b.path, args...)
+	cmd.Stdout = b.stdout
+	cmd.Stderr = b.stdout
+	return cmd.Run()
+}
+
+// errNativeDFUNotImplemented is returned by the native DFU backend
+// until it's implemented.
+var errNativeDFUNotImplemented = errors.New("native DFU backend is not implemented yet, use dfu-util")
+
+// NewNativeDFUBackend returns a DFUBackend which talks to the devices
+// directly via libusb, without requiring dfu-util. It's not
+// implemented yet, so it always returns an error.
+func NewNativeDFUBackend() (DFUBackend, error) {
+	return nil, errNativeDFUNotImplemented
+}
+
+// dfuBackend returns the backend from the options, defaulting to
+// dfu-util.
+func (f *FC) dfuBackend() (DFUBackend, error) {
+	if f.opts.DFUBackend != nil {
+		return f.opts.DFUBackend, nil
+	}
+	return NewDFUUtilBackend(f.opts.Stdout)
+}
+
+// dfuEnter reboots the board into DFU mode and waits for it to show
+// up. Some boards ignore the first reboot command or need DTR/RTS
+// toggled, so it's retried a few times.
+func (f *FC) dfuEnter(backend DFUBackend) (DFUDevice, error) {
+	var err error
+	for ii := 0; ii < dfuAttempts; ii++ {
+		if ii > 0 {
+			f.printf("Board didn't enter DFU mode, retrying (%d/%d)...\n", ii+1, dfuAttempts)
+			if err := pulseControlLines(f.opts.PortName); err != nil {
+				f.printf("Could not toggle DTR/RTS: %v\n", err)
+			}
+		}
+		if err = f.dfuReboot(); err != nil {
+			if ii == 0 {
+				return DFUDevice{}, err
+			}
+			// The port might have gone away because the
+			// board is entering DFU, keep waiting.
+		}
+		var device DFUDevice
+		if device, err = f.dfuWait(backend, dfuWaitTimeout); err == nil {
+			return device, nil
+		}
+	}
+	return DFUDevice{}, err
+}
+
+// dfuWait waits for a flash device to show up in DFU mode. Some USB
+// stacks enumerate the board twice while it reboots, so the list of
+// devices must be the same in two consecutive polls and the most
+// recently appeared device is preferred.
+func (f *FC) dfuWait(backend DFUBackend, wait time.Duration) (DFUDevice, error) {
+	timeout := time.Now().Add(wait)
+	// Poll in which each device was first seen
+	firstSeen := make(map[string]int)
+	var prev []DFUDevice
+	for poll := 0; ; poll++ {
+		if timeout.Before(time.Now()) {
+			if runtime.GOOS == "windows" {
+				// Windows uses the STM32 driver by default, which
+				// dfu-util can't talk to.
+				return DFUDevice{}, fmt.Errorf("timed out while waiting for board in DFU mode, make sure the WinUSB driver is installed for it (e.g. using Zadig)")
+			}
+			return DFUDevice{}, fmt.Errorf("timed out while waiting for board in DFU mode")
+		}
+		devices, err := backend.List()
+		if err != nil {
+			return DFUDevice{}, err
+		}
+		var flash []DFUDevice
+		for _, dev := range devices {
+			if dev.IsInternalFlash() {
+				flash = append(flash, dev)
+				if _, ok := firstSeen[dev.ID]; !ok {
+					firstSeen[dev.ID] = poll
+				}
+			}
+		}
+		if len(flash) > 0 && sameDevices(flash, prev) {
+			newest := flash[0]
+			for _, dev := range flash[1:] {
+				if firstSeen[dev.ID] >= firstSeen[newest.ID] {
+					newest = dev
+				}
+			}
+			if len(flash) > 1 {
+				f.printf("Found %d DFU flash devices, using the most recent one\n", len(flash))
+			}
+			return newest, nil
+		}
+		prev = flash
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func sameDevices(a, b []DFUDevice) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ii := range a {
+		if a[ii].ID != b[ii].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// dfuFlash flashes the binary to the given device, as returned
+// by dfuWait()
+func (f *FC) dfuFlash(backend DFUBackend, device DFUDevice, binaryPath string) error {
+	offset, err := device.FlashOffset()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(binaryPath)
+	if err != nil {
+		return err
+	}
+	f.printf("Flashing %s via DFU to offset 0x%08x...\n", filepath.Base(binaryPath), offset)
+	return backend.Download(device, offset, data)
+}
+
+func regexpFind(pattern string, s string) string {
+	r := regexp.MustCompile(pattern)
+	m := r.FindStringSubmatch(s)
+	if len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,240 @@
+package fc
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDownload struct {
+	dev    DFUDevice
+	offset uint32
+	data   []byte
+}
+
+// fakeDFU is a DFUBackend which records the calls to it
+type fakeDFU struct {
+	mu          sync.Mutex
+	devices     []DFUDevice
+	listErr     error
+	downloadErr error
+	lists       int
+	downloads   []fakeDownload
+}
+
+func (d *fakeDFU) List() ([]DFUDevice, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lists++
+	if d.listErr != nil {
+		return nil, d.listErr
+	}
+	return d.devices, nil
+}
+
+func (d *fakeDFU) Download(dev DFUDevice, offset uint32, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.downloads = append(d.downloads, fakeDownload{dev: dev, offset: offset, data: data})
+	return d.downloadErr
+}
+
+func TestParseDFUUtilDevice(t *testing.T) {
+	line := `[0483:df11] ver=2200, devnum=17, cfg=1, intf=0, path="20-1", alt=0, name="@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg", serial="3276365D3336"`
+	dev := parseDFUUtilDevice(line)
+	want := DFUDevice{
+		ID:            line,
+		VendorProduct: "0483:df11",
+		Serial:        "3276365D3336",
+		Path:          "20-1",
+		Alt:           0,
+		Name:          "@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg",
+	}
+	if dev != want {
+		t.Errorf("parseDFUUtilDevice() = %+v, want %+v", dev, want)
+	}
+	if !dev.IsInternalFlash() {
+		t.Error("IsInternalFlash() = false, want true")
+	}
+	if offset, err := dev.FlashOffset(); err != nil || offset != 0x08000000 {
+		t.Errorf("FlashOffset() = 0x%08x, %v, want 0x08000000", offset, err)
+	}
+	option := DFUDevice{Name: "@Option Bytes  /0x1FFFC000/01*016 e"}
+	if _, err := option.FlashOffset(); err == nil {
+		t.Errorf("FlashOffset() for %q succeeded", option.Name)
+	}
+}
+
+func TestDFUFlash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msp-tool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := []byte{0xde, 0xad, 0xbe, 0xef}
+	binaryPath := filepath.Join(dir, "inav_2.0.0_TEST.bin")
+	if err := ioutil.WriteFile(binaryPath, binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+	device := DFUDevice{
+		ID:   "test",
+		Alt:  0,
+		Name: "@Internal Flash  /0x08000000/04*016Kg,01*064Kg",
+	}
+	option := DFUDevice{
+		ID:   "option",
+		Alt:  1,
+		Name: "@Option Bytes  /0x1FFFC000/01*016 e",
+	}
+	errDownload := errors.New("download failed")
+	errList := errors.New("list failed")
+
+	tests := []struct {
+		name        string
+		devices     []DFUDevice
+		listErr     error
+		downloadErr error
+		err         error
+		downloads   int
+	}{
+		{name: "ok", devices: []DFUDevice{option, device}, downloads: 1},
+		{name: "download error", devices: []DFUDevice{device}, downloadErr: errDownload, err: errDownload, downloads: 1},
+		{name: "list error", listErr: errList, err: errList},
+	}
+	for _, tt := range tests {
+		dfu := &fakeDFU{devices: tt.devices, listErr: tt.listErr, downloadErr: tt.downloadErr}
+		f := &FC{opts: FCOptions{Stdout: ioutil.Discard, DFUBackend: dfu}}
+		dev, err := f.dfuWait(dfu, time.Second)
+		if err == nil {
+			if dev.ID != device.ID {
+				t.Errorf("%s: dfuWait() = %q, want %q", tt.name, dev.ID, device.ID)
+			}
+			err = f.dfuFlash(dfu, dev, binaryPath)
+		}
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if len(dfu.downloads) != tt.downloads {
+			t.Errorf("%s: Download() called %d times, want %d", tt.name, len(dfu.downloads), tt.downloads)
+			continue
+		}
+		for _, d := range dfu.downloads {
+			if d.dev.ID != device.ID || d.offset != 0x08000000 || !bytes.Equal(d.data, binary) {
+				t.Errorf("%s: Download(%q, 0x%08x, % x), want Download(%q, 0x08000000, % x)",
+					tt.name, d.dev.ID, d.offset, d.data, device.ID, binary)
+			}
+		}
+	}
+}
+
+// listenRebootBoard returns a listener for a fake board connected via
+// TCP, which sends to the returned channel whenever it receives a
+// reboot into bootloader command.
+func listenRebootBoard(t *testing.T) (net.Listener, <-chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reboots := make(chan struct{}, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				for {
+					n, err := conn.Read(buf)
+					if bytes.IndexByte(buf[:n], 'R') >= 0 {
+						reboots <- struct{}{}
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l, reboots
+}
+
+func TestFlashExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msp-tool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := []byte{0xde, 0xad, 0xbe, 0xef}
+	if err := os.Mkdir(filepath.Join(dir, "obj"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "obj", "inav_2.0.0_TEST.bin"), binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+	device := DFUDevice{
+		ID:   "test",
+		Alt:  0,
+		Name: "@Internal Flash  /0x08000000/04*016Kg,01*064Kg",
+	}
+	errDownload := errors.New("download failed")
+	errList := errors.New("list failed")
+
+	tests := []struct {
+		name        string
+		devices     []DFUDevice
+		listErr     error
+		downloadErr error
+		err         error
+		downloads   int
+	}{
+		{name: "ok", devices: []DFUDevice{device}, downloads: 1},
+		{name: "download error", devices: []DFUDevice{device}, downloadErr: errDownload, err: errDownload, downloads: 1},
+		{name: "list error", listErr: errList, err: errList},
+	}
+	for _, tt := range tests {
+		l, reboots := listenRebootBoard(t)
+		dfu := &fakeDFU{devices: tt.devices, listErr: tt.listErr, downloadErr: tt.downloadErr}
+		f := &FC{opts: FCOptions{
+			PortName:   tcpPortPrefix + l.Addr().String(),
+			Stdout:     ioutil.Discard,
+			DFUBackend: dfu,
+		}}
+		f.reset()
+		err := f.FlashExisting(dir, "TEST")
+		l.Close()
+
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("%s: FlashExisting() = %v", tt.name, err)
+			}
+		} else if derr, ok := err.(*DFUError); !ok || derr.Err != tt.err {
+			t.Errorf("%s: FlashExisting() = %v, want DFUError{%v}", tt.name, err, tt.err)
+		}
+		select {
+		case <-reboots:
+		case <-time.After(time.Second):
+			t.Errorf("%s: board wasn't rebooted into the bootloader", tt.name)
+		}
+		if tt.listErr != nil && dfu.lists != dfuAttempts {
+			t.Errorf("%s: List() called %d times, want %d", tt.name, dfu.lists, dfuAttempts)
+		}
+		if len(dfu.downloads) != tt.downloads {
+			t.Errorf("%s: Download() called %d times, want %d", tt.name, len(dfu.downloads), tt.downloads)
+			continue
+		}
+		for _, d := range dfu.downloads {
+			if d.dev.ID != device.ID || d.offset != 0x08000000 || !bytes.Equal(d.data, binary) {
+				t.Errorf("%s: Download(%q, 0x%08x, % x), want Download(%q, 0x08000000, % x)",
+					tt.name, d.dev.ID, d.offset, d.data, device.ID, binary)
+			}
+		}
+	}
+}
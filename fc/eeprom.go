@@ -0,0 +1,21 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// SaveToEEPROM writes the current configuration to the EEPROM via
+// MSP_EEPROM_WRITE and waits for the board to confirm it. Boards refuse
+// to write the EEPROM while armed, so an error is returned in that case.
+func (f *FC) SaveToEEPROM() error {
+	if st, err := f.readStatus(); err == nil && st.Armed() {
+		return errors.New("board is armed, disarm it to write the EEPROM")
+	}
+	if _, err := f.request(msp.MspEepromWrite); err != nil {
+		return fmt.Errorf("could not confirm EEPROM write: %v", err)
+	}
+	return nil
+}
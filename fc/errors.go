@@ -0,0 +1,56 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoIdentity is returned when the board identity is required but
+// the board didn't report it.
+var ErrNoIdentity = errors.New("board didn't report its identity")
+
+// ErrNoFrames is returned by Probe when no valid frames arrive
+// before the timeout.
+var ErrNoFrames = errors.New("no valid MSP frames received")
+
+// BuildError is returned by FC.Flash when building the firmware fails.
+type BuildError struct {
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("building firmware: %v", e.Err)
+}
+
+// SourceTreeError is returned by FC.Flash when the source directory
+// doesn't look like a firmware tree that can be built.
+type SourceTreeError struct {
+	Dir    string
+	Reason string
+}
+
+func (e *SourceTreeError) Error() string {
+	return fmt.Sprintf("%s doesn't look like an INAV/BF source tree: %s", e.Dir, e.Reason)
+}
+
+// DFUError is returned by FC.Flash when the firmware was built but
+// could not be flashed to the board via DFU.
+type DFUError struct {
+	Err error
+}
+
+func (e *DFUError) Error() string {
+	return fmt.Sprintf("flashing via DFU: %v", e.Err)
+}
+
+// IdentityMismatchError is returned by Info.Check when the board
+// identity doesn't match the expected one.
+type IdentityMismatchError struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("expecting %s %s, board is running %s", e.Field, e.Expected, e.Actual)
+}
@@ -1,17 +1,15 @@
 package fc
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fiam/msp-tool/msp"
@@ -19,10 +17,34 @@ import (
 )
 
 const (
-	dfuDevicePrefix     = "Found DFU: "
-	internalFlashMarker = "@Internal Flash  /"
+	// infoTimeout is the maximum time to wait for all the identity
+	// frames before printing the board info with whatever arrived.
+	infoTimeout = 2 * time.Second
+
+	// rebootAttempts is the number of times the port is reopened to
+	// send a reboot command, waiting rebootRetryDelay before the first
+	// retry and doubling it after each one.
+	rebootAttempts   = 3
+	rebootRetryDelay = 500 * time.Millisecond
+
+	// dfuAttempts is the number of times the board is rebooted into
+	// DFU, waiting up to dfuWaitTimeout for it to show up each time.
+	// Retries also toggle DTR/RTS for controlLinesPulse.
+	dfuAttempts       = 3
+	dfuWaitTimeout    = 10 * time.Second
+	controlLinesPulse = 100 * time.Millisecond
 )
 
+// infoCodes are the MSP commands whose responses form the board
+// identity printed on connection.
+var infoCodes = []uint16{
+	msp.MspFCVariant,
+	msp.MspFCVersion,
+	msp.MspBoardInfo,
+	msp.MspName,
+	msp.MspUID,
+}
+
 type PIDReceiver interface {
 	ReceivedPID(map[string]*Pid) error
 }
@@ -32,30 +54,252 @@ type Pid struct {
 	Value         []uint8
 }
 
+// Info contains the identity of the board, as reported via MSP.
+// Fields not provided by the firmware are left empty.
+type Info struct {
+	Variant      string
+	VersionMajor byte
+	VersionMinor byte
+	VersionPatch byte
+	BoardID      string
+	TargetName   string
+	CraftName    string
+	UID          string
+	// PIDLoopRate is the frequency of the PID loop in Hz,
+	// zero if unknown.
+	PIDLoopRate int
+}
+
 // FC represents a connection to the flight controller, which can
 // handle disconnections and reconnections on its on. Use NewFC()
 // to initialize an FC and then call FC.StartUpdating().
 type FC struct {
-	opts         FCOptions
+	// Nil while disconnected and replaced when reconnecting,
+	// see port() and closePort().
 	msp          *msp.MSP
+	mspMu        sync.Mutex
+	opts         FCOptions
 	variant      string
 	versionMajor byte
 	versionMinor byte
 	versionPatch byte
 	boardID      string
 	targetName   string
-	Features     uint32
+	craftName    string
+	uid          string
+	pidLoopRate  int
+	// MSP command used to retrieve the PID loop rate, which
+	// depends on the variant. Zero if not supported.
+	loopRateCode uint16
+	infoMu       sync.Mutex
+	infoReceived map[uint16]bool
+	infoPrinted  bool
+	infoTimer    *time.Timer
+	infoDone     chan struct{}
+	// Number of times MSP_FC_VARIANT was requested again
+	// because the board sent an invalid one.
+	variantRetries int
+	// Source revision of the last flashed firmware
+	flashedRevision string
+	// Settings read before flashing, compared once the board
+	// reconnects. See FCOptions.DiffConfigAfterFlash.
+	preFlashConfig *ConfigSnapshot
+	// Motor protocol read before flashing, compared once the
+	// board reconnects. Nil if it couldn't be read.
+	preFlashMotorProtocol *MotorProtocol
+	Features              FeatureMask
+	// Size of the MSP_FEATURE mask sent by the board, in bytes
+	featureWidth int
 	channelMap   []uint8
-	PidMap       map[string]*Pid
-	rxTicker     *time.Ticker
-	sticks       rx.RxSticks
+	// Number of RC channels used by the board, as reported
+	// by MSP_RC. Zero if unknown. Accessed atomically, see
+	// channelCount().
+	rcChannelCount int32
+	// Last channels received via MSP_RC, see RCChannels()
+	rcMu            sync.Mutex
+	rcChannels      []uint16
+	PidMap          map[string]*Pid
+	rxTicker        *time.Ticker
+	sticks          rx.RxSticks
+	rcMonitorTicker *time.Ticker
+	rxSourceMu      sync.Mutex
+	rxSource        rxSourceState
+	imuMonitorStop  chan struct{}
+	// See ToggleOverrunMonitor()
+	overrunMonitorStop chan struct{}
+	requestsMu         sync.Mutex
+	requests           []*pendingRequest
+	// See queueUnsolicited()
+	unsolicited chan *msp.MSPFrame
+	modeMu      sync.Mutex
+	in4WayMode  bool
+	inCLI       bool
+	cliPending  bool
+	// Whether we've warned about our frames being
+	// echoed back in this connection.
+	echoWarned bool
+	// Commands whose response used a different framing than
+	// the request, see checkFrameVersion().
+	versionWarned map[uint16]bool
+	// Accessed atomically, see SetVerbose()
+	verbose int32
+	// See observeArmed()
+	armedState int32
+	// Last attitude received, see Attitude()
+	attitudeMu sync.Mutex
+	attitude   attitude
+	// See ArmMotorTest()
+	motorTestMu sync.Mutex
+	motorTest   motorTestState
+	// Last MSP_CF_SERIAL_CONFIG received, see SerialConfig()
+	serialConfigMu sync.Mutex
+	serialConfig   []msp.MSPSerialConfig
+	// Last MSP_MOTOR received, see Motors()
+	motorsMu sync.Mutex
+	motors   [motorTestMotors]uint16
+	// Last MSP_ANALOG received, see Battery()
+	analogMu sync.Mutex
+	analog   AnalogState
+	// Last status received, see Status()
+	statusMu sync.Mutex
+	status   StatusInfo
+	// USB block devices present while connected and whether the
+	// board was rebooted into mass storage mode (accessed
+	// atomically). See inMSCMode().
+	usbDisks     map[string]bool
+	mscRequested int32
+	stats        stats
 }
 
 type FCOptions struct {
-	PortName         string
-	BaudRate         int
+	// PortName is the serial port or, for connecting over TCP
+	// (e.g. to SITL), a tcp://host:port address.
+	PortName string
+	// BaudRate is detected when connecting if zero, see FC.BaudRate()
+	BaudRate int
+	// SerialFraming defaults to 8N1 when empty
+	SerialFraming    msp.SerialFraming
 	Stdout           io.Writer
 	EnableDebugTrace bool
+	// DecodeAll prints every received frame, decoded when
+	// possible, before handling it.
+	DecodeAll bool
+	// ReadBufferSize is the size of the buffer used for reading
+	// from the port. If zero, msp.DefaultReadBufferSize is used.
+	ReadBufferSize int
+	// ReuseFrames avoids allocating memory for each received
+	// frame, see msp.MSP.SetReusePayloads().
+	ReuseFrames bool
+	// ChecksumErrorThreshold is the ratio (0-1) of frames with
+	// checksum errors over a 5s window which causes the port to be
+	// reopened. If zero, the port is never reopened because of
+	// checksum errors.
+	ChecksumErrorThreshold float64
+	// Bluetooth indicates that the port is a Bluetooth serial port,
+	// which uses a longer delay between reconnection attempts and a
+	// keepalive. Ports under /dev/rfcomm are always Bluetooth.
+	Bluetooth bool
+	// KeepaliveInterval is the idle time after which a request is
+	// sent to keep the link alive. If zero, keepalives are only sent
+	// for Bluetooth ports.
+	KeepaliveInterval time.Duration
+	// ReadTimeout is the time without receiving any data after which
+	// the port is checked for disconnection. If zero,
+	// defaultReadTimeout is used, or bluetoothReadTimeout for
+	// Bluetooth ports. If negative, reads block until data arrives.
+	ReadTimeout time.Duration
+	// ReconnectAttempts is the number of consecutive failures opening
+	// the port after which Run() stops reconnecting and returns the
+	// error. Waiting for the port to appear (e.g. while the board
+	// reboots) doesn't count. If zero, it retries forever.
+	ReconnectAttempts int
+	// ErrorHandler, if non-nil, is called with the errors that stop
+	// StartUpdating() and with a *LinkError when the board disconnects
+	// or reconnects. If nil, they're printed to Stdout.
+	ErrorHandler func(error)
+	// ArmChannel is the RC channel (starting at 1) used for arming
+	// via RX simulation. If zero, channel 5 (AUX 1) is used.
+	ArmChannel int
+	// VerifyFlashedRevision checks that the revision reported by
+	// the board after flashing matches the source tree git HEAD.
+	VerifyFlashedRevision bool
+	// RXTrim contains offsets for roll, pitch, yaw and throttle
+	// applied during RX simulation. See rx.RxSticks.Trim.
+	RXTrim [4]int16
+	// RXDeadband is the deadband around the center for roll, pitch
+	// and yaw during RX simulation. See rx.RxSticks.Deadband.
+	RXDeadband uint16
+	// ChannelNames contains names for the AUX channels, by channel
+	// number. See rx.ParseChannelNames().
+	ChannelNames map[int]string
+	// ChannelOrder sets the channel sent at each position during RX
+	// simulation, overriding RXMAP. See rx.ParseChannelOrder().
+	ChannelOrder []int
+	// RXExpressions, if non-nil, drives channels during RX simulation
+	// using expressions of the time since the simulation started.
+	RXExpressions *rx.ExprSource
+	// RXCrossfade is the time the channels take to ramp to the values
+	// of a new input source, see SetRXSource(). If zero,
+	// defaultRXCrossfade is used. Negative values disable it.
+	RXCrossfade time.Duration
+	// EmulateRangefinder sends RangefinderDistance (in mm, negative
+	// for out of range) as an MSP rangefinder would.
+	EmulateRangefinder  bool
+	RangefinderDistance int32
+	// WaitForBoard, if non-zero, is the maximum time NewFC waits for
+	// the port to appear and become usable.
+	WaitForBoard time.Duration
+	// RCKeepaliveInterval is the maximum time between MSP_SET_RAW_RC
+	// frames during RX simulation. Frames are sent more often when
+	// the channels change. If zero, defaultRCKeepaliveInterval is used.
+	RCKeepaliveInterval time.Duration
+	// MotorTestWatchdog is the time without SetMotor() calls after
+	// which all the motors are stopped. If zero,
+	// defaultMotorTestWatchdog is used.
+	MotorTestWatchdog time.Duration
+	// IMUSampleInterval is the interval between MSP_RAW_IMU requests
+	// while the IMU monitor is running. If zero,
+	// defaultIMUSampleInterval is used.
+	IMUSampleInterval time.Duration
+	// ResumeRXSimulation restarts RX simulation after reconnecting
+	// if it was active, with the sticks and channels reset. Otherwise
+	// it stops when the board disconnects.
+	ResumeRXSimulation bool
+	// AdaptiveRCRate lowers the rate of MSP_SET_RAW_RC frames during
+	// RX simulation when the board takes too long to answer requests,
+	// raising it again once the latency recovers.
+	AdaptiveRCRate bool
+	// LowCellVoltage enables monitoring the battery, printing an alert
+	// when the voltage per cell drops below it. Zero disables it.
+	LowCellVoltage float64
+	// LoopOverrunThreshold is the fraction over the expected cycle
+	// time considered an overrun by FC.ToggleOverrunMonitor. If zero,
+	// defaultOverrunThreshold is used.
+	LoopOverrunThreshold float64
+	// Notifications selects the events which ring the terminal bell.
+	// The caller should leave it empty if the output is not a terminal.
+	Notifications Notification
+	// CSVLog, if non-nil, receives a CSV row with the telemetry
+	// every CSVLogInterval (or defaultCSVLogInterval if zero).
+	CSVLog         io.Writer
+	CSVLogInterval time.Duration
+	// AttitudePollInterval, if non-zero, is the interval between
+	// MSP_ATTITUDE requests for keeping Attitude() up to date.
+	AttitudePollInterval time.Duration
+	// Capture, if non-nil, records all the data exchanged with the
+	// board. See Replay().
+	Capture *msp.CaptureWriter
+	// BuildSystem is used for building the firmware before flashing.
+	// If nil, it's detected from the source tree.
+	BuildSystem *BuildSystem
+	// FrameExport, if non-nil, records all the valid frames exchanged
+	// with the board. See ExportFrames().
+	FrameExport *msp.FrameRecordWriter
+	// DFUBackend is used for flashing. If nil, dfu-util is used.
+	DFUBackend DFUBackend
+	// DiffConfigAfterFlash reads some settings before flashing and
+	// prints the ones that changed once the board reconnects.
+	DiffConfigAfterFlash bool
 }
 
 func (f *FCOptions) stderr() io.Writer {
@@ -65,95 +309,347 @@ func (f *FCOptions) stderr() io.Writer {
 // NewFC returns a new FC using the given port and baud rate. stdout is
 // optional and will default to os.Stdout if nil
 func NewFC(opts FCOptions) (*FC, error) {
-	m, err := msp.New(opts.PortName, opts.BaudRate)
-	if err != nil {
-		return nil, err
-	}
 	if opts.Stdout == nil {
 		opts.Stdout = os.Stdout
 	}
+	if err := rx.ValidateTrim(opts.RXTrim); err != nil {
+		return nil, err
+	}
+	if err := rx.ValidateDeadband(opts.RXDeadband); err != nil {
+		return nil, err
+	}
+	if err := rx.ValidateChannelOrder(opts.ChannelOrder, 0); err != nil {
+		return nil, err
+	}
+	if err := opts.SerialFraming.Validate(); err != nil {
+		return nil, err
+	}
 	fc := &FC{
 		opts: opts,
-		msp:  m,
 	}
+	if opts.RXExpressions != nil {
+		fc.rxSource.source = opts.RXExpressions
+	}
+	var m *msp.MSP
+	var err error
+	if opts.WaitForBoard > 0 {
+		m, err = fc.waitForPort(opts.WaitForBoard)
+	} else {
+		m, err = fc.openPort()
+	}
+	if err != nil {
+		return nil, err
+	}
+	fc.msp = m
 	fc.reset()
+	fc.snapshotUSBDisks()
 	fc.updateInfo()
 	return fc, nil
 }
 
-func (f *FC) reconnect() error {
-	if f.msp != nil {
-		f.msp.Close()
-		f.msp = nil
+// openSerialPort opens an MSP connection over a serial port. Tests
+// replace it to talk to fake boards.
+var openSerialPort = msp.NewWithFraming
+
+// openPort opens a new MSP connection to the port in the options,
+// which might also be a tcp://host:port address. If the baud rate is
+// zero, it's detected, see connectAutoBaud().
+func (f *FC) openPort() (*msp.MSP, error) {
+	var m *msp.MSP
+	var err error
+	switch {
+	case f.isTCPPort():
+		m, err = f.dialTCP()
+	case f.opts.BaudRate == 0:
+		m, err = f.connectAutoBaud()
+	default:
+		m, err = openSerialPort(f.opts.PortName, f.opts.BaudRate, f.opts.SerialFraming)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.opts.ReadBufferSize > 0 {
+		m.SetReadBufferSize(f.opts.ReadBufferSize)
+	}
+	if timeout := f.readTimeout(); timeout > 0 {
+		if err := m.SetReadTimeout(timeout); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	m.SetReusePayloads(f.opts.ReuseFrames)
+	m.SetWriteTrace(f.traceTX)
+	if f.opts.Capture != nil {
+		m.SetCapture(f.opts.Capture)
 	}
+	if f.opts.FrameExport != nil {
+		m.SetFrameExport(f.opts.FrameExport)
+	}
+	return m, nil
+}
+
+// reconnect closes the port and opens it again, waiting for it to be
+// present. It returns an error if ctx is cancelled or opening the port
+// fails FCOptions.ReconnectAttempts times in a row.
+func (f *FC) reconnect(ctx context.Context) error {
+	f.closePort(nil)
+	inMSC := false
+	failures := 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Trying to connect on macOS when the port dev file is
 		// not present would cause an USB hub reset.
 		if f.portIsPresent() {
-			m, err := msp.New(f.opts.PortName, f.opts.BaudRate)
+			m, err := f.openPort()
 			if err == nil {
+				atomic.StoreInt32(&f.mscRequested, 0)
 				f.printf("Reconnected to %s @ %dbps\n", f.opts.PortName, f.opts.BaudRate)
+				f.countReconnection()
 				f.reset()
-				f.msp = m
+				f.setPort(m)
+				f.snapshotUSBDisks()
 				f.updateInfo()
 				return nil
 			}
+			failures++
+			if f.opts.ReconnectAttempts > 0 && failures >= f.opts.ReconnectAttempts {
+				return fmt.Errorf("giving up reconnecting to %s after %d attempts: %v", f.opts.PortName, failures, err)
+			}
+		}
+		if !inMSC && f.inMSCMode() {
+			f.printf("Board is in mass storage mode; serial MSP unavailable until reboot\n")
+			inMSC = true
+		}
+		delay := f.reconnectDelay()
+		if inMSC {
+			delay = mscReconnectDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
-		time.Sleep(time.Millisecond)
 	}
 }
 
 func (f *FC) Close() error {
-	if f.msp != nil {
-		err := f.msp.Close()
+	return f.closePort(nil)
+}
+
+// port returns the connection to the board, nil while disconnected
+func (f *FC) port() *msp.MSP {
+	f.mspMu.Lock()
+	defer f.mspMu.Unlock()
+	return f.msp
+}
+
+func (f *FC) setPort(m *msp.MSP) {
+	f.mspMu.Lock()
+	f.msp = m
+	f.mspMu.Unlock()
+}
+
+// closePort closes m and, if it's still the connection to the board,
+// clears it, which makes the reader goroutine reconnect. If m is nil,
+// the current connection is closed.
+func (f *FC) closePort(m *msp.MSP) error {
+	f.mspMu.Lock()
+	if m == nil {
+		m = f.msp
+	}
+	if f.msp == m {
 		f.msp = nil
-		if err != nil {
-			return err
-		}
 	}
-
-	return nil
+	f.mspMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.Close()
 }
 
 func (f *FC) updateInfo() {
+	// Print whatever we got if the board doesn't answer
+	// to all the identity commands in time.
+	f.infoMu.Lock()
+	if f.infoTimer != nil {
+		f.infoTimer.Stop()
+	}
+	f.infoTimer = time.AfterFunc(infoTimeout, f.printInfo)
+	f.infoMu.Unlock()
 	// Send commands to print FC info
-	f.msp.WriteCmd(msp.MspAPIVersion)
-	f.msp.WriteCmd(msp.MspFCVariant)
-	f.msp.WriteCmd(msp.MspFCVersion)
-	f.msp.WriteCmd(msp.MspBoardInfo)
-	f.msp.WriteCmd(msp.MspBuildInfo)
-	f.msp.WriteCmd(msp.MspFeature)
-	f.msp.WriteCmd(msp.MspCFSerialConfig)
-	f.msp.WriteCmd(msp.MspRXMap)
+	m := f.port()
+	m.WriteCmd(msp.MspAPIVersion)
+	m.WriteCmd(msp.MspFCVariant)
+	m.WriteCmd(msp.MspFCVersion)
+	m.WriteCmd(msp.MspBoardInfo)
+	m.WriteCmd(msp.MspName)
+	m.WriteCmd(msp.MspUID)
+	m.WriteCmd(msp.MspBuildInfo)
+	m.WriteCmd(msp.MspFeature)
+	m.WriteCmd(msp.MspCFSerialConfig)
+	m.WriteCmd(msp.MspRXMap)
+	m.WriteCmd(msp.MspRC)
 }
 
 func (f *FC) printf(format string, a ...interface{}) (int, error) {
 	return fmt.Fprintf(f.opts.Stdout, format, a...)
 }
 
+// Info returns the identity information received from the board
+// so far.
+func (f *FC) Info() Info {
+	f.infoMu.Lock()
+	defer f.infoMu.Unlock()
+	return Info{
+		Variant:      f.variant,
+		VersionMajor: f.versionMajor,
+		VersionMinor: f.versionMinor,
+		VersionPatch: f.versionPatch,
+		BoardID:      f.boardID,
+		TargetName:   f.targetName,
+		CraftName:    f.craftName,
+		UID:          f.uid,
+		PIDLoopRate:  f.pidLoopRate,
+	}
+}
+
+// WaitForInfo blocks until the identity information has been received
+// from the board, or until the board fails to provide it in time, and
+// then returns it.
+func (f *FC) WaitForInfo() Info {
+	f.infoMu.Lock()
+	done := f.infoDone
+	f.infoMu.Unlock()
+	<-done
+	return f.Info()
+}
+
+// receivedInfo marks the identity frame with the given code as received
+// and prints the board info once all of them have arrived.
+func (f *FC) receivedInfo(code uint16) {
+	f.infoMu.Lock()
+	if f.infoReceived == nil {
+		f.infoReceived = make(map[uint16]bool)
+	}
+	f.infoReceived[code] = true
+	complete := true
+	for _, c := range infoCodes {
+		if !f.infoReceived[c] {
+			complete = false
+			break
+		}
+	}
+	if f.loopRateCode != 0 && !f.infoReceived[f.loopRateCode] {
+		complete = false
+	}
+	f.infoMu.Unlock()
+	if complete {
+		f.printInfo()
+	}
+}
+
+// printInfo prints the board identity. It only prints once per
+// connection, either when all the identity frames have been received
+// or when infoTimeout expires.
 func (f *FC) printInfo() {
-	if f.variant != "" && f.versionMajor != 0 && f.boardID != "" {
+	f.infoMu.Lock()
+	if f.infoPrinted {
+		f.infoMu.Unlock()
+		return
+	}
+	f.infoPrinted = true
+	if f.infoTimer != nil {
+		f.infoTimer.Stop()
+		f.infoTimer = nil
+	}
+	close(f.infoDone)
+	snapshot := f.preFlashConfig
+	f.preFlashConfig = nil
+	motorProtocol := f.preFlashMotorProtocol
+	f.preFlashMotorProtocol = nil
+	f.infoMu.Unlock()
+	if snapshot != nil {
+		// Can't block here, since we might be running in
+		// the goroutine that delivers the responses.
+		defer func() { go f.diffPreFlashConfig(snapshot) }()
+	}
+	if motorProtocol != nil {
+		defer func() { go f.checkMotorProtocol(*motorProtocol) }()
+	}
+	info := f.Info()
+	if info.Variant != "" && info.VersionMajor != 0 && info.BoardID != "" {
 		targetName := ""
-		if f.targetName != "" {
-			targetName = ", target " + f.targetName
+		if info.TargetName != "" {
+			targetName = ", target " + info.TargetName
 		}
-		f.printf("%s %d.%d.%d (board %s%s)\n", f.variant, f.versionMajor, f.versionMinor, f.versionPatch, f.boardID, targetName)
+		f.printf("%s %d.%d.%d (board %s%s)\n", info.Variant, info.VersionMajor, info.VersionMinor, info.VersionPatch, info.BoardID, targetName)
+	}
+	var extra []string
+	if info.CraftName != "" {
+		extra = append(extra, fmt.Sprintf("craft %q", info.CraftName))
+	}
+	if info.UID != "" {
+		extra = append(extra, "UID "+info.UID)
+	}
+	if info.PIDLoopRate != 0 {
+		extra = append(extra, fmt.Sprintf("PID loop %dHz", info.PIDLoopRate))
+	}
+	if len(extra) > 0 {
+		f.printf("%s\n", strings.Join(extra, ", "))
 	}
 }
 
 func (f *FC) handleFrame(fr *msp.MSPFrame, w interface{}) error {
 	switch fr.Code {
+	case msp.MspAttitude:
+		if _, _, _, err := f.updateAttitude(fr); err != nil {
+			return err
+		}
+	case msp.MspMotor:
+		if _, err := f.updateMotors(fr); err != nil {
+			return err
+		}
+	case msp.MspAnalog:
+		if _, err := f.updateAnalog(fr); err != nil {
+			return err
+		}
+	case msp.MspStatus, msp.MspStatusEx:
+		// Requested by someone else, e.g. a configurator sharing
+		// the link. Keep it anyway.
+		if _, err := f.decodeStatus(fr); err != nil {
+			return err
+		}
 	case msp.MspAPIVersion:
 		f.printf("MSP API version %d.%d (protocol %d)\n", fr.Byte(1), fr.Byte(2), fr.Byte(0))
 	case msp.MspFCVariant:
-		f.variant = string(fr.Payload)
-		f.printInfo()
+		variant := string(fr.Payload)
+		if !isValidVariant(variant) {
+			// Some boards answer with an empty variant while booting
+			f.retryVariant(variant)
+			break
+		}
+		code := loopRateCode(variant)
+		f.infoMu.Lock()
+		f.variant = variant
+		f.loopRateCode = code
+		f.infoMu.Unlock()
+		if code != 0 {
+			// The response arrives after MSP_FC_VERSION, which
+			// is needed for decoding it.
+			f.port().WriteCmd(code)
+		}
+		f.receivedInfo(fr.Code)
 	case msp.MspFCVersion:
+		f.infoMu.Lock()
 		f.versionMajor = fr.Byte(0)
 		f.versionMinor = fr.Byte(1)
 		f.versionPatch = fr.Byte(2)
-		f.printInfo()
+		f.infoMu.Unlock()
+		f.receivedInfo(fr.Code)
 	case msp.MspBoardInfo:
+		f.infoMu.Lock()
 		// BoardID is always 4 characters
 		f.boardID = string(fr.Payload[:4])
 		// Then 4 bytes follow, HW revision (uint16), builtin OSD type (uint8) and wether
@@ -166,41 +662,58 @@ func (f *FC) handleFrame(fr *msp.MSPFrame, w interface{}) error {
 				f.targetName = string(fr.Payload[9 : 9+targetNameLength])
 			}
 		}
-		f.printInfo()
+		f.infoMu.Unlock()
+		f.receivedInfo(fr.Code)
+	case msp.MspName:
+		// Empty when no craft name has been configured
+		f.infoMu.Lock()
+		f.craftName = strings.TrimRight(string(fr.Payload), "\x00")
+		f.infoMu.Unlock()
+		f.receivedInfo(fr.Code)
+	case msp.MspUID:
+		uid := make([]uint32, 3)
+		if err := fr.Read(uid); err == nil {
+			f.infoMu.Lock()
+			f.uid = fmt.Sprintf("%08x%08x%08x", uid[0], uid[1], uid[2])
+			f.infoMu.Unlock()
+		}
+		f.receivedInfo(fr.Code)
 	case msp.MspBuildInfo:
 		buildDate := string(fr.Payload[:11])
 		buildTime := string(fr.Payload[11:19])
 		// XXX: Revision is 8 characters in iNav but 7 in BF/CF
 		rev := string(fr.Payload[19:])
 		f.printf("Build %s (built on %s @ %s)\n", rev, buildDate, buildTime)
+		f.checkFlashedRevision(strings.Trim(rev, " \x00"))
 	case msp.MspFeature:
-		fr.Read(&f.Features)
-		if (f.Features&msp.MspFCFeatureDebugTrace == 0) && f.shouldEnableDebugTrace() {
+		features, width, err := readFeatures(fr)
+		if err != nil {
+			return err
+		}
+		f.Features = features
+		f.featureWidth = width
+		debugTrace := debugTraceFeature(f.variant)
+		if debugTrace != 0 && !f.Features.Has(debugTrace) && f.shouldEnableDebugTrace() {
 			f.printf("Enabling FEATURE_DEBUG_TRACE\n")
-			f.Features |= msp.MspFCFeatureDebugTrace
-			f.msp.WriteCmd(msp.MspSetFeature, f.Features)
-			f.msp.WriteCmd(msp.MspEepromWrite)
+			f.Features |= debugTrace
+			f.port().WriteCmd(msp.MspSetFeature, featureArgs(f.Features, width)...)
+			f.port().WriteCmd(msp.MspEepromWrite)
 		}
 	case msp.MspCFSerialConfig:
+		serialConfigs, err := f.updateSerialConfig(fr)
+		if err != nil {
+			return err
+		}
 		if f.shouldEnableDebugTrace() {
-			var cfg msp.MSPSerialConfig
-			var serialConfigs []msp.MSPSerialConfig
 			hasDebugTraceMSPPort := false
 			mask := uint16(msp.SerialFunctionMSP | msp.SerialFunctionDebugTrace)
-			for {
-				err := fr.Read(&cfg)
-				if err != nil {
-					if err == io.EOF {
-						// All ports read
-						break
-					}
-					panic(err)
-				}
+			for _, cfg := range serialConfigs {
 				if cfg.FunctionMask&mask == mask {
 					hasDebugTraceMSPPort = true
 				}
-				serialConfigs = append(serialConfigs, cfg)
 			}
+			// Don't modify the stored config
+			serialConfigs = append([]msp.MSPSerialConfig(nil), serialConfigs...)
 			if !hasDebugTraceMSPPort {
 				// Enable DEBUG_TRACE on the first MSP port, since DEBUG_TRACE only
 				// works on one port.
@@ -212,8 +725,8 @@ func (f *FC) handleFrame(fr *msp.MSPFrame, w interface{}) error {
 					}
 				}
 				// Save ports
-				f.msp.WriteCmd(msp.MspSetCFSerialConfig, serialConfigs)
-				f.msp.WriteCmd(msp.MspEepromWrite)
+				f.port().WriteCmd(msp.MspSetCFSerialConfig, serialConfigs)
+				f.port().WriteCmd(msp.MspEepromWrite)
 			}
 		}
 	case msp.MspRXMap:
@@ -221,17 +734,41 @@ func (f *FC) handleFrame(fr *msp.MSPFrame, w interface{}) error {
 		if err := fr.Read(f.channelMap); err != nil {
 			return err
 		}
+	case msp.MspRC:
+		if _, err := f.updateRC(fr); err != nil {
+			return err
+		}
+	case msp.MspAdvancedConfig, msp.MspLoopTime:
+		hz, err := decodeLoopRate(f.Info(), fr)
+		if err != nil {
+			f.printf("could not decode PID loop rate: %v\n", err)
+		}
+		f.infoMu.Lock()
+		f.pidLoopRate = hz
+		f.infoMu.Unlock()
+		f.receivedInfo(fr.Code)
 	case msp.MspReboot:
 		f.printf("Rebooting board...\n")
 	case msp.MspDebugMsg:
 		s := strings.Trim(string(fr.Payload), " \r\n\t\x00")
 		f.printf("[DEBUG] %s\n", s)
 	case msp.MspSetFeature:
+	case msp.MspSetRXConfig:
 	case msp.MspSetCFSerialConfig:
+	case msp.MspSetVoltageMeterConfig:
+	case msp.MspSetOSDConfig:
 	case msp.MspSetRawRC:
+	case msp.MspSetAccTrim:
 	case msp.MspEepromWrite:
 	case msp.MspSetPID:
+	case msp.MspSetNavPoshold:
+	case msp.MspSetRTHAndLandConfig:
 		// Nothing to do for these
+	case msp.Msp2SensorRangefinder:
+		// Sent by an MSP sensor connected to the port
+		f.printf("Rangefinder: %s\n", decodeRangefinder(fr))
+	case msp.Msp2SensorOpticFlow:
+		f.printf("Optical flow: %s\n", decodeOpticFlow(fr))
 	case msp.MspPID:
 		pidMap := make([]uint8, 30)
 		if err := fr.Read(pidMap); err != nil {
@@ -265,7 +802,9 @@ func (f *FC) handleFrame(fr *msp.MSPFrame, w interface{}) error {
 			return nil
 		}
 	default:
-		f.printf("Unhandled MSP frame %d with payload %v\n", fr.Code, fr.Payload)
+		if !f.opts.DecodeAll {
+			f.printf("Unhandled MSP frame %s with payload %v\n", msp.CommandName(fr.Code), fr.Payload)
+		}
 	}
 	return nil
 }
@@ -285,24 +824,38 @@ func (f *FC) prepareToReboot(fn func(m *msp.MSP) error) error {
 	// so close the current port and open another one to ensure
 	// the goroutine reading from the port stops even if the
 	// board reboots very fast.
-	m := f.msp
-	f.msp = nil
-	m.Close()
+	f.closePort(nil)
 	time.Sleep(time.Second)
-	mm, err := msp.New(f.opts.PortName, f.opts.BaudRate)
-	if err != nil {
-		return err
+	// If the board came back faster than expected, the port might
+	// open against a VCP which is not ready yet, making the write
+	// fail. Give it some time to settle and try again.
+	var err error
+	delay := rebootRetryDelay
+	for ii := 0; ii < rebootAttempts; ii++ {
+		if ii > 0 {
+			f.printf("Could not send reboot command (%v), retrying in %v...\n", err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var mm *msp.MSP
+		mm, err = f.openPort()
+		if err != nil {
+			continue
+		}
+		err = fn(mm)
+		mm.Close()
+		if err == nil {
+			return nil
+		}
 	}
-	err = fn(mm)
-	mm.Close()
-	return err
+	return fmt.Errorf("could not reboot board after %d attempts: %v", rebootAttempts, err)
 }
 
 // Reboot reboots the board via MSP_REBOOT
-func (f *FC) Reboot() {
-	f.prepareToReboot(func(m *msp.MSP) error {
-		m.WriteCmd(msp.MspReboot)
-		return nil
+func (f *FC) Reboot() error {
+	return f.prepareToReboot(func(m *msp.MSP) error {
+		_, err := m.WriteCmd(msp.MspReboot)
+		return err
 	})
 }
 
@@ -314,7 +867,7 @@ func (f *FC) unwrapError(err error) error {
 }
 
 func (f *FC) portIsPresent() bool {
-	if runtime.GOOS == "windows" {
+	if runtime.GOOS == "windows" || f.isTCPPort() {
 		return true
 	}
 	_, err := os.Stat(f.opts.PortName)
@@ -322,12 +875,68 @@ func (f *FC) portIsPresent() bool {
 }
 
 // StartUpdating starts reading from the MSP port and handling
-// the received messages. Note that it never returns.
+// the received messages. Note that it never returns, unless
+// reconnecting fails (see FCOptions.ReconnectAttempts), in which case
+// the error is passed to FCOptions.ErrorHandler. Use Run() for
+// stopping it.
 func (f *FC) StartUpdating(w interface{}) {
+	if err := f.Run(context.Background(), w); err != nil {
+		f.reportError(err)
+	}
+}
+
+// Run reads from the MSP port and handles the received messages until
+// ctx is cancelled, which closes the port, returning ctx.Err(). If the
+// board disconnects and reconnecting fails FCOptions.ReconnectAttempts
+// times in a row, it returns the error. The goroutines started for the
+// options (e.g. the CSV log or the keepalive) stop when Run returns.
+func (f *FC) Run(ctx context.Context, w interface{}) error {
+	// Stops the goroutines below when Run returns for any reason
+	optCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if interval := f.keepaliveInterval(); interval > 0 {
+		go f.keepalive(optCtx, interval)
+	}
+	if f.opts.LowCellVoltage > 0 {
+		go f.monitorBattery(optCtx)
+	}
+	if f.opts.CSVLog != nil {
+		go f.logCSV(optCtx, f.opts.CSVLog)
+	}
+	if f.opts.AttitudePollInterval > 0 {
+		go f.pollAttitude(optCtx, f.opts.AttitudePollInterval)
+	}
+	if f.opts.Notifications&NotifyArm != 0 {
+		go f.monitorArmed(optCtx)
+	}
+	if f.opts.EmulateRangefinder {
+		go f.emulateRangefinder(optCtx, f.opts.RangefinderDistance)
+	}
+	f.unsolicited = make(chan *msp.MSPFrame, unsolicitedQueueSize)
+	go f.handleUnsolicited(w)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Makes ReadFrame() return
+			f.Close()
+		case <-stop:
+		}
+	}()
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		var frame *msp.MSPFrame
 		var err error
-		m := f.msp
+		m := f.port()
+		if m != nil && f.isPassthrough() {
+			// The port doesn't speak MSP until we exit the 4-way
+			// interface or the CLI
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
 		if m != nil {
 			frame, err = m.ReadFrame()
 		} else {
@@ -341,13 +950,54 @@ func (f *FC) StartUpdating(w interface{}) {
 			// path that handles a closed port.
 			err = os.ErrClosed
 		}
+		if err == msp.ErrReadTimeout {
+			// The board is idle. Reads might block forever
+			// when the port goes away, so check it here.
+			if f.portIsPresent() {
+				continue
+			}
+			err = os.ErrClosed
+		}
+		if err != nil && ctx.Err() != nil {
+			// Closed by Run()
+			return ctx.Err()
+		}
 		if err != nil {
+			if rerr, ok := err.(rejectedError); ok {
+				// The board is fine, it just didn't like the
+				// command. Fail the request waiting for it.
+				if !f.rejectRequest(rerr.RejectedCode(), err) || f.Verbose() {
+					f.printf("%v\n", err)
+				}
+				continue
+			}
 			if merr, ok := err.(msp.MSPError); ok && merr.IsMSPError() {
-				f.printf("%v\n", err)
+				if cerr, ok := err.(checksumError); ok && f.opts.DecodeAll {
+					f.printf("%s\n", describeFrame(cerr.Frame(), false))
+				} else {
+					f.printf("%v\n", err)
+				}
+				if terr, ok := err.(truncatedError); ok {
+					f.printTruncated(terr)
+				}
+				if _, ok := err.(oobError); ok {
+					f.countOOBByte()
+				}
+				if _, ok := err.(checksumError); ok && f.countChecksumError() {
+					// Reopening the port might fix a desynced UART
+					f.printf("Too many checksum errors, reopening the port...\n")
+					if err := f.reconnect(ctx); err != nil {
+						return err
+					}
+				}
 				continue
 			}
+			if terr, ok := err.(truncatedError); ok {
+				// The link dropped in the middle of a frame
+				f.printTruncated(terr)
+			}
 			uerr := f.unwrapError(err)
-			f.printf("Board disconnected (%v), trying to reconnect...\n", uerr)
+			f.reportError(&LinkError{Err: uerr})
 			if uerr == os.ErrClosed {
 				time.Sleep(time.Second)
 				// Wait for the port to go away or a 5s timeout
@@ -358,10 +1008,36 @@ func (f *FC) StartUpdating(w interface{}) {
 					}
 				}
 			}
-			if err := f.reconnect(); err != nil {
-				panic(err)
+			if err := f.reconnect(ctx); err != nil {
+				return err
 			}
-			f.printf("Reconnected...\n")
+			f.reportError(&LinkError{Reconnected: true})
+			continue
+		}
+		if m.IsEcho(frame) {
+			f.warnEcho(frame)
+			continue
+		}
+		f.countFrame()
+		f.traceRX(frame)
+		if f.opts.DecodeAll {
+			f.printf("%s\n", describeFrame(frame, true))
+		}
+		f.checkFrameVersion(m, frame)
+		if frame.Code == msp.MspSet4WayIF && frame.Direction == '>' {
+			// Stop reading before the board starts talking the 4-way
+			// protocol, see Enter4WayInterface()
+			f.set4WayMode(true)
+		}
+		if frame.Code == msp.MspAPIVersion && f.takeCLIPending() {
+			// Stop reading before entering the CLI, see enterCLI()
+			f.setCLIMode(true)
+		}
+		if f.deliverResponse(frame) {
+			continue
+		}
+		if isUnsolicited(frame) {
+			f.queueUnsolicited(frame)
 			continue
 		}
 		f.handleFrame(frame, w)
@@ -371,77 +1047,97 @@ func (f *FC) StartUpdating(w interface{}) {
 // HasDetectedTargetName returns true iff the target name installed on
 // the board has been retrieved via MSP.
 func (f *FC) HasDetectedTargetName() bool {
-	return f.targetName != ""
+	return f.Info().TargetName != ""
 }
 
 // Flash compiles the given target and flashes the board
 func (f *FC) Flash(srcDir string, targetName string) error {
+	return f.flash(srcDir, targetName, true)
+}
+
+// FlashExisting flashes the most recent binary for the given target
+// found in the obj directory of srcDir, without building it first.
+func (f *FC) FlashExisting(srcDir string, targetName string) error {
+	return f.flash(srcDir, targetName, false)
+}
+
+func (f *FC) flash(srcDir string, targetName string, build bool) error {
 	if targetName == "" {
-		targetName = f.targetName
+		targetName = f.Info().TargetName
 
 		if targetName == "" {
 			return errors.New("empty target name")
 		}
 	}
-	// First, check that dfu-util is available
-	dfu, err := exec.LookPath("dfu-util")
+	// First, check that the DFU backend (dfu-util by default) is available
+	dfu, err := f.dfuBackend()
+	if err != nil {
+		return &DFUError{Err: err}
+	}
+	bs, err := f.buildSystem(srcDir, build)
 	if err != nil {
 		return err
 	}
-	// Now compile the target
-	cmd := exec.Command("make", "binary")
-	cmd.Stdout = f.opts.Stdout
-	cmd.Stderr = f.opts.stderr()
-	cmd.Stdin = os.Stdin
-	var env []string
-	env = append(env, os.Environ()...)
-	env = append(env, "TARGET="+targetName)
-	cmd.Env = env
-	cmd.Dir = srcDir
+	// Existing binaries might not match the source tree revision,
+	// so the flashed firmware is only verified when building.
+	var revision string
+	if build {
+		// Now compile the target
+		cmd := bs.command(srcDir, targetName)
+		cmd.Stdout = f.opts.Stdout
+		cmd.Stderr = f.opts.stderr()
+		cmd.Stdin = os.Stdin
+
+		if f.opts.VerifyFlashedRevision {
+			if revision, err = sourceRevision(srcDir); err != nil {
+				f.printf("Could not determine source revision (%v), the flashed firmware won't be verified\n", err)
+			}
+		}
 
-	f.printf("Building binary for %s...\n", targetName)
+		f.printf("Building binary for %s...\n", targetName)
 
-	if err := cmd.Run(); err != nil {
-		return err
+		if err := cmd.Run(); err != nil {
+			return &BuildError{Err: err}
+		}
 	}
 
 	// Check existing .bin files in the output directory
-	obj := filepath.Join(srcDir, "obj")
-	files, err := ioutil.ReadDir(obj)
+	binaryPath, binary, err := bs.findBinary(srcDir, targetName)
 	if err != nil {
-		return err
+		return &BuildError{Err: err}
 	}
 
-	var binary os.FileInfo
-
-	for _, f := range files {
-		name := f.Name()
-		if filepath.Ext(name) == ".bin" {
-			nonExt := name[:len(name)-4]
-			// Binaries end with the target name
-			if strings.HasSuffix(nonExt, targetName) {
-				if binary == nil || binary.ModTime().Before(f.ModTime()) {
-					binary = f
-				}
-			}
+	var snapshot *ConfigSnapshot
+	if f.opts.DiffConfigAfterFlash {
+		if snapshot, err = f.CaptureConfig(); err != nil {
+			f.printf("Could not read the configuration before flashing (%v), changes won't be reported\n", err)
 		}
 	}
-	if binary == nil {
-		return fmt.Errorf("could not find binary for target %s", targetName)
+	var motorProtocol *MotorProtocol
+	if p, err := f.MotorProtocol(); err == nil {
+		motorProtocol = &p
+	}
+	if !build {
+		f.printf("Flashing existing binary %s (built %s)\n", binary.Name(), binary.ModTime().Format(time.Stamp))
 	}
-
-	binaryPath := filepath.Join(obj, binary.Name())
 
 	f.printf("Rebooting board in DFU mode...\n")
 
 	// Now reboot in dfu mode
-	if err := f.dfuReboot(); err != nil {
-		return err
+	device, err := f.dfuEnter(dfu)
+	if err != nil {
+		return &DFUError{Err: err}
 	}
-	if err := f.dfuWait(dfu); err != nil {
-		return err
+	if err := f.dfuFlash(dfu, device, binaryPath); err != nil {
+		return &DFUError{Err: err}
 	}
-	return f.dfuFlash(dfu, binaryPath)
+	// Checked once the board reconnects and sends MSP_BUILD_INFO
+	f.infoMu.Lock()
+	f.flashedRevision = revision
+	f.preFlashConfig = snapshot
+	f.preFlashMotorProtocol = motorProtocol
+	f.infoMu.Unlock()
+	return nil
 }
 
 func (f *FC) IsSimulatingRX() bool {
@@ -455,13 +1151,54 @@ func (f *FC) ToggleRXSimulation() (enabled bool, err error) {
 	} else {
 		f.rxTicker = time.NewTicker(10 * time.Millisecond)
 		go func(t *time.Ticker) {
+			var lastMSP *msp.MSP
+			var lastChannels []uint16
+			var lastSent time.Time
+			keepalive := f.rcKeepaliveInterval()
+			f.rxSourceMu.Lock()
+			f.rxSource.started = time.Now()
+			f.rxSourceMu.Unlock()
+			var fade *rx.Crossfade
+			var rate *rcRateController
+			if f.opts.AdaptiveRCRate {
+				rate = newRCRateController()
+			}
 			for range t.C {
 				f.sticks.Update()
-				m := f.msp
-				if m == nil {
+				src, elapsed, changed := f.currentRXSource()
+				if src != nil {
+					src.Apply(&f.sticks, elapsed)
+				}
+				if changed && lastChannels != nil && f.rxCrossfade() > 0 {
+					fade = rx.NewCrossfade(lastChannels, f.rxCrossfade())
+				}
+				m := f.port()
+				// The channel map is received again after
+				// reconnecting
+				if m == nil || f.channelMap == nil {
+					continue
+				}
+				if rate != nil {
+					rate.probe(f)
+					rate.adjust(f)
+					if time.Since(lastSent) < rate.interval {
+						continue
+					}
+				}
+				payload := f.sticks.ToMSP(f.channelMap, f.channelCount())
+				if fade != nil && !fade.Apply(payload.Channels, time.Now()) {
+					fade = nil
+				}
+				// Only send the channels when they change, but keep
+				// sending them periodically so the board doesn't
+				// consider the RX lost.
+				if m == lastMSP && channelsEqual(payload.Channels, lastChannels) && time.Since(lastSent) < keepalive {
 					continue
 				}
-				m.WriteCmd(msp.MspSetRawRC, f.sticks.ToMSP(f.channelMap))
+				m.WriteCmd(msp.MspSetRawRC, payload)
+				lastMSP = m
+				lastChannels = payload.Channels
+				lastSent = time.Now()
 			}
 		}(f.rxTicker)
 		enabled = true
@@ -470,22 +1207,46 @@ func (f *FC) ToggleRXSimulation() (enabled bool, err error) {
 }
 
 func (f *FC) GetPIDs() (err error) {
-	f.msp.WriteCmd(msp.MspPID)
-
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err = m.WriteCmd(msp.MspPID)
 	return err
 }
 
 func (f *FC) SetPIDs(pids []uint8) (err error) {
-	f.msp.WriteCmd(msp.MspSetPID, pids)
-	f.msp.WriteCmd(msp.MspEepromWrite)
-
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	if _, err = m.WriteCmd(msp.MspSetPID, pids); err != nil {
+		return err
+	}
+	_, err = m.WriteCmd(msp.MspEepromWrite)
 	return err
 }
 
+// RXChannelState returns the name and state of the given simulated
+// AUX channel, see rx.RxSticks.DescribeChannel
+func (f *FC) RXChannelState(ch int) string {
+	return f.sticks.DescribeChannel(ch)
+}
+
 func (f *FC) RX() rx.RX {
 	return &f.sticks
 }
 
+// HoldRXChannel sets the given channel (starting at 1) to value during
+// RX simulation until it's changed again. Sticks return to it after
+// a keypress instead of to the center. See rx.RxSticks.SetChannel.
+func (f *FC) HoldRXChannel(ch int, value uint16) error {
+	if !f.IsSimulatingRX() {
+		return errors.New("RX simulation is not active")
+	}
+	return f.sticks.SetChannel(ch, value)
+}
+
 // Reboots the board into the bootloader for flashing
 func (f *FC) dfuReboot() error {
 	return f.prepareToReboot(func(m *msp.MSP) error {
@@ -494,95 +1255,64 @@ func (f *FC) dfuReboot() error {
 	})
 }
 
-func (f *FC) dfuList(dfuPath string) ([]string, error) {
-	cmd := exec.Command(dfuPath, "--list")
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Run()
-	lines := strings.Split(buf.String(), "\n")
-	var dfuLines []string
-	for _, ll := range lines {
-		ll = strings.Trim(ll, "\n\r\t ")
-		if strings.HasPrefix(ll, dfuDevicePrefix) {
-			dfuLines = append(dfuLines, ll[len(dfuDevicePrefix):])
-		}
-	}
-	return dfuLines, nil
-}
-
-func (f *FC) dfuWait(dfuPath string) error {
-	timeout := time.Now().Add(30 * time.Second)
-	for {
-		if timeout.Before(time.Now()) {
-			return fmt.Errorf("timed out while waiting for board in DFU mode")
-		}
-		devices, err := f.dfuList(dfuPath)
-		if err != nil {
-			return err
-		}
-		for _, dev := range devices {
-			if strings.Contains(dev, internalFlashMarker) {
-				// Found a flash device
-				return nil
-			}
-		}
-	}
-}
-
-func (f *FC) regexpFind(pattern string, s string) string {
-	r := regexp.MustCompile(pattern)
-	m := r.FindStringSubmatch(s)
-	if len(m) > 1 {
-		return m[1]
-	}
-	return ""
-}
-
-func (f *FC) dfuFlash(dfuPath string, binaryPath string) error {
-	devices, err := f.dfuList(dfuPath)
-	if err != nil {
-		return err
-	}
-	var device string
-	for _, dev := range devices {
-		if strings.Contains(dev, internalFlashMarker) {
-			device = dev
-			break
-		}
-	}
-	// a device line looks like:
-	// [0483:df11] ver=2200, devnum=17, cfg=1, intf=0, path="20-1", alt=0, name="@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg", serial="3276365D3336"
-	// We need to extract alt, serial and the flash offset
-	alt := f.regexpFind("alt=(\\d+)", device)
-	serial := f.regexpFind(`serial="(.*?)"`, device)
-	offset := f.regexpFind("Internal Flash  /([\\dx]*?)/", device)
-	if alt == "" || serial == "" || offset == "" {
-		return fmt.Errorf("could not determine flash parameters from %q", device)
-	}
-	f.printf("Flashing %s via DFU to offset %s...\n", filepath.Base(binaryPath), offset)
-	cmd := exec.Command(dfuPath, "-a", alt, "-S", serial, "-s", offset+":leave", "-D", binaryPath)
-	cmd.Stdout = f.opts.Stdout
-	cmd.Stderr = f.opts.stderr()
-	return cmd.Run()
-}
-
 func (f *FC) reset() {
+	f.infoMu.Lock()
 	f.variant = ""
 	f.versionMajor = 0
 	f.versionMinor = 0
 	f.versionPatch = 0
 	f.boardID = ""
 	f.targetName = ""
+	f.craftName = ""
+	f.uid = ""
+	f.pidLoopRate = 0
+	f.loopRateCode = 0
+	f.infoReceived = nil
+	f.variantRetries = 0
+	// Keep the channel if nobody closed it, since
+	// there might be callers of WaitForInfo() blocked on it.
+	if f.infoDone == nil || f.infoPrinted {
+		f.infoDone = make(chan struct{})
+	}
+	f.infoPrinted = false
+	if f.infoTimer != nil {
+		f.infoTimer.Stop()
+		f.infoTimer = nil
+	}
+	f.infoMu.Unlock()
 	f.Features = 0
+	f.featureWidth = 0
 	f.channelMap = nil
+	atomic.StoreInt32(&f.rcChannelCount, 0)
+	f.echoWarned = false
+	f.versionWarned = nil
+	atomic.StoreInt32(&f.armedState, armedUnknown)
+	f.set4WayMode(false)
+	f.setCLIMode(false)
+	f.takeCLIPending()
+	wasSimulatingRX := f.rxTicker != nil
 	if f.rxTicker != nil {
 		f.rxTicker.Stop()
 		f.rxTicker = nil
 	}
 	f.sticks = rx.RxSticks{
-		Roll:     rx.RxMid,
-		Pitch:    rx.RxMid,
-		Yaw:      rx.RxMid,
-		Throttle: rx.RxMid,
+		Roll:         rx.RxMid,
+		Pitch:        rx.RxMid,
+		Yaw:          rx.RxMid,
+		Throttle:     rx.RxMid,
+		Trim:         f.opts.RXTrim,
+		Deadband:     f.opts.RXDeadband,
+		ChannelNames: f.opts.ChannelNames,
+		ChannelOrder: f.opts.ChannelOrder,
+	}
+	// AUX channels start low, like switches in their off position
+	for ii := range f.sticks.Channels {
+		f.sticks.Channels[ii] = rx.RxLow
+	}
+	if wasSimulatingRX && f.opts.ResumeRXSimulation {
+		// Sticks and channels start from their initial values,
+		// so the board won't be armed by resuming.
+		f.printf("Resuming RX simulation\n")
+		f.ToggleRXSimulation()
 	}
 }
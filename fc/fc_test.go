@@ -0,0 +1,119 @@
+package fc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/fiam/msp-tool/msp"
+	"github.com/fiam/msp-tool/rx"
+)
+
+// pipeFC is an FC connected to a fake board via a pipe
+type pipeFC struct {
+	*FC
+	// board is the other end of the FC connection
+	board  *msp.MSP
+	remote net.Conn
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// newPipeFC returns an FC talking to a fake board over a pipe, with
+// FC.Run() already running. Call close() when done with it.
+func newPipeFC(t *testing.T, opts FCOptions) *pipeFC {
+	if opts.Stdout == nil {
+		opts.Stdout = ioutil.Discard
+	}
+	local, remote := net.Pipe()
+	f := &FC{opts: opts, msp: msp.NewWithConn(local)}
+	f.msp.SetReusePayloads(opts.ReuseFrames)
+	f.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pipeFC{
+		FC:     f,
+		board:  msp.NewWithConn(remote),
+		remote: remote,
+		cancel: cancel,
+		done:   make(chan error, 1),
+	}
+	go func() {
+		p.done <- f.Run(ctx, nil)
+	}()
+	return p
+}
+
+func (p *pipeFC) close(t *testing.T) {
+	p.cancel()
+	if err := <-p.done; err != context.Canceled {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+	// Closing the connection rather than the MSP makes the
+	// goroutines reading from the board return.
+	p.remote.Close()
+}
+
+// fedConn is a connection which reads from r and records the data
+// written to it
+type fedConn struct {
+	r       io.Reader
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *fedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+func (c *fedConn) Close() error               { return nil }
+
+func (c *fedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written.Write(p)
+}
+
+func (c *fedConn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+// boardFrames returns the bytes sent by a board responding with the
+// frames written by fn
+func boardFrames(t *testing.T, fn func(board *msp.MSP) error) []byte {
+	conn := &fedConn{}
+	if err := fn(msp.NewWithConn(conn)); err != nil {
+		t.Fatal(err)
+	}
+	return conn.Written()
+}
+
+// newFedFC returns an FC which reads the given data and records what
+// it writes in the returned connection. Frames must be read and
+// handled by the caller.
+func newFedFC(data []byte) (*FC, *fedConn) {
+	conn := &fedConn{r: bytes.NewReader(data)}
+	f := &FC{opts: FCOptions{Stdout: ioutil.Discard}, msp: msp.NewWithConn(conn)}
+	f.reset()
+	return f, conn
+}
+
+func TestResetAUXChannelsLow(t *testing.T) {
+	f := &FC{opts: FCOptions{ChannelNames: map[int]string{5: "ARM"}}}
+	f.reset()
+	if s, want := f.RXChannelState(5), "ARM (CH5): LOW"; s != want {
+		t.Errorf("RXChannelState(5) = %q, want %q", s, want)
+	}
+	f.RX().Keypress(rx.RXKey1)
+	if s, want := f.RXChannelState(5), "ARM (CH5): HIGH"; s != want {
+		t.Errorf("RXChannelState(5) after toggling = %q, want %q", s, want)
+	}
+	payload := f.sticks.ToMSP([]uint8{0, 1, 3, 2}, 0)
+	for ii, v := range payload.Channels[5:] {
+		if v != rx.RxLow {
+			t.Errorf("AUX%d = %d, want %d", ii+2, v, rx.RxLow)
+		}
+	}
+}
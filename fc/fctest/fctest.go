@@ -0,0 +1,151 @@
+// Package fctest provides an in-memory fc.Controller, which allows
+// testing code using the fc package without a flight controller.
+package fctest
+
+import (
+	"sync"
+
+	"github.com/fiam/msp-tool/fc"
+	"github.com/fiam/msp-tool/rx"
+)
+
+// Call represents a method call received by FC
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// RX records the keys pressed via FC.RX()
+type RX struct {
+	mu   sync.Mutex
+	Keys []rx.RXKey
+}
+
+// Keypress implements rx.RX
+func (r *RX) Keypress(key rx.RXKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Keys = append(r.Keys, key)
+}
+
+// FC implements fc.Controller by recording every call and returning
+// the canned data and errors from its exported fields. The zero value
+// is ready to use.
+type FC struct {
+	mu    sync.Mutex
+	calls []Call
+
+	BoardInfo  fc.Info
+	RXKeys     RX
+	PIDs       []uint8
+	RebootErr  error
+	FlashErr   error
+	RXErr      error
+	PIDsErr    error
+	CloseErr   error
+	simulating bool
+}
+
+var _ fc.Controller = (*FC)(nil)
+
+func (f *FC) record(method string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls received so far, in order.
+func (f *FC) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([]Call, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+
+// Info returns f.BoardInfo
+func (f *FC) Info() fc.Info {
+	f.record("Info")
+	return f.BoardInfo
+}
+
+// WaitForInfo returns f.BoardInfo without blocking
+func (f *FC) WaitForInfo() fc.Info {
+	f.record("WaitForInfo")
+	return f.BoardInfo
+}
+
+// HasDetectedTargetName returns true iff f.BoardInfo has a target name
+func (f *FC) HasDetectedTargetName() bool {
+	f.record("HasDetectedTargetName")
+	return f.BoardInfo.TargetName != ""
+}
+
+// Reboot returns f.RebootErr
+func (f *FC) Reboot() error {
+	f.record("Reboot")
+	return f.RebootErr
+}
+
+// Flash returns f.FlashErr
+func (f *FC) Flash(srcDir string, targetName string) error {
+	f.record("Flash", srcDir, targetName)
+	return f.FlashErr
+}
+
+// FlashExisting returns f.FlashErr
+func (f *FC) FlashExisting(srcDir string, targetName string) error {
+	f.record("FlashExisting", srcDir, targetName)
+	return f.FlashErr
+}
+
+// IsSimulatingRX returns true iff RX simulation has been toggled on
+func (f *FC) IsSimulatingRX() bool {
+	f.record("IsSimulatingRX")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.simulating
+}
+
+// ToggleRXSimulation toggles the simulation state, unless f.RXErr
+// is non nil.
+func (f *FC) ToggleRXSimulation() (enabled bool, err error) {
+	f.record("ToggleRXSimulation")
+	if f.RXErr != nil {
+		return false, f.RXErr
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.simulating = !f.simulating
+	return f.simulating, nil
+}
+
+// RX returns &f.RXKeys
+func (f *FC) RX() rx.RX {
+	f.record("RX")
+	return &f.RXKeys
+}
+
+// GetPIDs returns f.PIDsErr
+func (f *FC) GetPIDs() error {
+	f.record("GetPIDs")
+	return f.PIDsErr
+}
+
+// SetPIDs stores pids in f.PIDs, unless f.PIDsErr is non nil.
+func (f *FC) SetPIDs(pids []uint8) error {
+	f.record("SetPIDs", pids)
+	if f.PIDsErr != nil {
+		return f.PIDsErr
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PIDs = append([]uint8(nil), pids...)
+	return nil
+}
+
+// Close returns f.CloseErr
+func (f *FC) Close() error {
+	f.record("Close")
+	return f.CloseErr
+}
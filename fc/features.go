@@ -0,0 +1,60 @@
+package fc
+
+import (
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// FeatureMask contains the features enabled in the board, one per bit.
+// Current firmwares send 32 bits in MSP_FEATURE, but newer ones might
+// append another 32, so the storage is wider.
+type FeatureMask uint64
+
+// Has returns true iff all the features in mask are enabled
+func (m FeatureMask) Has(mask FeatureMask) bool {
+	return m&mask == mask
+}
+
+// debugTraceFeatureBits is the bit of FEATURE_DEBUG_TRACE in each
+// firmware variant supporting it.
+var debugTraceFeatureBits = map[string]uint{
+	"INAV": 31,
+}
+
+// debugTraceFeature returns the FEATURE_DEBUG_TRACE bit for the given
+// variant, or zero if it doesn't support it.
+func debugTraceFeature(variant string) FeatureMask {
+	if bit, ok := debugTraceFeatureBits[variant]; ok {
+		return 1 << bit
+	}
+	return 0
+}
+
+// readFeatures decodes the MSP_FEATURE payload, returning the mask and
+// its width in bytes, which must be preserved when sending it back.
+func readFeatures(fr *msp.MSPFrame) (FeatureMask, int, error) {
+	var low uint32
+	if err := fr.Read(&low); err != nil {
+		return 0, 0, err
+	}
+	var high uint32
+	if fr.Read(&high) != nil {
+		return FeatureMask(low), 4, nil
+	}
+	return FeatureMask(high)<<32 | FeatureMask(low), 8, nil
+}
+
+// featureArgs returns the MSP_SET_FEATURE arguments for the mask,
+// using the same width the board used in MSP_FEATURE.
+func featureArgs(features FeatureMask, width int) []interface{} {
+	args := []interface{}{uint32(features)}
+	if width > 4 {
+		args = append(args, uint32(features>>32))
+	}
+	return args
+}
+
+func (m FeatureMask) format(width int) string {
+	return fmt.Sprintf("0x%0*x", width*2, uint64(m))
+}
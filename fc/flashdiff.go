@@ -0,0 +1,20 @@
+package fc
+
+// diffPreFlashConfig compares the settings read before flashing with
+// the current ones and prints the differences.
+func (f *FC) diffPreFlashConfig(before *ConfigSnapshot) {
+	after, err := f.CaptureConfig()
+	if err != nil {
+		f.printf("Could not read the configuration after flashing: %v\n", err)
+		return
+	}
+	diffs := before.Diff(after)
+	if len(diffs) == 0 {
+		f.printf("No configuration changes after flashing\n")
+		return
+	}
+	f.printf("Configuration changes after flashing:\n")
+	for _, d := range diffs {
+		f.printf("  %s\n", d)
+	}
+}
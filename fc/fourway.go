@@ -0,0 +1,69 @@
+package fc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Enter4WayInterface switches the MSP port into the BLHeli 4-way
+// interface mode, used to configure and flash ESCs, returning the
+// number of ESCs detected by the board. Note that while in 4-way mode
+// the board doesn't speak MSP, so frame reading by StartUpdating is
+// suspended until Exit4WayInterface is called.
+func (f *FC) Enter4WayInterface() (escCount uint8, err error) {
+	if f.isIn4WayMode() {
+		return 0, errors.New("already in 4-way interface mode")
+	}
+	fr, err := f.request(msp.MspSet4WayIF)
+	if err != nil {
+		return 0, err
+	}
+	if err := fr.Read(&escCount); err != nil {
+		return 0, err
+	}
+	return escCount, nil
+}
+
+// Exit4WayInterface returns the port to MSP mode after a call to
+// Enter4WayInterface and resumes frame reading.
+func (f *FC) Exit4WayInterface() error {
+	if !f.isIn4WayMode() {
+		return errors.New("not in 4-way interface mode")
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Exit4WayInterface()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-time.After(requestTimeout):
+		// Closing the port unblocks the goroutine above and
+		// makes StartUpdating reconnect to the board.
+		f.closePort(m)
+		f.set4WayMode(false)
+		return errors.New("timed out waiting for the 4-way interface to exit")
+	}
+	f.set4WayMode(false)
+	return nil
+}
+
+func (f *FC) isIn4WayMode() bool {
+	f.modeMu.Lock()
+	defer f.modeMu.Unlock()
+	return f.in4WayMode
+}
+
+func (f *FC) set4WayMode(in4WayMode bool) {
+	f.modeMu.Lock()
+	f.in4WayMode = in4WayMode
+	f.modeMu.Unlock()
+}
@@ -0,0 +1,145 @@
+package fc
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	defaultIMUSampleInterval = 20 * time.Millisecond
+	// The graph is redrawn at this rate regardless of the sample
+	// rate, so it doesn't flood the terminal.
+	imuMonitorDisplayInterval = 250 * time.Millisecond
+	// Number of samples shown in each graph
+	imuSparklineWidth = 40
+)
+
+var sparklineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// RawIMU returns the raw accelerometer, gyro and magnetometer readings
+func (f *FC) RawIMU() (msp.MSPRawIMU, error) {
+	var imu msp.MSPRawIMU
+	fr, err := f.request(msp.MspRawIMU)
+	if err != nil {
+		return imu, err
+	}
+	err = fr.Read(&imu)
+	return imu, err
+}
+
+func decodeRawIMU(fr *msp.MSPFrame) string {
+	var imu msp.MSPRawIMU
+	if fr.Read(&imu) != nil {
+		return ""
+	}
+	return fmt.Sprintf("acc=%v gyro=%v mag=%v", imu.Acc, imu.Gyro, imu.Mag)
+}
+
+func magnitude(v [3]int16) float64 {
+	x, y, z := float64(v[0]), float64(v[1]), float64(v[2])
+	return math.Sqrt(x*x + y*y + z*z)
+}
+
+// imuHistory keeps the last imuSparklineWidth magnitudes of a sensor
+type imuHistory struct {
+	values []float64
+}
+
+func (h *imuHistory) add(v float64) {
+	h.values = append(h.values, v)
+	if len(h.values) > imuSparklineWidth {
+		h.values = h.values[len(h.values)-imuSparklineWidth:]
+	}
+}
+
+// sparkline returns the history as a line of block characters,
+// scaled between its minimum and maximum values, followed by the
+// last value.
+func (h *imuHistory) sparkline() string {
+	if len(h.values) == 0 {
+		return ""
+	}
+	min, max := h.values[0], h.values[0]
+	for _, v := range h.values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	var buf bytes.Buffer
+	for _, v := range h.values {
+		level := 0
+		if max > min {
+			level = int((v - min) / (max - min) * float64(len(sparklineLevels)-1))
+		}
+		buf.WriteRune(sparklineLevels[level])
+	}
+	for ii := len(h.values); ii < imuSparklineWidth; ii++ {
+		buf.WriteByte(' ')
+	}
+	fmt.Fprintf(&buf, " %6.0f", h.values[len(h.values)-1])
+	return buf.String()
+}
+
+// IsMonitoringIMU returns true iff the IMU monitor started by
+// ToggleIMUMonitor is running.
+func (f *FC) IsMonitoringIMU() bool {
+	return f.imuMonitorStop != nil
+}
+
+// ToggleIMUMonitor starts or stops polling MSP_RAW_IMU and printing
+// a rolling graph of the gyro and accelerometer magnitudes, useful
+// for spotting vibrations. The graph is printed as complete lines,
+// so it can be interleaved with any other output.
+func (f *FC) ToggleIMUMonitor() (enabled bool) {
+	if f.imuMonitorStop != nil {
+		close(f.imuMonitorStop)
+		f.imuMonitorStop = nil
+		return false
+	}
+	interval := f.opts.IMUSampleInterval
+	if interval <= 0 {
+		interval = defaultIMUSampleInterval
+	}
+	stop := make(chan struct{})
+	f.imuMonitorStop = stop
+	go f.monitorIMU(interval, stop)
+	return true
+}
+
+func (f *FC) monitorIMU(interval time.Duration, stop chan struct{}) {
+	sample := time.NewTicker(interval)
+	defer sample.Stop()
+	display := time.NewTicker(imuMonitorDisplayInterval)
+	defer display.Stop()
+	var gyro, acc imuHistory
+	var lastErr string
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sample.C:
+			if f.port() == nil || f.isPassthrough() {
+				continue
+			}
+			imu, err := f.RawIMU()
+			if err != nil {
+				// Don't repeat the same error at the sample rate
+				if msg := err.Error(); msg != lastErr {
+					f.printf("Error reading IMU: %v\n", err)
+					lastErr = msg
+				}
+				continue
+			}
+			lastErr = ""
+			gyro.add(magnitude(imu.Gyro))
+			acc.add(magnitude(imu.Acc))
+		case <-display.C:
+			if len(gyro.values) > 0 {
+				f.printf("IMU gyro %s  acc %s\n", gyro.sparkline(), acc.sparkline())
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package fc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+func TestDecodeRawIMU(t *testing.T) {
+	payload := []byte{
+		0x00, 0x02, 0x00, 0xfe, 0x10, 0x00, // acc 512, -512, 16
+		0xff, 0xff, 0x02, 0x00, 0xfd, 0xff, // gyro -1, 2, -3
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // mag 0, 0, 0
+	}
+	fr := &msp.MSPFrame{Code: msp.MspRawIMU, Payload: payload}
+	want := "acc=[512 -512 16] gyro=[-1 2 -3] mag=[0 0 0]"
+	if s := decodeRawIMU(fr); s != want {
+		t.Errorf("decodeRawIMU() = %q, want %q", s, want)
+	}
+	short := &msp.MSPFrame{Code: msp.MspRawIMU, Payload: payload[:10]}
+	if s := decodeRawIMU(short); s != "" {
+		t.Errorf("decodeRawIMU() with a short payload = %q, want \"\"", s)
+	}
+}
+
+func TestMagnitude(t *testing.T) {
+	if m := magnitude([3]int16{3, -4, 12}); m != 13 {
+		t.Errorf("magnitude() = %v, want 13", m)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	var h imuHistory
+	if s := h.sparkline(); s != "" {
+		t.Errorf("empty sparkline() = %q, want \"\"", s)
+	}
+	for _, v := range []float64{0, 70, 35} {
+		h.add(v)
+	}
+	s := h.sparkline()
+	if !strings.HasPrefix(s, "▁█▄") {
+		t.Errorf("sparkline() = %q, want it to start with \"▁█▄\"", s)
+	}
+	if !strings.HasSuffix(s, "     35") {
+		t.Errorf("sparkline() = %q, want it to end with the last value", s)
+	}
+	for ii := 0; ii < 2*imuSparklineWidth; ii++ {
+		h.add(float64(ii))
+	}
+	if n := len(h.values); n != imuSparklineWidth {
+		t.Errorf("history has %d values, want %d", n, imuSparklineWidth)
+	}
+	// The graph keeps its width while the history fills up
+	if n := utf8.RuneCountInString(h.sparkline()); n != imuSparklineWidth+7 {
+		t.Errorf("sparkline() has %d characters, want %d", n, imuSparklineWidth+7)
+	}
+}
@@ -0,0 +1,116 @@
+package fc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// variantAliases maps lowercase firmware names to the identifiers
+// reported by MSP_FC_VARIANT.
+var variantAliases = map[string]string{
+	"inav":        "INAV",
+	"betaflight":  "BTFL",
+	"bf":          "BTFL",
+	"cleanflight": "CLFL",
+	"cf":          "CLFL",
+}
+
+// NormalizeVariant returns the MSP_FC_VARIANT identifier for the
+// given firmware name (e.g. "betaflight" returns "BTFL"). Unknown
+// names are returned in uppercase.
+func NormalizeVariant(variant string) string {
+	variant = strings.TrimSpace(variant)
+	if v, ok := variantAliases[strings.ToLower(variant)]; ok {
+		return v
+	}
+	return strings.ToUpper(variant)
+}
+
+const (
+	variantLength = 4
+	// Maximum number of times MSP_FC_VARIANT is requested again
+	// when the board sends an invalid variant and the delay between
+	// requests.
+	maxVariantRetries = 10
+	variantRetryDelay = 200 * time.Millisecond
+)
+
+// isValidVariant returns true iff variant looks like a valid
+// MSP_FC_VARIANT identifier, which is always 4 letters.
+func isValidVariant(variant string) bool {
+	if len(variant) != variantLength {
+		return false
+	}
+	for _, c := range variant {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// retryVariant requests MSP_FC_VARIANT again after the board sent an
+// invalid one, extending the timeout for printing the board info.
+func (f *FC) retryVariant(variant string) {
+	f.infoMu.Lock()
+	defer f.infoMu.Unlock()
+	if f.variantRetries >= maxVariantRetries {
+		f.printf("Board didn't send a valid firmware variant (got %q)\n", variant)
+		return
+	}
+	if f.variantRetries == 0 {
+		f.printf("Received invalid firmware variant %q, waiting for a valid one...\n", variant)
+	}
+	f.variantRetries++
+	if f.infoTimer != nil {
+		f.infoTimer.Reset(infoTimeout)
+	}
+	time.AfterFunc(variantRetryDelay, func() {
+		if m := f.port(); m != nil {
+			m.WriteCmd(msp.MspFCVariant)
+		}
+	})
+}
+
+// Version returns the firmware version formatted as major.minor.patch
+func (i Info) Version() string {
+	return fmt.Sprintf("%d.%d.%d", i.VersionMajor, i.VersionMinor, i.VersionPatch)
+}
+
+// atLeast returns true iff the board runs at least version
+// major.minor of its firmware.
+func (i Info) atLeast(major, minor byte) bool {
+	if i.VersionMajor != major {
+		return i.VersionMajor > major
+	}
+	return i.VersionMinor >= minor
+}
+
+// Check returns an error if the board identity doesn't match the
+// given variant and version. Empty arguments match any board. Variants
+// are compared after normalizing them with NormalizeVariant, while
+// version might be a prefix (e.g. "2.1" matches both 2.1.0 and 2.1.2).
+func (i Info) Check(variant string, version string) error {
+	if variant != "" {
+		expected := NormalizeVariant(variant)
+		if i.Variant == "" {
+			return ErrNoIdentity
+		}
+		if NormalizeVariant(i.Variant) != expected {
+			return &IdentityMismatchError{Field: "variant", Expected: expected, Actual: i.Variant}
+		}
+	}
+	if version != "" {
+		if i.VersionMajor == 0 && i.VersionMinor == 0 && i.VersionPatch == 0 {
+			return ErrNoIdentity
+		}
+		v := i.Version()
+		if v != version && !strings.HasPrefix(v, version+".") {
+			return &IdentityMismatchError{Field: "version", Expected: version, Actual: v}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package fc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+func TestIsValidVariant(t *testing.T) {
+	tests := []struct {
+		variant string
+		valid   bool
+	}{
+		{"BTFL", true},
+		{"INAV", true},
+		{"cflt", true},
+		{"", false},
+		{"BTF", false},
+		{"BTFL1", false},
+		{"BT\x00L", false},
+		{"1234", false},
+	}
+	for _, tt := range tests {
+		if valid := isValidVariant(tt.variant); valid != tt.valid {
+			t.Errorf("isValidVariant(%q) = %v, want %v", tt.variant, valid, tt.valid)
+		}
+	}
+}
+
+func variantFrame(variant string) *msp.MSPFrame {
+	return &msp.MSPFrame{Code: msp.MspFCVariant, Payload: []byte(variant)}
+}
+
+func TestInvalidVariantIsRetried(t *testing.T) {
+	data := boardFrames(t, func(board *msp.MSP) error {
+		if _, err := board.WriteResponse(msp.MspFCVariant, []byte{}); err != nil {
+			return err
+		}
+		_, err := board.WriteResponse(msp.MspFCVariant, []byte("BTFL"))
+		return err
+	})
+	f, conn := newFedFC(data)
+
+	fr, err := f.msp.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.handleFrame(fr, nil)
+	if v := f.Info().Variant; v != "" {
+		t.Fatalf("Variant after an empty MSP_FC_VARIANT = %q, want \"\"", v)
+	}
+	time.Sleep(2 * variantRetryDelay)
+	request := boardFrames(t, func(board *msp.MSP) error {
+		_, err := board.WriteCmd(msp.MspFCVariant)
+		return err
+	})
+	if !bytes.Contains(conn.Written(), request) {
+		t.Errorf("MSP_FC_VARIANT wasn't requested again, wrote % x", conn.Written())
+	}
+
+	fr, err = f.msp.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.handleFrame(fr, nil)
+	if v := f.Info().Variant; v != "BTFL" {
+		t.Errorf("Variant = %q, want \"BTFL\"", v)
+	}
+	if f.variantRetries != 1 {
+		t.Errorf("variantRetries = %d, want 1", f.variantRetries)
+	}
+}
+
+func TestVariantRetriesAreLimited(t *testing.T) {
+	f := &FC{opts: FCOptions{Stdout: ioutil.Discard}}
+	f.reset()
+	for ii := 0; ii < maxVariantRetries+5; ii++ {
+		f.handleFrame(variantFrame(""), nil)
+	}
+	if f.variantRetries != maxVariantRetries {
+		t.Errorf("variantRetries = %d, want %d", f.variantRetries, maxVariantRetries)
+	}
+}
@@ -0,0 +1,82 @@
+package fc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// bluetoothKeepaliveInterval is the default keepalive interval
+	// for Bluetooth ports, since most modules drop idle links.
+	bluetoothKeepaliveInterval = 2 * time.Second
+	// defaultReadTimeout is the time without receiving data after
+	// which we check whether the port is still present
+	defaultReadTimeout = time.Second
+	// bluetoothReadTimeout is the default read timeout for Bluetooth
+	// ports, which often stall for a while without disconnecting.
+	// It's longer than bluetoothKeepaliveInterval, so an idle link
+	// gets a response to the keepalive before timing out.
+	bluetoothReadTimeout = 5 * time.Second
+	// bluetoothReconnectDelay is the delay between reconnection
+	// attempts for Bluetooth ports. Each attempt might take a while
+	// and retrying too fast only makes it slower.
+	bluetoothReconnectDelay = time.Second
+)
+
+// isBluetoothPort returns true iff the port in the options is a
+// Bluetooth serial port.
+func (f *FC) isBluetoothPort() bool {
+	return f.opts.Bluetooth || strings.HasPrefix(f.opts.PortName, "/dev/rfcomm")
+}
+
+func (f *FC) keepaliveInterval() time.Duration {
+	if f.opts.KeepaliveInterval == 0 && f.isBluetoothPort() {
+		return bluetoothKeepaliveInterval
+	}
+	return f.opts.KeepaliveInterval
+}
+
+func (f *FC) readTimeout() time.Duration {
+	if f.opts.ReadTimeout == 0 {
+		if f.isBluetoothPort() {
+			return bluetoothReadTimeout
+		}
+		return defaultReadTimeout
+	}
+	return f.opts.ReadTimeout
+}
+
+func (f *FC) reconnectDelay() time.Duration {
+	if f.isBluetoothPort() {
+		return bluetoothReconnectDelay
+	}
+	if f.isTCPPort() {
+		return tcpReconnectDelay
+	}
+	return time.Millisecond
+}
+
+// keepalive sends a request to the board when no frames have been
+// received for the keepalive interval, until ctx is cancelled.
+func (f *FC) keepalive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		f.stats.mu.Lock()
+		idle := time.Since(f.stats.lastFrame)
+		f.stats.mu.Unlock()
+		if idle < interval || f.port() == nil || f.isPassthrough() {
+			continue
+		}
+		// Use request() to consume the response
+		f.request(msp.MspAPIVersion)
+	}
+}
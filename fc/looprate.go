@@ -0,0 +1,79 @@
+package fc
+
+import (
+	"errors"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// Gyro sample rates in Hz used by BF. Note that some gyros
+	// (e.g. BMI270) use a different rate, so this is an approximation.
+	gyroSampleRate      = 8000
+	gyroSampleRate32kHz = 32000
+)
+
+var errLoopRateUnsupported = errors.New("PID loop rate is only supported by Betaflight and INAV")
+
+// loopRateCode returns the MSP command used for retrieving the PID loop
+// rate for the given variant, or zero if it's not supported.
+func loopRateCode(variant string) uint16 {
+	switch variant {
+	case "BTFL":
+		return msp.MspAdvancedConfig
+	case "INAV":
+		// INAV sends fixed values in the MSP_ADVANCED_CONFIG denominators
+		return msp.MspLoopTime
+	}
+	return 0
+}
+
+// decodeLoopRate returns the PID loop rate in Hz from a response to the
+// command returned by loopRateCode() sent by a board with the given info.
+func decodeLoopRate(info Info, fr *msp.MSPFrame) (int, error) {
+	switch fr.Code {
+	case msp.MspAdvancedConfig:
+		var cfg msp.MSPAdvancedConfig
+		if err := fr.Read(&cfg); err != nil {
+			return 0, err
+		}
+		if cfg.GyroSyncDenom == 0 || cfg.PIDProcessDenom == 0 {
+			return 0, errors.New("invalid PID loop denominators")
+		}
+		rate := gyroSampleRate
+		// BF 3.2 to 4.0 send whether the gyro runs at 32kHz, later
+		// versions always send zero.
+		if info.atLeast(3, 2) && !info.atLeast(4, 1) {
+			var use32kHz uint8
+			if err := fr.Read(&use32kHz); err == nil && use32kHz != 0 {
+				rate = gyroSampleRate32kHz
+			}
+		}
+		return rate / int(cfg.GyroSyncDenom) / int(cfg.PIDProcessDenom), nil
+	case msp.MspLoopTime:
+		var looptime uint16
+		if err := fr.Read(&looptime); err != nil {
+			return 0, err
+		}
+		if looptime == 0 {
+			return 0, errors.New("invalid looptime")
+		}
+		return 1000000 / int(looptime), nil
+	}
+	return 0, errLoopRateUnsupported
+}
+
+// PIDLoopRate returns the frequency of the PID loop in Hz, as
+// configured in the board.
+func (f *FC) PIDLoopRate() (hz int, err error) {
+	info := f.Info()
+	code := loopRateCode(info.Variant)
+	if code == 0 {
+		return 0, errLoopRateUnsupported
+	}
+	fr, err := f.request(code)
+	if err != nil {
+		return 0, err
+	}
+	return decodeLoopRate(info, fr)
+}
@@ -0,0 +1,118 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// PlatformType is the type of aircraft configured in INAV, see
+// flyingPlatformType_e.
+type PlatformType uint8
+
+const (
+	PlatformMultirotor PlatformType = iota
+	PlatformAirplane
+	PlatformHelicopter
+	PlatformTricopter
+	PlatformRover
+	PlatformBoat
+	PlatformOther
+)
+
+var platformTypeNames = []string{
+	"MULTIROTOR",
+	"AIRPLANE",
+	"HELICOPTER",
+	"TRICOPTER",
+	"ROVER",
+	"BOAT",
+	"OTHER",
+}
+
+func (p PlatformType) String() string {
+	if int(p) < len(platformTypeNames) {
+		return platformTypeNames[p]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(p))
+}
+
+// MixerConfig is the INAV mixer configuration, as sent in
+// MSP2_INAV_MIXER. Note that motor stop is a feature rather than
+// a mixer setting.
+type MixerConfig struct {
+	MotorDirectionInverted bool
+	PlatformType           PlatformType
+	HasFlaps               bool
+	AppliedMixerPreset     uint16
+	// Read only, ignored by SetMixerConfig()
+	MaxMotors uint8
+	MaxServos uint8
+}
+
+// mixerPayload is the wire format of MSP2_INAV_MIXER and
+// MSP2_INAV_SET_MIXER
+type mixerPayload struct {
+	MotorDirectionInverted uint8
+	Unused                 uint16 // Was yaw_jump_prevention_limit
+	PlatformType           uint8
+	HasFlaps               uint8
+	AppliedMixerPreset     uint16
+	MaxMotors              uint8
+	MaxServos              uint8
+}
+
+var errMixerUnsupported = errors.New("mixer configuration is only supported by INAV")
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GetMixerConfig returns the mixer configuration
+func (f *FC) GetMixerConfig() (*MixerConfig, error) {
+	if f.Info().Variant != "INAV" {
+		return nil, errMixerUnsupported
+	}
+	fr, err := f.requestV2(msp.Msp2INAVMixer)
+	if err != nil {
+		return nil, err
+	}
+	var p mixerPayload
+	if err := fr.Read(&p); err != nil {
+		return nil, err
+	}
+	return &MixerConfig{
+		MotorDirectionInverted: p.MotorDirectionInverted != 0,
+		PlatformType:           PlatformType(p.PlatformType),
+		HasFlaps:               p.HasFlaps != 0,
+		AppliedMixerPreset:     p.AppliedMixerPreset,
+		MaxMotors:              p.MaxMotors,
+		MaxServos:              p.MaxServos,
+	}, nil
+}
+
+// SetMixerConfig updates the mixer configuration and then writes it
+// to the EEPROM. Changing the platform type requires a reboot.
+func (f *FC) SetMixerConfig(cfg *MixerConfig) error {
+	if f.Info().Variant != "INAV" {
+		return errMixerUnsupported
+	}
+	if int(cfg.PlatformType) >= len(platformTypeNames) {
+		return fmt.Errorf("invalid platform type %d", uint8(cfg.PlatformType))
+	}
+	p := mixerPayload{
+		MotorDirectionInverted: boolToUint8(cfg.MotorDirectionInverted),
+		PlatformType:           uint8(cfg.PlatformType),
+		HasFlaps:               boolToUint8(cfg.HasFlaps),
+		AppliedMixerPreset:     cfg.AppliedMixerPreset,
+	}
+	if _, err := f.requestV2(msp.Msp2INAVSetMixer, p); err != nil {
+		return err
+	}
+	_, err := f.request(msp.MspEepromWrite)
+	return err
+}
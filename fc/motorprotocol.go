@@ -0,0 +1,100 @@
+package fc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// MotorProtocol is the motor output protocol configured in the board
+type MotorProtocol struct {
+	// Value is the motorPwmProtocolTypes_e value sent by the board
+	Value uint8
+	// Name is the protocol name (e.g. DSHOT600) or UNKNOWN(n)
+	Name string
+	// PWMRate is the update rate in Hz, only used by analog protocols
+	PWMRate uint16
+}
+
+// IsDigital returns true iff the protocol is a digital one (DSHOT,
+// PROSHOT or SERIALSHOT), which ESCs don't need to be calibrated for.
+func (p MotorProtocol) IsDigital() bool {
+	return strings.Contains(p.Name, "SHOT") && !strings.HasPrefix(p.Name, "ONESHOT") && !strings.HasPrefix(p.Name, "MULTISHOT")
+}
+
+func (p MotorProtocol) String() string {
+	if p.IsDigital() || p.Name == "BRUSHED" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s @ %dHz", p.Name, p.PWMRate)
+}
+
+// motorProtocolNames returns the names of the motorPwmProtocolTypes_e
+// values for the firmware in info. INAV 3.0 dropped ONESHOT42,
+// DSHOT1200 and SERIALSHOT.
+func motorProtocolNames(info Info) []string {
+	if info.Variant == "INAV" && info.atLeast(3, 0) {
+		return []string{"PWM", "ONESHOT125", "MULTISHOT", "BRUSHED", "DSHOT150", "DSHOT300", "DSHOT600"}
+	}
+	names := []string{"PWM", "ONESHOT125", "ONESHOT42", "MULTISHOT", "BRUSHED", "DSHOT150", "DSHOT300", "DSHOT600", "DSHOT1200"}
+	switch info.Variant {
+	case "BTFL":
+		names = append(names, "PROSHOT1000")
+	case "INAV":
+		names = append(names, "SERIALSHOT")
+	}
+	return names
+}
+
+// decodeMotorProtocol decodes the motor protocol from a response to
+// MSP_ADVANCED_CONFIG sent by a board with the given info
+func decodeMotorProtocol(info Info, fr *msp.MSPFrame) (MotorProtocol, error) {
+	var cfg msp.MSPAdvancedConfig
+	if err := fr.Read(&cfg); err != nil {
+		return MotorProtocol{}, err
+	}
+	p := MotorProtocol{
+		Value:   cfg.MotorPWMProtocol,
+		Name:    fmt.Sprintf("UNKNOWN(%d)", cfg.MotorPWMProtocol),
+		PWMRate: cfg.MotorPWMRate,
+	}
+	if names := motorProtocolNames(info); int(p.Value) < len(names) {
+		p.Name = names[p.Value]
+	}
+	return p, nil
+}
+
+// MotorProtocol returns the motor output protocol configured in the
+// board, from MSP_ADVANCED_CONFIG.
+func (f *FC) MotorProtocol() (MotorProtocol, error) {
+	fr, err := f.request(msp.MspAdvancedConfig)
+	if err != nil {
+		return MotorProtocol{}, err
+	}
+	return decodeMotorProtocol(f.Info(), fr)
+}
+
+// PrintMotorProtocol prints the motor output protocol
+func (f *FC) PrintMotorProtocol() error {
+	p, err := f.MotorProtocol()
+	if err != nil {
+		return err
+	}
+	f.printf("Motor protocol: %s\n", p)
+	return nil
+}
+
+// checkMotorProtocol compares the motor protocol before flashing with
+// the current one, warning if it changed, since the ESCs might not
+// support the new one and the motors won't spin.
+func (f *FC) checkMotorProtocol(before MotorProtocol) {
+	after, err := f.MotorProtocol()
+	if err != nil {
+		f.printf("Could not read the motor protocol after flashing: %v\n", err)
+		return
+	}
+	if after.Name != before.Name {
+		f.printf("WARNING: motor protocol changed from %s to %s after flashing, make sure the ESCs support it\n", before.Name, after.Name)
+	}
+}
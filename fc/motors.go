@@ -0,0 +1,83 @@
+package fc
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// decodeMotorValues decodes a MSP_MOTOR frame. Boards with fewer than 8
+// motors send zero for the rest.
+func decodeMotorValues(fr *msp.MSPFrame) ([motorTestMotors]uint16, error) {
+	var motors [motorTestMotors]uint16
+	count := len(fr.Payload) / 2
+	if count > len(motors) {
+		count = len(motors)
+	}
+	err := fr.Read(motors[:count])
+	return motors, err
+}
+
+// formatMotors returns the motor values, omitting the trailing zeros
+func formatMotors(motors [motorTestMotors]uint16) string {
+	n := len(motors)
+	for n > 0 && motors[n-1] == 0 {
+		n--
+	}
+	if n == 0 {
+		return "no motors"
+	}
+	var buf bytes.Buffer
+	for ii, v := range motors[:n] {
+		if ii > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%d:%d", ii+1, v)
+	}
+	return buf.String()
+}
+
+func decodeMotors(fr *msp.MSPFrame) string {
+	motors, err := decodeMotorValues(fr)
+	if err != nil {
+		return ""
+	}
+	return formatMotors(motors)
+}
+
+// updateMotors stores the values in a MSP_MOTOR frame, so they're
+// returned by Motors().
+func (f *FC) updateMotors(fr *msp.MSPFrame) ([motorTestMotors]uint16, error) {
+	motors, err := decodeMotorValues(fr)
+	if err != nil {
+		return motors, err
+	}
+	f.motorsMu.Lock()
+	f.motors = motors
+	f.motorsMu.Unlock()
+	return motors, nil
+}
+
+// Motors returns the last motor outputs received via MSP_MOTOR.
+// Motors not present in the board are zero.
+func (f *FC) Motors() [8]uint16 {
+	f.motorsMu.Lock()
+	defer f.motorsMu.Unlock()
+	return f.motors
+}
+
+// PrintMotors requests the motor outputs from the board and prints
+// them, e.g. for checking the mixer while simulating RX.
+func (f *FC) PrintMotors() error {
+	fr, err := f.request(msp.MspMotor)
+	if err != nil {
+		return err
+	}
+	motors, err := f.updateMotors(fr)
+	if err != nil {
+		return err
+	}
+	f.printf("Motors %s\n", formatMotors(motors))
+	return nil
+}
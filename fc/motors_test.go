@@ -0,0 +1,76 @@
+package fc
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+func motorPayload(values ...uint16) []byte {
+	payload := make([]byte, 2*len(values))
+	for ii, v := range values {
+		binary.LittleEndian.PutUint16(payload[2*ii:], v)
+	}
+	return payload
+}
+
+func TestDecodeMotors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    [motorTestMotors]uint16
+		text    string
+	}{
+		{
+			name:    "4 motors",
+			payload: motorPayload(1000, 1100, 1200, 1300, 0, 0, 0, 0),
+			want:    [motorTestMotors]uint16{1000, 1100, 1200, 1300},
+			text:    "1:1000 2:1100 3:1200 4:1300",
+		},
+		{
+			name:    "8 motors",
+			payload: motorPayload(1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008),
+			want:    [motorTestMotors]uint16{1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008},
+			text:    "1:1001 2:1002 3:1003 4:1004 5:1005 6:1006 7:1007 8:1008",
+		},
+		{
+			// Old firmwares only send the motors they use
+			name:    "short",
+			payload: motorPayload(1500, 1600),
+			want:    [motorTestMotors]uint16{1500, 1600},
+			text:    "1:1500 2:1600",
+		},
+		{
+			// A trailing byte can't be a motor
+			name:    "odd length",
+			payload: append(motorPayload(1500), 0x05),
+			want:    [motorTestMotors]uint16{1500},
+			text:    "1:1500",
+		},
+		{
+			name: "empty",
+			text: "no motors",
+		},
+		{
+			// Values after the 8th motor are ignored
+			name:    "long",
+			payload: motorPayload(1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+			want:    [motorTestMotors]uint16{1, 2, 3, 4, 5, 6, 7, 8},
+			text:    "1:1 2:2 3:3 4:4 5:5 6:6 7:7 8:8",
+		},
+	}
+	for _, tt := range tests {
+		motors, err := decodeMotorValues(&msp.MSPFrame{Code: msp.MspMotor, Payload: tt.payload})
+		if err != nil {
+			t.Errorf("%s: decodeMotorValues() = %v", tt.name, err)
+			continue
+		}
+		if motors != tt.want {
+			t.Errorf("%s: decodeMotorValues() = %v, want %v", tt.name, motors, tt.want)
+		}
+		if text := formatMotors(motors); text != tt.text {
+			t.Errorf("%s: formatMotors() = %q, want %q", tt.name, text, tt.text)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// MSP_SET_MOTOR always carries 8 motors
+	motorTestMotors = 8
+	motorTestMin    = 1000
+	motorTestMax    = 2000
+	// defaultMotorTestWatchdog is the time after the last SetMotor()
+	// call after which all the motors are stopped
+	defaultMotorTestWatchdog = 2 * time.Second
+)
+
+var errMotorTestNotArmed = errors.New("motor test is not armed, call ArmMotorTest() first")
+
+// motorTestState is the state of the motor test, see ArmMotorTest()
+type motorTestState struct {
+	armed    bool
+	values   [motorTestMotors]uint16
+	watchdog *time.Timer
+}
+
+func (f *FC) motorTestWatchdog() time.Duration {
+	if f.opts.MotorTestWatchdog > 0 {
+		return f.opts.MotorTestWatchdog
+	}
+	return defaultMotorTestWatchdog
+}
+
+// IsMotorTestArmed returns true iff SetMotor() is allowed to spin
+// the motors.
+func (f *FC) IsMotorTestArmed() bool {
+	f.motorTestMu.Lock()
+	defer f.motorTestMu.Unlock()
+	return f.motorTest.armed
+}
+
+// ArmMotorTest toggles the interlock which allows SetMotor() to spin
+// the motors, returning whether it's now armed. Disarming it stops
+// all the motors. Remove the props before arming it.
+func (f *FC) ArmMotorTest() (armed bool, err error) {
+	f.motorTestMu.Lock()
+	defer f.motorTestMu.Unlock()
+	if f.motorTest.armed {
+		f.motorTest.armed = false
+		return false, f.stopMotorsLocked()
+	}
+	if st := f.Status(); st.Armed() {
+		return false, errors.New("board is armed")
+	}
+	for ii := range f.motorTest.values {
+		f.motorTest.values[ii] = motorTestMin
+	}
+	f.motorTest.armed = true
+	return true, nil
+}
+
+// SetMotor sets the output of the motor at index (starting at 0) to
+// value, which is clamped to 1000-2000. The other motors keep their
+// previous values. If SetMotor() is not called again within
+// FCOptions.MotorTestWatchdog, all the motors are stopped.
+func (f *FC) SetMotor(index int, value uint16) error {
+	if index < 0 || index >= motorTestMotors {
+		return fmt.Errorf("invalid motor index %d, must be 0-%d", index, motorTestMotors-1)
+	}
+	if value < motorTestMin {
+		value = motorTestMin
+	} else if value > motorTestMax {
+		value = motorTestMax
+	}
+	f.motorTestMu.Lock()
+	defer f.motorTestMu.Unlock()
+	if !f.motorTest.armed {
+		return errMotorTestNotArmed
+	}
+	f.motorTest.values[index] = value
+	if err := f.sendMotorsLocked(); err != nil {
+		return err
+	}
+	if f.motorTest.watchdog != nil {
+		f.motorTest.watchdog.Stop()
+	}
+	var watchdog *time.Timer
+	watchdog = time.AfterFunc(f.motorTestWatchdog(), func() {
+		f.motorTestMu.Lock()
+		defer f.motorTestMu.Unlock()
+		// Stop() doesn't prevent the function from running if it
+		// already fired and was waiting for the lock, so ignore it
+		// if the watchdog was rearmed or stopped meanwhile.
+		if f.motorTest.watchdog != watchdog {
+			return
+		}
+		f.printf("No motor commands for %v, stopping the motors\n", f.motorTestWatchdog())
+		if err := f.stopMotorsLocked(); err != nil {
+			f.printf("Error stopping the motors: %v\n", err)
+		}
+	})
+	f.motorTest.watchdog = watchdog
+	return nil
+}
+
+// stopMotorsLocked sets all the motors to the minimum value. f.motorTestMu
+// must be held.
+func (f *FC) stopMotorsLocked() error {
+	if f.motorTest.watchdog != nil {
+		f.motorTest.watchdog.Stop()
+		f.motorTest.watchdog = nil
+	}
+	for ii := range f.motorTest.values {
+		f.motorTest.values[ii] = motorTestMin
+	}
+	return f.sendMotorsLocked()
+}
+
+// sendMotorsLocked sends MSP_SET_MOTOR with the current values.
+// f.motorTestMu must be held.
+func (f *FC) sendMotorsLocked() error {
+	values := f.motorTest.values
+	f.printf("%s %v\n", msp.CommandName(msp.MspSetMotor), values)
+	_, err := f.request(msp.MspSetMotor, values)
+	return err
+}
@@ -0,0 +1,47 @@
+package fc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// mscReconnectDelay is the delay between reconnection attempts
+// while the board is in mass storage mode. The serial port won't
+// come back until the board is rebooted, so there's no point in
+// retrying quickly.
+const mscReconnectDelay = time.Second
+
+// snapshotUSBDisks records the USB block devices present while the
+// board is connected, so the ones that appear after it disconnects
+// can be attributed to it.
+func (f *FC) snapshotUSBDisks() {
+	disks := make(map[string]bool)
+	for _, d := range usbDisks() {
+		disks[d] = true
+	}
+	f.usbDisks = disks
+}
+
+// newUSBDisk returns a USB block device which wasn't present while
+// the board was connected, or an empty string if there's none.
+func (f *FC) newUSBDisk() string {
+	for _, d := range usbDisks() {
+		if !f.usbDisks[d] {
+			return d
+		}
+	}
+	return ""
+}
+
+// inMSCMode returns true iff the board seems to have enumerated as
+// USB mass storage, either because it was rebooted into it or because
+// a USB block device appeared while its serial port is gone.
+func (f *FC) inMSCMode() bool {
+	if atomic.LoadInt32(&f.mscRequested) != 0 {
+		return true
+	}
+	if f.portIsPresent() {
+		return false
+	}
+	return f.newUSBDisk() != ""
+}
@@ -0,0 +1,21 @@
+package fc
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// usbDisks returns the whole disk devices. macOS doesn't tell the bus
+// from the device node, but a disk showing up while the board is
+// disconnected is still a good hint.
+func usbDisks() []string {
+	devices, _ := filepath.Glob("/dev/disk[0-9]*")
+	var disks []string
+	for _, d := range devices {
+		// Skip partitions, e.g. /dev/disk2s1
+		if !strings.Contains(strings.TrimPrefix(filepath.Base(d), "disk"), "s") {
+			disks = append(disks, d)
+		}
+	}
+	return disks
+}
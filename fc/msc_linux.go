@@ -0,0 +1,26 @@
+package fc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// usbDisks returns the block devices connected via USB
+func usbDisks() []string {
+	entries, err := ioutil.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+	var disks []string
+	for _, e := range entries {
+		// Entries are symlinks to the device, which includes the
+		// bus it's connected to in its path.
+		target, err := os.Readlink(filepath.Join("/sys/block", e.Name()))
+		if err == nil && strings.Contains(target, "/usb") {
+			disks = append(disks, "/dev/"+e.Name())
+		}
+	}
+	return disks
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package fc
+
+// usbDisks is not implemented in this platform, so the board is only
+// known to be in mass storage mode after rebooting it into it.
+func usbDisks() []string {
+	return nil
+}
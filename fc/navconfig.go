@@ -0,0 +1,89 @@
+package fc
+
+import (
+	"errors"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+var errNavConfigUnsupported = errors.New("navigation config is only supported by INAV 2.0+")
+
+// NavConfig contains the INAV navigation settings available via MSP
+type NavConfig struct {
+	Poshold msp.MSPNavPoshold
+	RTH     msp.MSPRTHAndLandConfig
+}
+
+func (f *FC) checkNavConfigSupported() error {
+	if info := f.Info(); info.Variant != "INAV" || !info.atLeast(2, 0) {
+		return errNavConfigUnsupported
+	}
+	return nil
+}
+
+// GetNavConfig returns the navigation configuration
+func (f *FC) GetNavConfig() (*NavConfig, error) {
+	if err := f.checkNavConfigSupported(); err != nil {
+		return nil, err
+	}
+	var cfg NavConfig
+	fr, err := f.request(msp.MspNavPoshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := fr.Read(&cfg.Poshold); err != nil {
+		return nil, err
+	}
+	if fr, err = f.request(msp.MspRTHAndLandConfig); err != nil {
+		return nil, err
+	}
+	if err := fr.Read(&cfg.RTH); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SetNavConfig updates the navigation configuration and then writes
+// it to the EEPROM. cfg should be obtained via GetNavConfig() and then
+// modified.
+func (f *FC) SetNavConfig(cfg *NavConfig) error {
+	if err := f.checkNavConfigSupported(); err != nil {
+		return err
+	}
+	if cfg.Poshold.MaxAutoSpeed == 0 || cfg.Poshold.MaxManualSpeed == 0 {
+		return errors.New("navigation speeds can't be zero")
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	if _, err := m.WriteCmd(msp.MspSetNavPoshold, cfg.Poshold); err != nil {
+		return err
+	}
+	if _, err := m.WriteCmd(msp.MspSetRTHAndLandConfig, cfg.RTH); err != nil {
+		return err
+	}
+	_, err := m.WriteCmd(msp.MspEepromWrite)
+	return err
+}
+
+// PrintNavConfig prints the navigation configuration
+func (f *FC) PrintNavConfig() error {
+	cfg, err := f.GetNavConfig()
+	if err != nil {
+		return err
+	}
+	p := &cfg.Poshold
+	f.printf("Nav speeds (cm/s): auto %d, auto climb %d, manual %d, manual climb %d\n",
+		p.MaxAutoSpeed, p.MaxAutoClimbRate, p.MaxManualSpeed, p.MaxManualClimbRate)
+	f.printf("Multirotor: max bank angle %d, hover throttle %d\n",
+		p.MCMaxBankAngle, p.MCHoverThrottle)
+	r := &cfg.RTH
+	f.printf("RTH: altitude %dcm, min distance %dcm, abort threshold %dcm, altitude mode %d\n",
+		r.RTHAltitude, r.MinRTHDistance, r.RTHAbortThreshold, r.RTHAltControlMode)
+	f.printf("RTH: climb first %v, tail first %v, allow landing %d\n",
+		r.RTHClimbFirst != 0, r.RTHTailFirst != 0, r.RTHAllowLanding)
+	f.printf("Landing (cm/s): descent %d-%d between %dcm and %dcm, emergency %d\n",
+		r.LandMinAltVSpeed, r.LandMaxAltVSpeed, r.LandSlowdownMinAlt, r.LandSlowdownMaxAlt, r.EmergencyDescentRate)
+	return nil
+}
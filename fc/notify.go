@@ -0,0 +1,150 @@
+package fc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Notification identifies an event which can ring the terminal bell,
+// see FCOptions.Notifications
+type Notification uint8
+
+const (
+	// NotifyDisconnect is triggered when the board disconnects
+	NotifyDisconnect Notification = 1 << iota
+	// NotifyArm is triggered when the board is armed or disarmed
+	NotifyArm
+	// NotifyBattery is triggered by the battery alerts, see
+	// FCOptions.LowCellVoltage
+	NotifyBattery
+	// NotifyOverrun is triggered when the loop starts overrunning,
+	// see FC.ToggleOverrunMonitor
+	NotifyOverrun
+
+	NotifyAll = NotifyDisconnect | NotifyArm | NotifyBattery | NotifyOverrun
+)
+
+var notificationNames = map[string]Notification{
+	"disconnect": NotifyDisconnect,
+	"arm":        NotifyArm,
+	"battery":    NotifyBattery,
+	"overrun":    NotifyOverrun,
+}
+
+// ParseNotifications parses a comma separated list of notification
+// names (disconnect, arm, battery, overrun). "all" enables all of them.
+func ParseNotifications(s string) (Notification, error) {
+	var n Notification
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			n |= NotifyAll
+			continue
+		}
+		v, ok := notificationNames[name]
+		if !ok {
+			var valid []string
+			for k := range notificationNames {
+				valid = append(valid, k)
+			}
+			sort.Strings(valid)
+			return 0, fmt.Errorf("unknown notification %q, valid ones are %s and all", name, strings.Join(valid, ", "))
+		}
+		n |= v
+	}
+	return n, nil
+}
+
+const armMonitorInterval = 500 * time.Millisecond
+
+// Values for FC.armedState
+const (
+	armedUnknown int32 = iota
+	armedNo
+	armedYes
+)
+
+// notifyf prints the given message, ringing the terminal bell
+// if n is enabled in FCOptions.Notifications.
+func (f *FC) notifyf(n Notification, format string, args ...interface{}) {
+	if f.opts.Notifications&n != 0 {
+		format = "\a" + format
+	}
+	f.printf(format, args...)
+}
+
+// LinkError is passed to FCOptions.ErrorHandler when the board
+// disconnects, with Err set to the reason, and when it reconnects,
+// with Reconnected set. Neither of them stops Run().
+type LinkError struct {
+	Reconnected bool
+	Err         error
+}
+
+func (e *LinkError) Error() string {
+	if e.Reconnected {
+		return "board reconnected"
+	}
+	return fmt.Sprintf("board disconnected (%v)", e.Err)
+}
+
+// reportError passes err to FCOptions.ErrorHandler or, if there's
+// none, prints it.
+func (f *FC) reportError(err error) {
+	if f.opts.ErrorHandler != nil {
+		f.opts.ErrorHandler(err)
+		return
+	}
+	if lerr, ok := err.(*LinkError); ok {
+		if lerr.Reconnected {
+			f.printf("Reconnected...\n")
+		} else {
+			f.notifyf(NotifyDisconnect, "Board disconnected (%v), trying to reconnect...\n", lerr.Err)
+		}
+		return
+	}
+	f.printf("Error: %v\n", err)
+}
+
+// observeArmed records the armed state reported by the board,
+// notifying changes if NotifyArm is enabled.
+func (f *FC) observeArmed(armed bool) {
+	state := armedNo
+	if armed {
+		state = armedYes
+	}
+	prev := atomic.SwapInt32(&f.armedState, state)
+	if prev == armedUnknown || prev == state || f.opts.Notifications&NotifyArm == 0 {
+		return
+	}
+	if armed {
+		f.notifyf(NotifyArm, "Board armed\n")
+	} else {
+		f.notifyf(NotifyArm, "Board disarmed\n")
+	}
+}
+
+// monitorArmed polls the board status, so arming and disarming are
+// notified even if nothing else requests it, until ctx is cancelled.
+func (f *FC) monitorArmed(ctx context.Context) {
+	ticker := time.NewTicker(armMonitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if f.port() == nil || f.isPassthrough() {
+			continue
+		}
+		f.readStatus()
+	}
+}
@@ -0,0 +1,171 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// OSDPositionLayout describes how the position of an OSD element and
+// its visibility are packed into the uint16 used by MSP_OSD_CONFIG.
+type OSDPositionLayout struct {
+	XBits           uint
+	YBits           uint
+	VisibilityShift uint
+	// Betaflight uses a visibility bit per OSD profile, while
+	// INAV has a single one.
+	VisibilityBits uint
+}
+
+var (
+	// BetaflightOSDLayout is the OSD position layout used by Betaflight
+	BetaflightOSDLayout = OSDPositionLayout{XBits: 5, YBits: 5, VisibilityShift: 11, VisibilityBits: 3}
+	// INAVOSDLayout is the OSD position layout used by INAV
+	INAVOSDLayout = OSDPositionLayout{XBits: 6, YBits: 6, VisibilityShift: 13, VisibilityBits: 1}
+)
+
+// Pack returns the position word for an element at the given
+// coordinates. visibility is a bitmask with a bit per OSD profile,
+// starting at bit 0 for the first profile.
+func (l OSDPositionLayout) Pack(x, y uint8, visibility uint8) uint16 {
+	pos := uint16(x) & (1<<l.XBits - 1)
+	pos |= (uint16(y) & (1<<l.YBits - 1)) << l.XBits
+	pos |= (uint16(visibility) & (1<<l.VisibilityBits - 1)) << l.VisibilityShift
+	return pos
+}
+
+// Unpack is the inverse of Pack.
+func (l OSDPositionLayout) Unpack(pos uint16) (x, y uint8, visibility uint8) {
+	x = uint8(pos & (1<<l.XBits - 1))
+	y = uint8((pos >> l.XBits) & (1<<l.YBits - 1))
+	visibility = uint8((pos >> l.VisibilityShift) & (1<<l.VisibilityBits - 1))
+	return x, y, visibility
+}
+
+// OSDConfig contains the general OSD settings and the position word for
+// each element, which can be decoded using Layout.
+type OSDConfig struct {
+	VideoSystem   uint8
+	Units         uint8
+	RSSIAlarm     uint8
+	CapacityAlarm uint16
+	AltitudeAlarm uint16
+	Elements      []uint16
+	Layout        OSDPositionLayout
+}
+
+var errNoOSD = errors.New("board has no OSD")
+
+func (f *FC) osdLayout() (OSDPositionLayout, error) {
+	switch f.Info().Variant {
+	case "BTFL":
+		return BetaflightOSDLayout, nil
+	case "INAV":
+		return INAVOSDLayout, nil
+	}
+	return OSDPositionLayout{}, errors.New("OSD config is only supported by Betaflight and INAV")
+}
+
+// GetOSDConfig retrieves the OSD configuration via MSP_OSD_CONFIG
+func (f *FC) GetOSDConfig() (*OSDConfig, error) {
+	layout, err := f.osdLayout()
+	if err != nil {
+		return nil, err
+	}
+	fr, err := f.request(msp.MspOSDConfig)
+	if err != nil {
+		return nil, err
+	}
+	// Boards without OSD only send the OSD flags (BF) or driver (INAV)
+	if len(fr.Payload) <= 1 {
+		return nil, errNoOSD
+	}
+	cfg := &OSDConfig{Layout: layout}
+	// flags is the OSD driver in INAV
+	var flags uint8
+	var itemCount uint8
+	var headers []interface{}
+	if layout == BetaflightOSDLayout {
+		// In BF, time alarm was replaced by the element count
+		var unused uint8
+		headers = []interface{}{&flags, &cfg.VideoSystem, &cfg.Units, &cfg.RSSIAlarm,
+			&cfg.CapacityAlarm, &unused, &itemCount, &cfg.AltitudeAlarm}
+	} else {
+		var timeAlarm, distanceAlarm, negativeAltitudeAlarm uint16
+		headers = []interface{}{&flags, &cfg.VideoSystem, &cfg.Units, &cfg.RSSIAlarm,
+			&cfg.CapacityAlarm, &timeAlarm, &cfg.AltitudeAlarm, &distanceAlarm, &negativeAltitudeAlarm}
+	}
+	for _, h := range headers {
+		if err := fr.Read(h); err != nil {
+			return nil, err
+		}
+	}
+	if flags == 0 {
+		return nil, errNoOSD
+	}
+	// Element positions follow until the end of the payload in
+	// INAV. BF sends stats, timers and warnings after them, so
+	// limit them to the reported count.
+	count := fr.BytesRemaining() / 2
+	if layout == BetaflightOSDLayout {
+		count = int(itemCount)
+	}
+	cfg.Elements = make([]uint16, count)
+	if err := fr.Read(cfg.Elements); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetOSDElement changes the position word (see OSDPositionLayout) of the
+// OSD element at the given index via MSP_SET_OSD_CONFIG and then writes
+// the configuration to the EEPROM.
+func (f *FC) SetOSDElement(index int, position uint16) error {
+	cfg, err := f.GetOSDConfig()
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(cfg.Elements) {
+		return fmt.Errorf("invalid OSD element %d, board has %d elements", index, len(cfg.Elements))
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	if _, err := m.WriteCmd(msp.MspSetOSDConfig, uint8(index), position); err != nil {
+		return err
+	}
+	_, err = m.WriteCmd(msp.MspEepromWrite)
+	return err
+}
+
+// PrintOSDConfig prints the position and visibility of the configured
+// OSD elements.
+func (f *FC) PrintOSDConfig() error {
+	cfg, err := f.GetOSDConfig()
+	if err != nil {
+		return err
+	}
+	hidden := 0
+	for ii, pos := range cfg.Elements {
+		x, y, visibility := cfg.Layout.Unpack(pos)
+		if visibility == 0 {
+			hidden++
+			continue
+		}
+		var profiles string
+		for p := uint(0); p < cfg.Layout.VisibilityBits; p++ {
+			if visibility&(1<<p) != 0 {
+				profiles += fmt.Sprintf(" %d", p+1)
+			}
+		}
+		if cfg.Layout.VisibilityBits > 1 {
+			f.printf("OSD element %2d at (%2d, %2d), profiles%s\n", ii, x, y, profiles)
+		} else {
+			f.printf("OSD element %2d at (%2d, %2d)\n", ii, x, y)
+		}
+	}
+	f.printf("%d OSD elements, %d hidden\n", len(cfg.Elements), hidden)
+	return nil
+}
@@ -0,0 +1,96 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// OSD flags sent by BF as the first byte of MSP_OSD_CONFIG
+const (
+	bfOSDFlagFeature        = 1 << 0
+	bfOSDFlagFrSkyOSD       = 1 << 3
+	bfOSDFlagMAX7456        = 1 << 4
+	bfOSDFlagDeviceDetected = 1 << 5
+)
+
+// INAV sends its OSD driver as the first byte of MSP_OSD_CONFIG
+const inavOSDDriverMAX7456 = 1
+
+// OSDDevice describes the OSD chip reported by the board
+type OSDDevice struct {
+	// Driver is the OSD chip, e.g. "MAX7456". Empty if there's no OSD.
+	Driver string
+	// Detected is true iff the board found the chip. Only BF reports
+	// it, so it's always true with INAV.
+	Detected bool
+}
+
+// OSDDevice returns the OSD chip used by the board, decoded from the
+// first byte of MSP_OSD_CONFIG.
+func (f *FC) OSDDevice() (*OSDDevice, error) {
+	variant := f.Info().Variant
+	if variant != "BTFL" && variant != "INAV" {
+		return nil, errors.New("OSD device is only reported by Betaflight and INAV")
+	}
+	fr, err := f.request(msp.MspOSDConfig)
+	if err != nil {
+		return nil, err
+	}
+	var flags uint8
+	if err := fr.Read(&flags); err != nil {
+		return nil, err
+	}
+	dev := &OSDDevice{}
+	if variant == "INAV" {
+		if flags == inavOSDDriverMAX7456 {
+			dev.Driver = "MAX7456"
+			dev.Detected = true
+		}
+		return dev, nil
+	}
+	if flags&bfOSDFlagFeature == 0 {
+		return dev, nil
+	}
+	switch {
+	case flags&bfOSDFlagMAX7456 != 0:
+		dev.Driver = "MAX7456"
+	case flags&bfOSDFlagFrSkyOSD != 0:
+		dev.Driver = "FrSkyOSD"
+	default:
+		// e.g. MSP displayport, which uses the font in the goggles
+		dev.Driver = "external"
+	}
+	dev.Detected = flags&bfOSDFlagDeviceDetected != 0
+	return dev, nil
+}
+
+// CheckOSDFont prints the OSD chip used by the board and whether its
+// font is likely to be compatible. Neither BF nor INAV report the
+// installed font version via MSP, so the check is limited to warning
+// about chips which weren't detected and pointing out which font
+// should be installed.
+func (f *FC) CheckOSDFont() error {
+	dev, err := f.OSDDevice()
+	if err != nil {
+		return err
+	}
+	if dev.Driver == "" {
+		f.printf("No OSD\n")
+		return nil
+	}
+	info := f.Info()
+	f.printf("OSD: %s\n", dev.Driver)
+	if !dev.Detected {
+		f.printf("WARNING: the %s OSD chip was not detected by the board, check the wiring and video power\n", dev.Driver)
+		return nil
+	}
+	if dev.Driver != "MAX7456" {
+		return nil
+	}
+	version := fmt.Sprintf("%d.%d", info.VersionMajor, info.VersionMinor)
+	f.printf("The font version is not reported via MSP. If the OSD looks garbled, upload a font from the configurator for %s %s, since fonts from other versions might not match.\n",
+		info.Variant, version)
+	return nil
+}
@@ -0,0 +1,116 @@
+package fc
+
+import "time"
+
+const (
+	overrunPollInterval   = 100 * time.Millisecond
+	overrunReportInterval = 5 * time.Second
+	// defaultOverrunThreshold is the fraction over the expected
+	// cycle time considered an overrun.
+	defaultOverrunThreshold = 0.1
+)
+
+// overrunCounter accumulates the cycle times reported by the board
+// during a report interval.
+type overrunCounter struct {
+	samples  int
+	overruns int
+	max      uint16
+}
+
+func (c *overrunCounter) add(cycleTime uint16, overrun bool) {
+	c.samples++
+	if overrun {
+		c.overruns++
+	}
+	if cycleTime > c.max {
+		c.max = cycleTime
+	}
+}
+
+// rate returns the percentage of the samples which overran
+func (c *overrunCounter) rate() float64 {
+	if c.samples == 0 {
+		return 0
+	}
+	return float64(c.overruns) * 100 / float64(c.samples)
+}
+
+func (f *FC) overrunThreshold() float64 {
+	if f.opts.LoopOverrunThreshold > 0 {
+		return f.opts.LoopOverrunThreshold
+	}
+	return defaultOverrunThreshold
+}
+
+// IsMonitoringOverruns returns true iff the monitor started by
+// ToggleOverrunMonitor is running.
+func (f *FC) IsMonitoringOverruns() bool {
+	return f.overrunMonitorStop != nil
+}
+
+// ToggleOverrunMonitor starts or stops polling the cycle time reported
+// in MSP_STATUS_EX and comparing it with the one expected for the PID
+// loop rate. Overruns (cycle times longer than expected by more than
+// FCOptions.LoopOverrunThreshold) are alerted when they start, and the
+// overrun rate is reported periodically.
+func (f *FC) ToggleOverrunMonitor() (enabled bool, err error) {
+	if f.overrunMonitorStop != nil {
+		close(f.overrunMonitorStop)
+		f.overrunMonitorStop = nil
+		return false, nil
+	}
+	hz, err := f.PIDLoopRate()
+	if err != nil {
+		return false, err
+	}
+	if hz <= 0 {
+		return false, errLoopRateUnsupported
+	}
+	expected := time.Second / time.Duration(hz)
+	f.printf("Expected cycle time %v (%dHz PID loop), overrun threshold %.0f%%\n",
+		expected, hz, f.overrunThreshold()*100)
+	stop := make(chan struct{})
+	f.overrunMonitorStop = stop
+	go f.monitorOverruns(expected, stop)
+	return true, nil
+}
+
+func (f *FC) monitorOverruns(expected time.Duration, stop chan struct{}) {
+	poll := time.NewTicker(overrunPollInterval)
+	defer poll.Stop()
+	report := time.NewTicker(overrunReportInterval)
+	defer report.Stop()
+	limit := float64(expected/time.Microsecond) * (1 + f.overrunThreshold())
+	var total, window overrunCounter
+	var overrunning bool
+	for {
+		select {
+		case <-stop:
+			return
+		case <-poll.C:
+			if f.port() == nil || f.isPassthrough() {
+				continue
+			}
+			st, err := f.readStatus()
+			if err != nil || st.CycleTime == 0 {
+				continue
+			}
+			overrun := float64(st.CycleTime) > limit
+			if overrun && !overrunning {
+				f.notifyf(NotifyOverrun, "Loop overrun: cycle time %dus, expected %dus\n",
+					st.CycleTime, expected/time.Microsecond)
+			}
+			overrunning = overrun
+			total.add(st.CycleTime, overrun)
+			window.add(st.CycleTime, overrun)
+		case <-report.C:
+			if window.samples == 0 {
+				continue
+			}
+			f.printf("Loop overruns: %d/%d samples (%.1f%%), max cycle time %dus, %.1f%% since started\n",
+				window.overruns, window.samples, window.rate(), window.max, total.rate())
+			window = overrunCounter{}
+		}
+	}
+}
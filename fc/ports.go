@@ -0,0 +1,92 @@
+package fc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// unixPortPatterns match the serial ports which might be a board on
+// Linux and macOS
+var unixPortPatterns = []string{
+	"/dev/ttyACM*",
+	"/dev/ttyUSB*",
+	"/dev/tty.*",
+	"/dev/rfcomm*",
+}
+
+// maxWindowsCOMPort is the highest COM port checked on Windows
+const maxWindowsCOMPort = 32
+
+// SerialPort is a serial port which might be connected to a board
+type SerialPort struct {
+	Name string
+	// Likely is true for USB CDC ACM ports, which is what most
+	// boards use for their USB connection.
+	Likely bool
+}
+
+// portLister returns the candidate ports using the given functions,
+// so it can be tested without real ports.
+type portLister struct {
+	glob func(pattern string) ([]string, error)
+	// exists returns true iff the given Windows COM port exists
+	exists func(name string) bool
+}
+
+func (l portLister) list(goos string) ([]SerialPort, error) {
+	var ports []SerialPort
+	if goos == "windows" {
+		for ii := 1; ii <= maxWindowsCOMPort; ii++ {
+			name := fmt.Sprintf("COM%d", ii)
+			if l.exists(name) {
+				ports = append(ports, SerialPort{Name: name})
+			}
+		}
+		return ports, nil
+	}
+	seen := make(map[string]bool)
+	for _, pattern := range unixPortPatterns {
+		matches, err := l.glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			ports = append(ports, SerialPort{Name: name, Likely: isLikelyBoardPort(name)})
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// isLikelyBoardPort returns true iff the port name corresponds to an
+// USB CDC ACM device, which is how boards with a VCP show up.
+func isLikelyBoardPort(name string) bool {
+	return strings.HasPrefix(name, "/dev/ttyACM") || strings.HasPrefix(name, "/dev/tty.usbmodem")
+}
+
+// windowsCOMPortExists returns true iff the COM port can be opened or
+// exists but is busy.
+func windowsCOMPortExists(name string) bool {
+	f, err := os.OpenFile(`\\.\`+name, os.O_RDWR, 0)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	f.Close()
+	return true
+}
+
+// ListSerialPorts returns the serial ports which might be connected to
+// a board, sorted by name. On Windows, there's no way to tell which
+// ports are likely to be a board.
+func ListSerialPorts() ([]SerialPort, error) {
+	l := portLister{glob: filepath.Glob, exists: windowsCOMPortExists}
+	return l.list(runtime.GOOS)
+}
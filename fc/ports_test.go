@@ -0,0 +1,71 @@
+package fc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPortListerList(t *testing.T) {
+	tests := []struct {
+		goos  string
+		globs map[string][]string
+		com   map[string]bool
+		want  []SerialPort
+	}{
+		{
+			goos: "linux",
+			globs: map[string][]string{
+				"/dev/ttyACM*": {"/dev/ttyACM1", "/dev/ttyACM0"},
+				"/dev/ttyUSB*": {"/dev/ttyUSB0"},
+				"/dev/rfcomm*": {"/dev/rfcomm0"},
+				"/dev/tty.*":   nil,
+			},
+			want: []SerialPort{
+				{Name: "/dev/rfcomm0"},
+				{Name: "/dev/ttyACM0", Likely: true},
+				{Name: "/dev/ttyACM1", Likely: true},
+				{Name: "/dev/ttyUSB0"},
+			},
+		},
+		{
+			goos: "darwin",
+			globs: map[string][]string{
+				// /dev/tty.* also matches the ports returned by
+				// other patterns, they must be listed once
+				"/dev/tty.*":   {"/dev/tty.usbserial-A1", "/dev/tty.usbmodem1421", "/dev/tty.Bluetooth-Incoming-Port"},
+				"/dev/ttyACM*": {"/dev/tty.usbmodem1421"},
+			},
+			want: []SerialPort{
+				{Name: "/dev/tty.Bluetooth-Incoming-Port"},
+				{Name: "/dev/tty.usbmodem1421", Likely: true},
+				{Name: "/dev/tty.usbserial-A1"},
+			},
+		},
+		{
+			goos: "windows",
+			com:  map[string]bool{"COM3": true, "COM10": true},
+			want: []SerialPort{
+				{Name: "COM3"},
+				{Name: "COM10"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		l := portLister{
+			glob: func(pattern string) ([]string, error) {
+				return tt.globs[pattern], nil
+			},
+			exists: func(name string) bool {
+				return tt.com[name]
+			},
+		}
+		got, err := l.list(tt.goos)
+		if err != nil {
+			t.Errorf("%s: %v", tt.goos, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: list() = %+v, want %+v", tt.goos, got, tt.want)
+		}
+	}
+}
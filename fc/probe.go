@@ -0,0 +1,64 @@
+package fc
+
+import (
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// ProbeResult is returned by Probe when a valid frame was received
+type ProbeResult struct {
+	// Frame is the first valid frame
+	Frame *msp.MSPFrame
+	// Elapsed is the time from opening the port to receiving Frame
+	Elapsed time.Duration
+	// Invalid is the number of frames with errors (e.g. checksum
+	// mismatches) received before Frame.
+	Invalid int
+}
+
+// Probe opens the port in the options and waits up to timeout for
+// any valid, checksummed frame, without requiring the board identity.
+// MSP_API_VERSION is requested right away, so boards don't need to
+// be sending frames on their own. ErrNoFrames is returned if nothing
+// valid arrives in time.
+func Probe(opts FCOptions, timeout time.Duration) (*ProbeResult, error) {
+	f := &FC{opts: opts}
+	start := time.Now()
+	m, err := f.openPort()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	if _, err := m.WriteCmd(msp.MspAPIVersion); err != nil {
+		return nil, err
+	}
+	// ReadFrame() blocks, closing the port makes it return
+	timer := time.AfterFunc(timeout, func() { m.Close() })
+	defer timer.Stop()
+	res := &ProbeResult{}
+	for {
+		frame, err := m.ReadFrame()
+		if err != nil {
+			if err == msp.ErrReadTimeout {
+				continue
+			}
+			if merr, ok := err.(msp.MSPError); ok && merr.IsMSPError() {
+				res.Invalid++
+				continue
+			}
+			if time.Since(start) >= timeout {
+				return nil, ErrNoFrames
+			}
+			return nil, err
+		}
+		// Our own request echoed back doesn't mean there's
+		// a board on the other side.
+		if m.IsEcho(frame) {
+			continue
+		}
+		res.Frame = frame
+		res.Elapsed = time.Since(start)
+		return res, nil
+	}
+}
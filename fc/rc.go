@@ -0,0 +1,174 @@
+package fc
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+	"github.com/fiam/msp-tool/rx"
+)
+
+const (
+	rcMonitorInterval = 500 * time.Millisecond
+	// Firmwares consider the MSP RX lost after 100ms without frames
+	defaultRCKeepaliveInterval = 50 * time.Millisecond
+	// Maximum difference between the simulated value and the one
+	// reported by the board to consider them equal
+	rcMismatchTolerance = 10
+)
+
+func (f *FC) readRC() ([]uint16, error) {
+	fr, err := f.request(msp.MspRC)
+	if err != nil {
+		return nil, err
+	}
+	return f.updateRC(fr)
+}
+
+// updateRC decodes a MSP_RC frame and stores the channels, so they're
+// returned by RCChannels(). The number of channels depends on the
+// firmware and the receiver, so it's inferred from the payload size.
+func (f *FC) updateRC(fr *msp.MSPFrame) ([]uint16, error) {
+	channels := make([]uint16, len(fr.Payload)/2)
+	if err := fr.Read(channels); err != nil {
+		return nil, err
+	}
+	if count := len(channels); count != f.channelCount() {
+		if err := rx.ValidateChannelOrder(f.opts.ChannelOrder, count); err != nil {
+			f.printf("%v\n", err)
+		}
+		atomic.StoreInt32(&f.rcChannelCount, int32(count))
+	}
+	f.rcMu.Lock()
+	f.rcChannels = channels
+	f.rcMu.Unlock()
+	return channels, nil
+}
+
+// channelCount returns the number of RC channels used by the board,
+// as reported by MSP_RC, or zero if unknown. The channels are updated
+// by the reader goroutine and the RC monitor, while the simulated RX
+// reads the count from its own goroutine.
+func (f *FC) channelCount() int {
+	return int(atomic.LoadInt32(&f.rcChannelCount))
+}
+
+// RCChannels returns the last RC channel values received from the
+// board via MSP_RC, e.g. while the RC monitor is running. The first 4
+// channels are in AETR order. It returns nil if MSP_RC hasn't been
+// received yet.
+func (f *FC) RCChannels() []uint16 {
+	f.rcMu.Lock()
+	defer f.rcMu.Unlock()
+	return append([]uint16(nil), f.rcChannels...)
+}
+
+// PrintRC requests the RC channels from the board and prints them
+// once. While RX simulation is active, the simulated values are
+// printed too, like in the RC monitor.
+func (f *FC) PrintRC() error {
+	board, err := f.readRC()
+	if err != nil {
+		return err
+	}
+	f.printf("%s\n", formatRC(f.simulatedRC(), board, f.sticks.ChannelName))
+	return nil
+}
+
+// simulatedRC returns the channels sent via MSP_SET_RAW_RC, in the
+// order they're reported by MSP_RC (i.e. after applying the RX map).
+func (f *FC) simulatedRC() []uint16 {
+	if !f.IsSimulatingRX() || f.channelMap == nil {
+		return nil
+	}
+	raw := f.sticks.ToMSP(f.channelMap, f.channelCount()).Channels
+	channels := make([]uint16, len(raw))
+	copy(channels, raw)
+	for ii, ch := range f.channelMap {
+		if ii < len(channels) && int(ch) < len(raw) {
+			channels[ii] = raw[ch]
+		}
+	}
+	return channels
+}
+
+// IsMonitoringRC returns true iff the RC monitor started by
+// ToggleRCMonitor is running.
+func (f *FC) IsMonitoringRC() bool {
+	return f.rcMonitorTicker != nil
+}
+
+// ToggleRCMonitor starts or stops periodically printing the RC channels
+// reported by the board via MSP_RC. While RX simulation is active, the
+// simulated value for each channel is printed too and mismatches are
+// marked with a '!'.
+func (f *FC) ToggleRCMonitor() (enabled bool) {
+	if f.rcMonitorTicker != nil {
+		f.rcMonitorTicker.Stop()
+		f.rcMonitorTicker = nil
+		return false
+	}
+	f.rcMonitorTicker = time.NewTicker(rcMonitorInterval)
+	go func(t *time.Ticker) {
+		for range t.C {
+			board, err := f.readRC()
+			if err != nil {
+				f.printf("Error reading RC channels: %v\n", err)
+				continue
+			}
+			f.printf("%s\n", formatRC(f.simulatedRC(), board, f.sticks.ChannelName))
+		}
+	}(f.rcMonitorTicker)
+	return true
+}
+
+// formatRC returns a line with the value of each channel as
+// simulated/board, marking mismatches. If simulated is empty, only
+// the board values are included. AUX channels are labeled using name.
+func formatRC(simulated []uint16, board []uint16, name func(ch int) string) string {
+	var buf bytes.Buffer
+	buf.WriteString("RC")
+	for ii, v := range board {
+		label := strconv.Itoa(ii + 1)
+		if ii >= 4 {
+			label = name(ii + 1)
+		}
+		if len(simulated) == 0 {
+			fmt.Fprintf(&buf, " %s:%d", label, v)
+			continue
+		}
+		if ii >= len(simulated) {
+			fmt.Fprintf(&buf, " %s:-/%d", label, v)
+			continue
+		}
+		s := simulated[ii]
+		mismatch := ""
+		if int(s)-int(v) > rcMismatchTolerance || int(v)-int(s) > rcMismatchTolerance {
+			mismatch = "!"
+		}
+		fmt.Fprintf(&buf, " %s:%d/%d%s", label, s, v, mismatch)
+	}
+	return buf.String()
+}
+
+func (f *FC) rcKeepaliveInterval() time.Duration {
+	if f.opts.RCKeepaliveInterval > 0 {
+		return f.opts.RCKeepaliveInterval
+	}
+	return defaultRCKeepaliveInterval
+}
+
+func channelsEqual(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ii := range a {
+		if a[ii] != b[ii] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package fc
+
+import (
+	"time"
+)
+
+const (
+	// Bounds for the interval between MSP_SET_RAW_RC frames with
+	// FCOptions.AdaptiveRCRate. The maximum must stay below the time
+	// after which firmwares consider the MSP RX lost.
+	minRCInterval = 10 * time.Millisecond
+	maxRCInterval = defaultRCKeepaliveInterval
+	// How often the latency is measured
+	rcLatencyProbeInterval = 250 * time.Millisecond
+	// The rate is lowered when the latency is above rcLatencyHigh and
+	// raised again when it drops below rcLatencyLow.
+	rcLatencyHigh = 60 * time.Millisecond
+	rcLatencyLow  = 25 * time.Millisecond
+)
+
+// rcRateController adjusts the interval between RC frames according
+// to the latency of the status requests, backing off quickly when the
+// board looks saturated and recovering slowly.
+type rcRateController struct {
+	interval time.Duration
+	latency  chan time.Duration
+	lastSent time.Time
+}
+
+func newRCRateController() *rcRateController {
+	return &rcRateController{
+		interval: minRCInterval,
+		latency:  make(chan time.Duration, 1),
+	}
+}
+
+// update adjusts the interval using the given round trip latency,
+// returning true iff it changed.
+func (c *rcRateController) update(latency time.Duration) bool {
+	prev := c.interval
+	switch {
+	case latency > rcLatencyHigh:
+		c.interval *= 2
+		if c.interval > maxRCInterval {
+			c.interval = maxRCInterval
+		}
+	case latency < rcLatencyLow:
+		c.interval -= c.interval / 4
+		if c.interval < minRCInterval {
+			c.interval = minRCInterval
+		}
+	}
+	return c.interval != prev
+}
+
+// probe measures the latency of MSP_STATUS in the background, unless
+// a previous measurement is still running. Timeouts are reported as
+// the request timeout.
+func (c *rcRateController) probe(f *FC) {
+	if time.Since(c.lastSent) < rcLatencyProbeInterval {
+		return
+	}
+	c.lastSent = time.Now()
+	go func() {
+		start := time.Now()
+		f.readStatus()
+		select {
+		case c.latency <- time.Since(start):
+		default:
+		}
+	}()
+}
+
+// adjust applies the latest latency measurement, if any
+func (c *rcRateController) adjust(f *FC) {
+	select {
+	case latency := <-c.latency:
+		if c.update(latency) && f.Verbose() {
+			f.tracef("RC latency %v, sending RC frames every %v\n", latency, c.interval)
+		}
+	default:
+	}
+}
@@ -0,0 +1,68 @@
+package fc
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// RebootMode indicates what the board should boot into after
+// rebooting, see mspRebootType_e in BF.
+type RebootMode uint8
+
+const (
+	RebootFirmware RebootMode = iota
+	RebootBootloader
+	RebootMSC
+	RebootMSCUTC
+)
+
+func (m RebootMode) String() string {
+	switch m {
+	case RebootFirmware:
+		return "firmware"
+	case RebootBootloader:
+		return "bootloader"
+	case RebootMSC:
+		return "mass storage"
+	case RebootMSCUTC:
+		return "mass storage (UTC)"
+	}
+	return fmt.Sprintf("unknown reboot mode %d", uint8(m))
+}
+
+// supportsRebootModes returns true iff MSP_REBOOT accepts the
+// reboot mode as its payload. BF 4.0 added it.
+func (f *FC) supportsRebootModes() bool {
+	return f.Info().Variant == "BTFL" && f.versionGte(4, 0, 0)
+}
+
+// RebootInto reboots the board into the given mode. Firmwares that
+// don't support reboot modes can only reboot into the firmware, via
+// a plain MSP_REBOOT, or into the bootloader, via the reboot character.
+func (f *FC) RebootInto(mode RebootMode) error {
+	if f.supportsRebootModes() {
+		if mode > RebootMSCUTC {
+			return fmt.Errorf("unsupported reboot mode %d", uint8(mode))
+		}
+		err := f.prepareToReboot(func(m *msp.MSP) error {
+			_, err := m.WriteCmd(msp.MspReboot, uint8(mode))
+			return err
+		})
+		if err == nil && (mode == RebootMSC || mode == RebootMSCUTC) {
+			// Report it as soon as the port goes away, the disk
+			// might take a while to show up.
+			atomic.StoreInt32(&f.mscRequested, 1)
+		}
+		return err
+	}
+	switch mode {
+	case RebootFirmware:
+		return f.Reboot()
+	case RebootBootloader:
+		return f.dfuReboot()
+	}
+	info := f.Info()
+	return fmt.Errorf("rebooting into %s is not supported by %s %s", mode, info.Variant, info.Version())
+}
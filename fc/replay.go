@@ -0,0 +1,247 @@
+package fc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// ReplayFilter selects the events printed by Replay
+type ReplayFilter struct {
+	// Codes contains the commands to print. If empty, all are printed.
+	Codes map[uint16]bool
+	// Direction is msp.CaptureSent or msp.CaptureReceived to only
+	// print frames in that direction, or zero for both.
+	Direction byte
+}
+
+// ParseReplayFilter parses a comma separated list of command names
+// (e.g. MSP_ATTITUDE or just attitude) or codes, "debug" for the
+// DEBUG_TRACE messages and "rx" or "tx" for selecting the direction.
+func ParseReplayFilter(s string) (ReplayFilter, error) {
+	var filter ReplayFilter
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		switch strings.ToLower(item) {
+		case "":
+			continue
+		case "rx":
+			filter.Direction = msp.CaptureReceived
+			continue
+		case "tx":
+			filter.Direction = msp.CaptureSent
+			continue
+		case "debug":
+			item = "MSP_DEBUGMSG"
+		}
+		code, ok := msp.CommandCode(item)
+		if !ok {
+			n, err := strconv.ParseUint(item, 0, 16)
+			if err != nil {
+				return ReplayFilter{}, fmt.Errorf("unknown command %q", item)
+			}
+			code = uint16(n)
+		}
+		if filter.Codes == nil {
+			filter.Codes = make(map[uint16]bool)
+		}
+		filter.Codes[code] = true
+	}
+	return filter, nil
+}
+
+func (f ReplayFilter) matches(ev *replayEvent) bool {
+	if f.Direction != 0 && f.Direction != ev.direction {
+		return false
+	}
+	if len(f.Codes) == 0 {
+		return true
+	}
+	// Decoding errors are only printed when not filtering by command
+	return ev.frame != nil && f.Codes[ev.frame.Code]
+}
+
+// replayEvent is a frame or a decoding error in a capture
+type replayEvent struct {
+	time      time.Time
+	direction byte
+	frame     *msp.MSPFrame
+	err       error
+}
+
+// recordFeeder reads the data in a sequence of capture records,
+// remembering the time of the last record read. The frames read
+// with bufio on top of it are completed by the data in that record,
+// since bufio only reads more when its buffer is empty.
+type recordFeeder struct {
+	records []*msp.CaptureRecord
+	data    []byte
+	time    time.Time
+}
+
+func (r *recordFeeder) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if len(r.records) == 0 {
+			return 0, io.EOF
+		}
+		r.data = r.records[0].Data
+		r.time = r.records[0].Time
+		r.records = r.records[1:]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// decodeCaptured decodes the frames in the records, which must all be
+// in the same direction.
+func decodeCaptured(direction byte, records []*msp.CaptureRecord) []*replayEvent {
+	feeder := &recordFeeder{records: records}
+	m := msp.NewFromReader(feeder)
+	var events []*replayEvent
+	for {
+		fr, err := m.ReadFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if _, ok := err.(oobError); ok {
+			// Bytes outside frames, e.g. CLI output
+			continue
+		}
+		events = append(events, &replayEvent{
+			time:      feeder.time,
+			direction: direction,
+			frame:     fr,
+			err:       err,
+		})
+	}
+	return events
+}
+
+// readCapture decodes the frames in a capture, returning them in
+// timestamp order as well as the time of the first record.
+func readCapture(r io.Reader) ([]*replayEvent, time.Time, error) {
+	cr, err := msp.NewCaptureReader(r)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	byDirection := make(map[byte][]*msp.CaptureRecord)
+	var start time.Time
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if start.IsZero() {
+			start = rec.Time
+		}
+		byDirection[rec.Direction] = append(byDirection[rec.Direction], rec)
+	}
+	events := decodeCaptured(msp.CaptureReceived, byDirection[msp.CaptureReceived])
+	events = append(events, decodeCaptured(msp.CaptureSent, byDirection[msp.CaptureSent])...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time.Before(events[j].time)
+	})
+	return events, start, nil
+}
+
+// readFrameRecords reads the frames in a frame record file, see
+// msp.FrameRecordWriter.
+func readFrameRecords(r io.Reader) ([]*replayEvent, time.Time, error) {
+	fr, err := msp.NewFrameRecordReader(r)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var events []*replayEvent
+	var start time.Time
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if start.IsZero() {
+			start = rec.Time
+		}
+		direction := byte(msp.CaptureReceived)
+		if rec.Frame.Direction == '<' {
+			direction = msp.CaptureSent
+		}
+		events = append(events, &replayEvent{time: rec.Time, direction: direction, frame: rec.Frame})
+	}
+	return events, start, nil
+}
+
+// Replay decodes the frames in a capture (see FCOptions.Capture) and
+// prints the ones matching filter in timestamp order, with the sent
+// and received frames interleaved. DEBUG_TRACE messages are printed as
+// text, while other frames are decoded as with FCOptions.DecodeAll.
+// Frame record files (see FCOptions.FrameExport) are accepted too.
+func Replay(w io.Writer, r io.Reader, filter ReplayFilter) error {
+	br := bufio.NewReader(r)
+	read := readCapture
+	if header, _ := br.Peek(msp.FrameRecordMagicSize); msp.IsFrameRecordFile(header) {
+		read = readFrameRecords
+	}
+	events, start, err := read(br)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if !filter.matches(ev) {
+			continue
+		}
+		dir := "RX"
+		if ev.direction == msp.CaptureSent {
+			dir = "TX"
+		}
+		prefix := fmt.Sprintf("%9.3fs %s", ev.time.Sub(start).Seconds(), dir)
+		switch {
+		case ev.err != nil:
+			fmt.Fprintf(w, "%s error: %v\n", prefix, ev.err)
+		case ev.frame.Code == msp.MspDebugMsg:
+			s := strings.Trim(string(ev.frame.Payload), " \r\n\t\x00")
+			fmt.Fprintf(w, "%s [DEBUG] %s\n", prefix, s)
+		default:
+			fmt.Fprintf(w, "%s %s\n", prefix, describeFrame(ev.frame, true))
+		}
+	}
+	return nil
+}
+
+// ExportFrames decodes the frames in a capture (see FCOptions.Capture)
+// and writes the valid ones in timestamp order to w as a frame record
+// file (see msp.FrameRecordWriter). It returns the number of frames
+// written.
+func ExportFrames(w io.Writer, r io.Reader) (int, error) {
+	events, _, err := readCapture(r)
+	if err != nil {
+		return 0, err
+	}
+	fw, err := msp.NewFrameRecordWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, ev := range events {
+		if ev.err != nil {
+			continue
+		}
+		if err := fw.Write(msp.FrameRecord{Time: ev.time, Frame: ev.frame}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
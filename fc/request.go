@@ -0,0 +1,147 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// requestTimeout is the maximum time to wait for the response
+	// to a command sent via FC.request(), unless the command has
+	// its own timeout in requestTimeouts.
+	requestTimeout = time.Second
+)
+
+// requestTimeouts overrides requestTimeout for commands which are
+// answered much faster or slower than most.
+var requestTimeouts = map[uint16]time.Duration{
+	// Answered right away, used for checking the link
+	msp.MspAPIVersion: 500 * time.Millisecond,
+	// Writing the flash blocks the board for a while
+	msp.MspEepromWrite: 3 * time.Second,
+	// The board probes the ESCs before answering
+	msp.MspSet4WayIF: 3 * time.Second,
+}
+
+// timeoutFor returns the maximum time to wait for the response to
+// the given command.
+func timeoutFor(code uint16) time.Duration {
+	if timeout, ok := requestTimeouts[code]; ok {
+		return timeout
+	}
+	return requestTimeout
+}
+
+var errNotConnected = errors.New("board is not connected")
+
+type requestResult struct {
+	fr  *msp.MSPFrame
+	err error
+}
+
+type pendingRequest struct {
+	code uint16
+	ch   chan requestResult
+}
+
+// request sends the given command to the board and waits for its
+// response, which is delivered by the goroutine running StartUpdating.
+// Hence, it must only be called while StartUpdating is running.
+// The timeout depends on the command, see requestTimeouts.
+func (f *FC) request(code uint16, args ...interface{}) (*msp.MSPFrame, error) {
+	return f.requestWithTimeout(code, timeoutFor(code), args...)
+}
+
+// requestWithTimeout is like request(), but waits up to the given
+// timeout for the response.
+func (f *FC) requestWithTimeout(code uint16, timeout time.Duration, args ...interface{}) (*msp.MSPFrame, error) {
+	return f.sendRequest(code, timeout, false, args...)
+}
+
+// requestV2 is like request(), but always uses MSPv2 framing. It's
+// required for the commands only available via MSPv2.
+func (f *FC) requestV2(code uint16, args ...interface{}) (*msp.MSPFrame, error) {
+	return f.sendRequest(code, timeoutFor(code), true, args...)
+}
+
+func (f *FC) sendRequest(code uint16, timeout time.Duration, v2 bool, args ...interface{}) (*msp.MSPFrame, error) {
+	m := f.port()
+	if m == nil {
+		return nil, errNotConnected
+	}
+	req := &pendingRequest{
+		code: code,
+		ch:   make(chan requestResult, 1),
+	}
+	f.requestsMu.Lock()
+	f.requests = append(f.requests, req)
+	f.requestsMu.Unlock()
+	defer f.removeRequest(req)
+
+	write := m.WriteCmd
+	if v2 {
+		write = m.WriteCmdV2
+	}
+	start := time.Now()
+	if _, err := write(code, args...); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-req.ch:
+		if f.Verbose() {
+			f.tracef("%s took %v\n", msp.CommandName(code), time.Since(start))
+		}
+		return res.fr, res.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out waiting for %s response after %v", msp.CommandName(code), timeout)
+	}
+}
+
+func (f *FC) removeRequest(req *pendingRequest) {
+	f.requestsMu.Lock()
+	defer f.requestsMu.Unlock()
+	for ii, r := range f.requests {
+		if r == req {
+			f.requests = append(f.requests[:ii], f.requests[ii+1:]...)
+			break
+		}
+	}
+}
+
+// deliverResponse hands fr to the oldest request waiting for a frame
+// with the same code. It returns true iff fr was delivered.
+func (f *FC) deliverResponse(fr *msp.MSPFrame) bool {
+	f.requestsMu.Lock()
+	defer f.requestsMu.Unlock()
+	for ii, r := range f.requests {
+		if r.code == fr.Code {
+			f.requests = append(f.requests[:ii], f.requests[ii+1:]...)
+			if f.opts.ReuseFrames {
+				// The requester reads it after we've moved
+				// on to the next frame.
+				fr = fr.Clone()
+			}
+			r.ch <- requestResult{fr: fr}
+			return true
+		}
+	}
+	return false
+}
+
+// rejectRequest makes the oldest request waiting for a response to
+// code fail with err. It returns true iff a request was waiting.
+func (f *FC) rejectRequest(code uint16, err error) bool {
+	f.requestsMu.Lock()
+	defer f.requestsMu.Unlock()
+	for ii, r := range f.requests {
+		if r.code == code {
+			f.requests = append(f.requests[:ii], f.requests[ii+1:]...)
+			r.ch <- requestResult{err: err}
+			return true
+		}
+	}
+	return false
+}
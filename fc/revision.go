@@ -0,0 +1,37 @@
+package fc
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// sourceRevision returns the git commit checked out in srcDir
+func sourceRevision(srcDir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = srcDir
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// checkFlashedRevision compares the revision reported by the board in
+// MSP_BUILD_INFO against the one we flashed, if any.
+func (f *FC) checkFlashedRevision(rev string) {
+	f.infoMu.Lock()
+	expected := f.flashedRevision
+	f.flashedRevision = ""
+	f.infoMu.Unlock()
+	if expected == "" {
+		return
+	}
+	// Boards report an abbreviated revision
+	if rev != "" && strings.HasPrefix(expected, rev) {
+		f.printf("Board is running the flashed revision %s\n", rev)
+		return
+	}
+	f.printf("WARNING: board is running revision %q, but the flashed source tree is at %s\n", rev, expected)
+}
@@ -0,0 +1,54 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Minimum size of MSP_RX_CONFIG, up to and including mincheck.
+// Fields after it were added over time by different versions.
+const rxConfigMinSize = 7
+
+// GetRXConfig returns the RX configuration. Fields not sent by the
+// firmware running on the board are left as zero.
+func (f *FC) GetRXConfig() (*msp.MSPRXConfig, error) {
+	fr, err := f.request(msp.MspRXConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(fr.Payload) < rxConfigMinSize {
+		return nil, fmt.Errorf("MSP_RX_CONFIG too short (%d bytes)", len(fr.Payload))
+	}
+	var cfg msp.MSPRXConfig
+	// Fields are decoded in order, so io.EOF means the
+	// payload ended before the fields we know about.
+	if err := fr.Read(&cfg); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SetRXConfig updates the RX configuration and then writes it to the
+// EEPROM. Boards running firmware that doesn't know about some of the
+// fields ignore them, so cfg should be obtained via GetRXConfig() and
+// then modified.
+func (f *FC) SetRXConfig(cfg *msp.MSPRXConfig) error {
+	if cfg.MinCheck >= cfg.MidRC || cfg.MidRC >= cfg.MaxCheck {
+		return fmt.Errorf("invalid RX thresholds: mincheck %d, midrc %d, maxcheck %d", cfg.MinCheck, cfg.MidRC, cfg.MaxCheck)
+	}
+	if cfg.RXMinUsec != 0 && cfg.RXMinUsec >= cfg.RXMaxUsec {
+		return errors.New("RX min usec must be lower than RX max usec")
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	if _, err := m.WriteCmd(msp.MspSetRXConfig, *cfg); err != nil {
+		return err
+	}
+	_, err := m.WriteCmd(msp.MspEepromWrite)
+	return err
+}
@@ -0,0 +1,69 @@
+package fc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fiam/msp-tool/rx"
+)
+
+const defaultRXCrossfade = 500 * time.Millisecond
+
+// rxSourceState is the input source driving the channels during RX
+// simulation on top of the keyboard, see SetRXSource.
+type rxSourceState struct {
+	source  rx.Source
+	started time.Time
+	// Set when the source changes, until the ticker starts the
+	// crossfade.
+	changed bool
+}
+
+func (f *FC) rxCrossfade() time.Duration {
+	if f.opts.RXCrossfade != 0 {
+		return f.opts.RXCrossfade
+	}
+	return defaultRXCrossfade
+}
+
+// currentRXSource returns the active source (nil for just the
+// keyboard), the time since it became active and whether it changed
+// since the last call.
+func (f *FC) currentRXSource() (rx.Source, time.Duration, bool) {
+	f.rxSourceMu.Lock()
+	defer f.rxSourceMu.Unlock()
+	changed := f.rxSource.changed
+	f.rxSource.changed = false
+	return f.rxSource.source, time.Since(f.rxSource.started), changed
+}
+
+// SetRXSource makes src drive the channels during RX simulation on
+// top of the keyboard, or just the keyboard if src is nil. The
+// channels sent to the board ramp from their previous values to the
+// new ones over FCOptions.RXCrossfade.
+func (f *FC) SetRXSource(src rx.Source) {
+	f.rxSourceMu.Lock()
+	defer f.rxSourceMu.Unlock()
+	if prev := f.rxSource.source; prev != nil && prev != src {
+		prev.Release(&f.sticks)
+	}
+	f.rxSource = rxSourceState{source: src, started: time.Now(), changed: true}
+}
+
+// ToggleRXExpressions switches between the keyboard and the
+// expressions in FCOptions.RXExpressions driving the channels.
+func (f *FC) ToggleRXExpressions() (enabled bool, err error) {
+	exprs := f.opts.RXExpressions
+	if exprs == nil {
+		return false, errors.New("no RX expressions were given")
+	}
+	f.rxSourceMu.Lock()
+	active := f.rxSource.source != nil
+	f.rxSourceMu.Unlock()
+	if active {
+		f.SetRXSource(nil)
+		return false, nil
+	}
+	f.SetRXSource(exprs)
+	return true, nil
+}
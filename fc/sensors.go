@@ -0,0 +1,76 @@
+package fc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Rate at which emulated sensors send their readings
+const sensorEmulationInterval = 100 * time.Millisecond
+
+// SendRangefinder sends a reading to the board as an MSP rangefinder
+// would. INAV must be configured with the MSP rangefinder. Use a
+// negative distance to indicate that the target is out of range.
+func (f *FC) SendRangefinder(r msp.MSP2SensorRangefinder) error {
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err := m.WriteCmd(msp.Msp2SensorRangefinder, r)
+	return err
+}
+
+// SendOpticFlow sends a reading to the board as an MSP optical flow
+// sensor would. INAV must be configured with the MSP optical flow.
+func (f *FC) SendOpticFlow(of msp.MSP2SensorOpticFlow) error {
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err := m.WriteCmd(msp.Msp2SensorOpticFlow, of)
+	return err
+}
+
+// emulateRangefinder sends the given distance periodically, until
+// sending fails or ctx is cancelled. Errors are printed only once.
+func (f *FC) emulateRangefinder(ctx context.Context, distanceMM int32) {
+	r := msp.MSP2SensorRangefinder{Quality: 255, DistanceMM: distanceMM}
+	ticker := time.NewTicker(sensorEmulationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if f.port() == nil || f.isPassthrough() {
+			continue
+		}
+		if err := f.SendRangefinder(r); err != nil {
+			f.printf("Stopping rangefinder emulation: %v\n", err)
+			return
+		}
+	}
+}
+
+func decodeRangefinder(fr *msp.MSPFrame) string {
+	var r msp.MSP2SensorRangefinder
+	if fr.Read(&r) != nil {
+		return ""
+	}
+	if r.DistanceMM < 0 {
+		return fmt.Sprintf("out of range, quality=%d", r.Quality)
+	}
+	return fmt.Sprintf("distance=%dmm quality=%d", r.DistanceMM, r.Quality)
+}
+
+func decodeOpticFlow(fr *msp.MSPFrame) string {
+	var of msp.MSP2SensorOpticFlow
+	if fr.Read(&of) != nil {
+		return ""
+	}
+	return fmt.Sprintf("motion=%d,%d quality=%d", of.MotionX, of.MotionY, of.Quality)
+}
@@ -0,0 +1,173 @@
+package fc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// serialFunctionNames contains the names of the serial port functions
+// used by all the variants. Higher bits differ between them, see
+// variantSerialFunctionNames.
+var serialFunctionNames = map[uint16]string{
+	msp.SerialFunctionMSP:                "MSP",
+	msp.SerialFunctionGPS:                "GPS",
+	msp.SerialFunctionTelemetryFrSky:     "TELEMETRY_FRSKY",
+	msp.SerialFunctionTelemetryHoTT:      "TELEMETRY_HOTT",
+	msp.SerialFunctionTelemetryLTM:       "TELEMETRY_LTM",
+	msp.SerialFunctionTelemetrySmartPort: "TELEMETRY_SMARTPORT",
+	msp.SerialFunctionRXSerial:           "RX_SERIAL",
+	msp.SerialFunctionBlackbox:           "BLACKBOX",
+}
+
+// variantSerialFunctionNames contains the names of the serial port
+// functions specific to each variant, by bit.
+var variantSerialFunctionNames = map[string]map[uint]string{
+	"INAV": {
+		8:  "TELEMETRY_MAVLINK",
+		9:  "TELEMETRY_IBUS",
+		10: "RCDEVICE",
+		11: "VTX_SMARTAUDIO",
+		12: "VTX_TRAMP",
+		13: "UAV_INTERCONNECT",
+		14: "OPTICAL_FLOW",
+		15: "DEBUG_TRACE",
+	},
+	"BTFL": {
+		9:  "TELEMETRY_MAVLINK",
+		10: "ESC_SENSOR",
+		11: "VTX_SMARTAUDIO",
+		12: "TELEMETRY_IBUS",
+		13: "VTX_TRAMP",
+		14: "RCDEVICE",
+		15: "LIDAR_TF",
+	},
+}
+
+// serialBaudRates are the baud rates selected by each index in
+// MSP_CF_SERIAL_CONFIG, by variant. Index 0 is always AUTO.
+var serialBaudRates = map[string][]int{
+	"INAV": {0, 1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400, 250000, 460800, 921600},
+	"BTFL": {0, 9600, 19200, 38400, 57600, 115200, 230400, 250000, 400000, 460800, 500000, 921600, 1000000, 1500000, 2000000, 2470000},
+}
+
+// serialFunctions returns the names of the functions enabled in mask.
+// Unknown bits are returned as BIT(n).
+func serialFunctions(variant string, mask uint16) []string {
+	var names []string
+	for bit := uint(0); bit < 16; bit++ {
+		if mask&(1<<bit) == 0 {
+			continue
+		}
+		name, ok := serialFunctionNames[1<<bit]
+		if !ok {
+			name, ok = variantSerialFunctionNames[variant][bit]
+		}
+		if !ok {
+			name = fmt.Sprintf("BIT(%d)", bit)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// serialBaudRate returns the baud rate selected by index as a string,
+// or the index itself if it's unknown.
+func serialBaudRate(variant string, index uint8) string {
+	rates := serialBaudRates[variant]
+	switch {
+	case int(index) >= len(rates):
+		return fmt.Sprintf("#%d", index)
+	case rates[index] == 0:
+		return "AUTO"
+	}
+	return strconv.Itoa(rates[index])
+}
+
+// serialPortName returns the name of the port with the given
+// identifier, as used by serialPortIdentifier_e.
+func serialPortName(id uint8) string {
+	switch {
+	case id < 20:
+		return fmt.Sprintf("UART%d", id+1)
+	case id == 20:
+		return "USB_VCP"
+	case id >= 30 && id < 40:
+		return fmt.Sprintf("SOFTSERIAL%d", id-30+1)
+	}
+	return fmt.Sprintf("PORT%d", id)
+}
+
+// readSerialConfigs decodes all the ports in a MSP_CF_SERIAL_CONFIG
+// frame.
+func readSerialConfigs(fr *msp.MSPFrame) ([]msp.MSPSerialConfig, error) {
+	var configs []msp.MSPSerialConfig
+	for fr.BytesRemaining() > 0 {
+		var cfg msp.MSPSerialConfig
+		if err := fr.Read(&cfg); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// updateSerialConfig decodes a MSP_CF_SERIAL_CONFIG frame and stores
+// the ports, so they're returned by SerialConfig().
+func (f *FC) updateSerialConfig(fr *msp.MSPFrame) ([]msp.MSPSerialConfig, error) {
+	configs, err := readSerialConfigs(fr)
+	if err != nil {
+		return nil, err
+	}
+	f.serialConfigMu.Lock()
+	f.serialConfig = configs
+	f.serialConfigMu.Unlock()
+	return configs, nil
+}
+
+// SerialConfig returns the serial ports configuration from the last
+// MSP_CF_SERIAL_CONFIG received, which is requested when connecting.
+func (f *FC) SerialConfig() []msp.MSPSerialConfig {
+	f.serialConfigMu.Lock()
+	defer f.serialConfigMu.Unlock()
+	return append([]msp.MSPSerialConfig(nil), f.serialConfig...)
+}
+
+// PrintSerialConfig requests the serial ports configuration and
+// prints it as a table, with the functions and baud rates decoded.
+func (f *FC) PrintSerialConfig() error {
+	fr, err := f.request(msp.MspCFSerialConfig)
+	if err != nil {
+		return err
+	}
+	configs, err := f.updateSerialConfig(fr)
+	if err != nil {
+		return err
+	}
+	if len(configs) == 0 {
+		f.printf("No serial ports\n")
+		return nil
+	}
+	variant := f.Info().Variant
+	peripheral := "PERIPHERAL"
+	if variant == "BTFL" {
+		peripheral = "BLACKBOX"
+	}
+	f.printf("%-12s %-8s %-8s %-9s %-10s %s\n", "PORT", "MSP", "GPS", "TELEMETRY", peripheral, "FUNCTIONS")
+	for _, cfg := range configs {
+		functions := strings.Join(serialFunctions(variant, cfg.FunctionMask), ", ")
+		if functions == "" {
+			functions = "-"
+		}
+		f.printf("%-12s %-8s %-8s %-9s %-10s %s\n",
+			serialPortName(cfg.Identifier),
+			serialBaudRate(variant, cfg.MSPBaudRateIndex),
+			serialBaudRate(variant, cfg.GPSBaudRateIndex),
+			serialBaudRate(variant, cfg.TelemetryBaudRateIndex),
+			serialBaudRate(variant, cfg.PeripheralBaudRateIndex),
+			functions)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package fc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// SettingType is the type of a setting value, see setting_type_e
+// in INAV
+type SettingType uint8
+
+const (
+	SettingUint8 SettingType = iota
+	SettingInt8
+	SettingUint16
+	SettingInt16
+	SettingUint32
+	SettingFloat
+	SettingString
+)
+
+var settingTypeNames = []string{
+	"UINT8",
+	"INT8",
+	"UINT16",
+	"INT16",
+	"UINT32",
+	"FLOAT",
+	"STRING",
+}
+
+func (t SettingType) String() string {
+	if int(t) < len(settingTypeNames) {
+		return settingTypeNames[t]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+}
+
+const (
+	settingTypeMask    = 0x07
+	settingSectionMask = 0x30
+	settingModeLookup  = 1 << 6
+)
+
+// Setting is a setting exposed by the board, as returned by
+// MSP2_COMMON_SETTING_INFO
+type Setting struct {
+	Name  string
+	Index uint16
+	// Parameter group the setting is stored in
+	PGN  uint16
+	Type SettingType
+	// Whether the setting is global or per profile, see
+	// setting_section_e in INAV
+	Section uint8
+	Min     int32
+	Max     uint32
+	// For per profile settings, the current profile and
+	// the number of them
+	ProfileIndex uint8
+	ProfileCount uint8
+	// Names of the values, for settings using a lookup table
+	Lookup []string
+	// One of uint8, int8, uint16, int16, uint32, float32 or string
+	Value interface{}
+}
+
+// ValueString returns the value as printed by the CLI
+func (s *Setting) ValueString() string {
+	switch v := s.Value.(type) {
+	case string:
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	if len(s.Lookup) > 0 {
+		n, _ := strconv.Atoi(fmt.Sprint(s.Value))
+		if n >= 0 && n < len(s.Lookup) {
+			return s.Lookup[n]
+		}
+	}
+	return fmt.Sprint(s.Value)
+}
+
+// readCString reads a NUL terminated string from fr
+func readCString(fr *msp.MSPFrame) (string, error) {
+	var buf bytes.Buffer
+	for {
+		var c uint8
+		if err := fr.Read(&c); err != nil {
+			return "", err
+		}
+		if c == 0 {
+			return buf.String(), nil
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func decodeSetting(fr *msp.MSPFrame) (*Setting, error) {
+	name, err := readCString(fr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Setting{Name: name}
+	var info struct {
+		PGN          uint16
+		Type         uint8
+		Section      uint8
+		Mode         uint8
+		Min          int32
+		Max          uint32
+		Index        uint16
+		ProfileIndex uint8
+		ProfileCount uint8
+	}
+	if err := fr.Read(&info); err != nil {
+		return nil, err
+	}
+	s.PGN = info.PGN
+	s.Type = SettingType(info.Type & settingTypeMask)
+	s.Section = info.Section & settingSectionMask
+	s.Min = info.Min
+	s.Max = info.Max
+	s.Index = info.Index
+	s.ProfileIndex = info.ProfileIndex
+	s.ProfileCount = info.ProfileCount
+	if info.Mode&settingModeLookup != 0 {
+		for ii := int64(info.Min); ii <= int64(info.Max); ii++ {
+			name, err := readCString(fr)
+			if err != nil {
+				return nil, err
+			}
+			s.Lookup = append(s.Lookup, name)
+		}
+	}
+	switch s.Type {
+	case SettingUint8:
+		var v uint8
+		err = fr.Read(&v)
+		s.Value = v
+	case SettingInt8:
+		var v int8
+		err = fr.Read(&v)
+		s.Value = v
+	case SettingUint16:
+		var v uint16
+		err = fr.Read(&v)
+		s.Value = v
+	case SettingInt16:
+		var v int16
+		err = fr.Read(&v)
+		s.Value = v
+	case SettingUint32:
+		var v uint32
+		err = fr.Read(&v)
+		s.Value = v
+	case SettingFloat:
+		var v uint32
+		err = fr.Read(&v)
+		s.Value = math.Float32frombits(v)
+	case SettingString:
+		// The rest of the payload, which might include a NUL
+		b := make([]uint8, fr.BytesRemaining())
+		err = fr.Read(b)
+		s.Value = string(bytes.TrimRight(b, "\x00"))
+	default:
+		return nil, fmt.Errorf("setting %s has unknown type %d", s.Name, uint8(s.Type))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DumpAllSettings returns every setting exposed by the board with its
+// current value, in index order, by requesting MSP2_COMMON_SETTING_INFO
+// for each index until the board replies with an error, which
+// indicates the end of the list. Only INAV 2.0+ supports it.
+func (f *FC) DumpAllSettings() ([]Setting, error) {
+	info := f.Info()
+	if info.Variant != "INAV" || !info.atLeast(2, 0) {
+		return nil, errors.New("MSP2_COMMON_SETTING_INFO is only supported by INAV 2.0+")
+	}
+	var settings []Setting
+	for index := 0; index <= math.MaxUint16; index++ {
+		// A zero byte instead of a setting name selects by index
+		fr, err := f.requestV2(msp.Msp2CommonSettingInfo, uint8(0), uint16(index))
+		if _, ok := err.(rejectedError); ok {
+			// Past the last setting
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := decodeSetting(fr)
+		if err != nil {
+			return nil, fmt.Errorf("decoding setting %d: %v", index, err)
+		}
+		settings = append(settings, *s)
+	}
+	return settings, nil
+}
@@ -0,0 +1,73 @@
+package fc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+	"github.com/fiam/msp-tool/rx"
+)
+
+const (
+	// safeStopDuration is how long the safe RC frame is sent for
+	// by StopRXSimulationSafely, so the board sees it even if a
+	// frame is lost.
+	safeStopDuration = 200 * time.Millisecond
+	safeStopInterval = 20 * time.Millisecond
+	// safeStopWriteTimeout is the maximum time to wait for a write
+	// to complete, so a dead link doesn't block quitting.
+	safeStopWriteTimeout = 500 * time.Millisecond
+)
+
+// StopRXSimulationSafely stops RX simulation after sending frames with
+// the throttle and every AUX channel (including the arm channel) low
+// and the sticks centered for a moment. It's best-effort: the
+// simulation is always stopped, and an error is returned if the frames
+// couldn't be sent.
+func (f *FC) StopRXSimulationSafely() error {
+	if f.rxTicker == nil {
+		return nil
+	}
+	// Stop the ticker first, so our frames are the last ones
+	f.rxTicker.Stop()
+	f.rxTicker = nil
+
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	if f.channelMap == nil {
+		return errors.New("channel map not received yet")
+	}
+	var safe rx.RxSticks
+	safe.Reset()
+	safe.ChannelOrder = f.opts.ChannelOrder
+	payload := safe.ToMSP(f.channelMap, f.channelCount())
+	// A frame from the ticker goroutine might still be in flight,
+	// so keep sending ours for a while.
+	deadline := time.Now().Add(safeStopDuration)
+	for {
+		if err := writeWithTimeout(m, safeStopWriteTimeout, msp.MspSetRawRC, payload); err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(safeStopInterval)
+	}
+}
+
+// writeWithTimeout sends a command, giving up after timeout
+func writeWithTimeout(m *msp.MSP, timeout time.Duration, code uint16, args ...interface{}) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.WriteCmd(code, args...)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timed out writing to the board")
+	}
+}
@@ -0,0 +1,110 @@
+package fc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Paths only found in the source tree of each variant
+var sourceTreeMarkers = map[string][]string{
+	"INAV": {"src/main/navigation"},
+	"BTFL": {"src/main/flight/pid.h", "src/main/pg"},
+}
+
+func pathExists(dir string, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+// DetectSourceTree checks that srcDir contains an INAV or BF source
+// tree which can be built with make, returning its variant.
+func DetectSourceTree(srcDir string) (string, error) {
+	st, err := os.Stat(srcDir)
+	if err != nil {
+		return "", &SourceTreeError{Dir: srcDir, Reason: err.Error()}
+	}
+	if !st.IsDir() {
+		return "", &SourceTreeError{Dir: srcDir, Reason: "not a directory"}
+	}
+	for _, name := range []string{"Makefile", "src/main"} {
+		if !pathExists(srcDir, name) {
+			return "", &SourceTreeError{Dir: srcDir, Reason: fmt.Sprintf("%s is missing", name)}
+		}
+	}
+	for variant, markers := range sourceTreeMarkers {
+		found := true
+		for _, m := range markers {
+			if !pathExists(srcDir, m) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return variant, nil
+		}
+	}
+	return "", &SourceTreeError{Dir: srcDir, Reason: "could not determine the firmware variant"}
+}
+
+// ListTargets returns the names of the targets in the source tree at
+// srcDir, sorted alphabetically. Each target has its own directory
+// in src/main/target.
+func ListTargets(srcDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(srcDir, "src", "main", "target"))
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		// Skip directories with shared code, e.g. common
+		dir := "src/main/target/" + e.Name()
+		if pathExists(srcDir, dir+"/target.h") || pathExists(srcDir, dir+"/target.mk") {
+			targets = append(targets, e.Name())
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// checkSourceTree verifies that the source tree at srcDir, containing
+// the given variant, matches the variant running in the board, if known.
+func (f *FC) checkSourceTree(srcDir string, variant string) error {
+	if boardVariant := f.Info().Variant; boardVariant != "" && boardVariant != variant {
+		return &SourceTreeError{
+			Dir:    srcDir,
+			Reason: fmt.Sprintf("it contains %s but the board is running %s", variant, boardVariant),
+		}
+	}
+	return nil
+}
+
+// buildSystem returns the build system in the options or, if none,
+// the one detected from the source tree. When building, the tree
+// must be for the firmware running in the board. Otherwise, binaries
+// are looked up without identifying the tree if detection fails.
+func (f *FC) buildSystem(srcDir string, build bool) (*BuildSystem, error) {
+	if bs := f.opts.BuildSystem; bs != nil {
+		f.printf("Using %s build system in %s\n", bs.Name, srcDir)
+		return bs, nil
+	}
+	bs, err := DetectBuildSystem(srcDir)
+	if err != nil {
+		if !build {
+			return genericBuildSystem, nil
+		}
+		return nil, err
+	}
+	if build {
+		if err := f.checkSourceTree(srcDir, bs.Variant); err != nil {
+			return nil, err
+		}
+	}
+	f.printf("Detected %s build system in %s\n", bs.Name, srcDir)
+	return bs, nil
+}
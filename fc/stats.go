@@ -0,0 +1,128 @@
+package fc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// checksumWindow is the period used for calculating the
+	// checksum error rate.
+	checksumWindow = 5 * time.Second
+	// Minimum number of frames in a window to consider the
+	// checksum error rate meaningful.
+	checksumWindowMinFrames = 20
+)
+
+// Stats contains counters about the data received from the board
+// since the FC was created.
+type Stats struct {
+	Frames         uint64
+	ChecksumErrors uint64
+	OOBBytes       uint64
+	Reconnections  uint64
+	// Unsolicited frames dropped because they arrived faster than
+	// they could be handled.
+	DroppedFrames uint64
+}
+
+type stats struct {
+	mu sync.Mutex
+	Stats
+	lastFrame time.Time
+	// Framing version of the last response by code
+	responseVersions map[uint16]uint8
+	// Current checksum error rate window
+	windowStart    time.Time
+	windowFrames   int
+	windowChecksum int
+}
+
+// Stats returns the counters for the data received from the board
+func (f *FC) Stats() Stats {
+	f.stats.mu.Lock()
+	defer f.stats.mu.Unlock()
+	return f.stats.Stats
+}
+
+func (f *FC) countFrame() {
+	f.stats.mu.Lock()
+	f.stats.Frames++
+	f.stats.lastFrame = time.Now()
+	f.stats.rollChecksumWindow(f.stats.lastFrame)
+	f.stats.windowFrames++
+	f.stats.mu.Unlock()
+}
+
+// rollChecksumWindow starts a new checksum error rate window if the
+// current one is older than checksumWindow. It's called for every
+// frame and checksum error, so only the ones received during the
+// window are counted. s.mu must be held.
+func (s *stats) rollChecksumWindow(now time.Time) {
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= checksumWindow {
+		s.windowStart = now
+		s.windowFrames = 0
+		s.windowChecksum = 0
+	}
+}
+
+func (f *FC) countResponseVersion(code uint16, version uint8) {
+	f.stats.mu.Lock()
+	if f.stats.responseVersions == nil {
+		f.stats.responseVersions = make(map[uint16]uint8)
+	}
+	f.stats.responseVersions[code] = version
+	f.stats.mu.Unlock()
+}
+
+// ResponseVersions returns the framing version (1 or 2) used by the
+// board in its last response to each command, by command code.
+func (f *FC) ResponseVersions() map[uint16]uint8 {
+	f.stats.mu.Lock()
+	defer f.stats.mu.Unlock()
+	versions := make(map[uint16]uint8, len(f.stats.responseVersions))
+	for code, v := range f.stats.responseVersions {
+		versions[code] = v
+	}
+	return versions
+}
+
+func (f *FC) countOOBByte() {
+	f.stats.mu.Lock()
+	f.stats.OOBBytes++
+	f.stats.mu.Unlock()
+}
+
+func (f *FC) countDroppedFrame() {
+	f.stats.mu.Lock()
+	f.stats.DroppedFrames++
+	f.stats.mu.Unlock()
+}
+
+func (f *FC) countReconnection() {
+	f.stats.mu.Lock()
+	f.stats.Reconnections++
+	f.stats.mu.Unlock()
+}
+
+// countChecksumError records a checksum error and returns true iff
+// the checksum error rate over the current window exceeds the threshold
+// in FCOptions.ChecksumErrorThreshold. When that happens, a new window
+// is started.
+func (f *FC) countChecksumError() bool {
+	f.stats.mu.Lock()
+	defer f.stats.mu.Unlock()
+	f.stats.ChecksumErrors++
+	f.stats.rollChecksumWindow(time.Now())
+	f.stats.windowChecksum++
+	threshold := f.opts.ChecksumErrorThreshold
+	total := f.stats.windowFrames + f.stats.windowChecksum
+	if threshold <= 0 || total < checksumWindowMinFrames {
+		return false
+	}
+	if float64(f.stats.windowChecksum)/float64(total) <= threshold {
+		return false
+	}
+	f.stats.windowStart = time.Time{}
+	return true
+}
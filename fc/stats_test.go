@@ -0,0 +1,38 @@
+package fc
+
+import "testing"
+
+func TestChecksumErrorRate(t *testing.T) {
+	f := &FC{opts: FCOptions{ChecksumErrorThreshold: 0.1}}
+	for ii := 0; ii < 200; ii++ {
+		f.countFrame()
+	}
+	for ii := 0; ii < 10; ii++ {
+		if f.countChecksumError() {
+			t.Fatalf("error %d exceeded the threshold with 200 frames", ii)
+		}
+	}
+
+	// Frames received before the window must not dilute the rate
+	f.stats.mu.Lock()
+	f.stats.windowStart = f.stats.windowStart.Add(-checksumWindow)
+	f.stats.mu.Unlock()
+	exceeded := -1
+	for ii := 0; ii < checksumWindowMinFrames; ii++ {
+		if f.countChecksumError() {
+			exceeded = ii
+			break
+		}
+	}
+	if exceeded != checksumWindowMinFrames-1 {
+		t.Errorf("threshold exceeded at error %d, want %d", exceeded, checksumWindowMinFrames-1)
+	}
+
+	// Exceeding the threshold starts a new window
+	if f.countChecksumError() {
+		t.Error("threshold exceeded right after starting a new window")
+	}
+	if got := f.Stats().ChecksumErrors; got != 10+checksumWindowMinFrames+1 {
+		t.Errorf("ChecksumErrors = %d, want %d", got, 10+checksumWindowMinFrames+1)
+	}
+}
@@ -0,0 +1,98 @@
+package fc
+
+import (
+	"github.com/fiam/msp-tool/msp"
+)
+
+// StatusInfo contains the board status, as reported by MSP_STATUS or
+// MSP_STATUS_EX. CPULoad and ArmingDisableFlags are only sent in the
+// latter.
+type StatusInfo struct {
+	CycleTime uint16
+	I2CErrors uint16
+	Sensors   uint16
+	// Flight mode flags, with a bit per active box. Since ARM is
+	// always the first box, bit 0 indicates if the board is armed.
+	ModeFlags uint32
+	Profile   uint8
+	CPULoad   uint16
+	// ArmingDisableFlags contains the reasons preventing the board
+	// from arming. Use ArmingDisableReasons() to decode them.
+	ArmingDisableFlags uint32
+}
+
+// Armed returns true iff the board is armed
+func (s *StatusInfo) Armed() bool {
+	return s.ModeFlags&1 != 0
+}
+
+// Status returns the last status received from the board. It's
+// updated every time MSP_STATUS or MSP_STATUS_EX are received, e.g.
+// while polling for arming. If no status has been received yet, it
+// returns the zero StatusInfo.
+func (f *FC) Status() StatusInfo {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	return f.status
+}
+
+func (f *FC) readStatus() (*StatusInfo, error) {
+	fr, err := f.request(msp.MspStatusEx)
+	if err != nil {
+		return nil, err
+	}
+	return f.decodeStatus(fr)
+}
+
+// decodeStatus decodes a MSP_STATUS or MSP_STATUS_EX frame, storing
+// it as the last known status.
+func (f *FC) decodeStatus(fr *msp.MSPFrame) (*StatusInfo, error) {
+	st := &StatusInfo{}
+	fields := []interface{}{&st.CycleTime, &st.I2CErrors, &st.Sensors, &st.ModeFlags, &st.Profile}
+	for _, field := range fields {
+		if err := fr.Read(field); err != nil {
+			return nil, err
+		}
+	}
+	f.observeArmed(st.Armed())
+	if fr.Code == msp.MspStatusEx {
+		f.decodeStatusEx(fr, st)
+	}
+	f.statusMu.Lock()
+	f.status = *st
+	f.statusMu.Unlock()
+	return st, nil
+}
+
+// decodeStatusEx decodes the fields only sent in MSP_STATUS_EX, which
+// are optional and vary by variant.
+func (f *FC) decodeStatusEx(fr *msp.MSPFrame, st *StatusInfo) {
+	if err := fr.Read(&st.CPULoad); err != nil {
+		return
+	}
+	switch f.Info().Variant {
+	case "INAV":
+		// INAV sends its 16 bits arming flags. Since they also
+		// include the armed state, only keep the reasons.
+		var armingFlags uint16
+		if err := fr.Read(&armingFlags); err == nil {
+			st.ArmingDisableFlags = uint32(armingFlags) &^ inavArmingStateMask
+		}
+	case "BTFL":
+		// Profile count, rate profile, then additional
+		// flight mode flags prefixed by their count.
+		var profileCount, rateProfile, extraModeFlagsCount uint8
+		for _, v := range []*uint8{&profileCount, &rateProfile, &extraModeFlagsCount} {
+			if err := fr.Read(v); err != nil {
+				return
+			}
+		}
+		if err := fr.Read(make([]uint8, extraModeFlagsCount)); err != nil {
+			return
+		}
+		var flagsCount uint8
+		if err := fr.Read(&flagsCount); err == nil {
+			fr.Read(&st.ArmingDisableFlags)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package fc
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Config storage usage above which ConfigStorage.NearlyFull returns true
+const configStorageWarnRatio = 0.9
+
+var (
+	configSizeRe      = regexp.MustCompile(`(?i)config size:\s*(\d+)`)
+	configAvailableRe = regexp.MustCompile(`(?i)max available config:\s*(\d+)`)
+)
+
+// ConfigStorage contains the size of the stored configuration and the
+// space available for it, in bytes.
+type ConfigStorage struct {
+	Used  int
+	Total int
+}
+
+// Free returns the number of bytes available for the configuration
+func (s *ConfigStorage) Free() int {
+	return s.Total - s.Used
+}
+
+// NearlyFull returns true iff the storage is close enough to full that
+// writing the EEPROM might fail after adding settings.
+func (s *ConfigStorage) NearlyFull() bool {
+	return s.Total > 0 && float64(s.Used) >= float64(s.Total)*configStorageWarnRatio
+}
+
+// parseConfigStorage parses the output of the CLI status command
+func parseConfigStorage(status string) (*ConfigStorage, error) {
+	used := configSizeRe.FindStringSubmatch(status)
+	total := configAvailableRe.FindStringSubmatch(status)
+	if used == nil || total == nil {
+		return nil, errors.New("the firmware doesn't report the config size")
+	}
+	s := &ConfigStorage{}
+	s.Used, _ = strconv.Atoi(used[1])
+	s.Total, _ = strconv.Atoi(total[1])
+	return s, nil
+}
+
+// GetConfigStorage returns the config storage usage. No MSP message
+// exposes it, so it's parsed from the CLI status command. Leaving the
+// CLI reboots the board.
+func (f *FC) GetConfigStorage() (*ConfigStorage, error) {
+	m, err := f.enterCLI()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.Write([]byte("status\n")); err != nil {
+		f.exitCLI(m)
+		return nil, err
+	}
+	status, err := f.readCLIResponse(m)
+	if err != nil {
+		return nil, err
+	}
+	// Leave the CLI without saving, which reboots the board
+	m.Write([]byte("exit\n"))
+	time.Sleep(100 * time.Millisecond)
+	f.exitCLI(m)
+	return parseConfigStorage(status)
+}
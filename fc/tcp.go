@@ -0,0 +1,35 @@
+package fc
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	// tcpPortPrefix selects a TCP connection instead of a serial
+	// port in FCOptions.PortName, e.g. tcp://localhost:5760 for SITL
+	tcpPortPrefix = "tcp://"
+	// tcpDialTimeout is the maximum time to wait for connecting
+	tcpDialTimeout = 5 * time.Second
+	// tcpReconnectDelay is the delay between reconnection attempts
+	// for TCP ports, so we don't hammer a host that's down.
+	tcpReconnectDelay = time.Second
+)
+
+// isTCPPort returns true iff the port in the options is a TCP address
+func (f *FC) isTCPPort() bool {
+	return strings.HasPrefix(f.opts.PortName, tcpPortPrefix)
+}
+
+// dialTCP connects to the TCP address in the port name
+func (f *FC) dialTCP() (*msp.MSP, error) {
+	addr := strings.TrimPrefix(f.opts.PortName, tcpPortPrefix)
+	conn, err := net.DialTimeout("tcp", addr, tcpDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return msp.NewWithConn(conn), nil
+}
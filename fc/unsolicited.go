@@ -0,0 +1,49 @@
+package fc
+
+import "github.com/fiam/msp-tool/msp"
+
+// unsolicitedQueueSize is the number of unsolicited frames buffered
+// while their handler catches up, e.g. while the terminal is slow to
+// print DEBUG_TRACE messages.
+const unsolicitedQueueSize = 256
+
+// unsolicitedCodes are the frames the board (or an MSP sensor) sends
+// on its own, possibly continuously. Their handlers only print them,
+// so they run in their own goroutine to keep the reader free for
+// responses, see queueUnsolicited.
+var unsolicitedCodes = map[uint16]bool{
+	msp.MspDebugMsg:           true,
+	msp.Msp2SensorRangefinder: true,
+	msp.Msp2SensorOpticFlow:   true,
+}
+
+func isUnsolicited(fr *msp.MSPFrame) bool {
+	return unsolicitedCodes[fr.Code]
+}
+
+// queueUnsolicited hands fr to handleUnsolicited without blocking the
+// reader. If the queue is full, the frame is dropped and counted, so
+// a fast stream can't delay the responses behind it.
+func (f *FC) queueUnsolicited(fr *msp.MSPFrame) {
+	if f.opts.ReuseFrames {
+		fr = fr.Clone()
+	}
+	select {
+	case f.unsolicited <- fr:
+	default:
+		f.countDroppedFrame()
+	}
+}
+
+// handleUnsolicited handles the frames sent by queueUnsolicited, in
+// the order they were received.
+func (f *FC) handleUnsolicited(w interface{}) {
+	var reported uint64
+	for fr := range f.unsolicited {
+		if dropped := f.Stats().DroppedFrames; dropped != reported {
+			f.printf("%d unsolicited frames dropped, output can't keep up with the board\n", dropped-reported)
+			reported = dropped
+		}
+		f.handleFrame(fr, w)
+	}
+}
@@ -0,0 +1,168 @@
+package fc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// gatedWriter blocks all writes until open() is called, simulating a
+// terminal that can't keep up with the output.
+type gatedWriter struct {
+	gate chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) open() { close(w.gate) }
+
+func (w *gatedWriter) lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(w.buf.String(), "\n"), "\n")
+}
+
+// debugMessages returns the DEBUG_MSG lines written to w
+func (w *gatedWriter) debugMessages() []string {
+	var messages []string
+	for _, line := range w.lines() {
+		if strings.HasPrefix(line, "[DEBUG] ") {
+			messages = append(messages, line)
+		}
+	}
+	return messages
+}
+
+// checkDebugMessages checks that the messages printed from the
+// unsolicited queue are in order. Queued frames must have been cloned,
+// otherwise their payloads would be overwritten by the following
+// frames.
+func checkDebugMessages(t *testing.T, messages []string, want int) {
+	if len(messages) != want {
+		t.Fatalf("printed %d debug messages, want %d", len(messages), want)
+	}
+	last := -1
+	for _, line := range messages {
+		var n int
+		if _, err := fmt.Sscanf(line, "[DEBUG] msg %04d", &n); err != nil {
+			t.Fatalf("invalid debug message %q: %v", line, err)
+		}
+		if n <= last {
+			t.Fatalf("debug message %d printed after %d", n, last)
+		}
+		last = n
+	}
+}
+
+func TestUnsolicitedQueue(t *testing.T) {
+	const streamed = 2000
+	out := &gatedWriter{gate: make(chan struct{})}
+	f := &FC{opts: FCOptions{Stdout: out, ReuseFrames: true}}
+	f.reset()
+	f.unsolicited = make(chan *msp.MSPFrame, unsolicitedQueueSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.handleUnsolicited(nil)
+	}()
+
+	// Reuse the frame and its payload, like the reader does with
+	// FCOptions.ReuseFrames
+	fr := &msp.MSPFrame{Code: msp.MspDebugMsg}
+	for ii := 0; ii < streamed; ii++ {
+		fr.Payload = append(fr.Payload[:0], fmt.Sprintf("msg %04d", ii)...)
+		if !isUnsolicited(fr) {
+			t.Fatalf("MSP_DEBUGMSG is not unsolicited")
+		}
+		f.queueUnsolicited(fr)
+	}
+	dropped := f.Stats().DroppedFrames
+	if dropped == 0 {
+		t.Fatalf("no frames were dropped while the output was blocked")
+	}
+
+	out.open()
+	close(f.unsolicited)
+	<-done
+	checkDebugMessages(t, out.debugMessages(), streamed-int(dropped))
+}
+
+func TestUnsolicitedStreamWithRequests(t *testing.T) {
+	const streamed = 2000
+	out := &gatedWriter{gate: make(chan struct{})}
+	f := newPipeFC(t, FCOptions{Stdout: out, ReuseFrames: true})
+	defer f.close(t)
+
+	// The board answers MSP_ATTITUDE requests while it streams
+	// debug messages
+	var writeMu sync.Mutex
+	go func() {
+		for {
+			fr, err := f.board.ReadFrame()
+			if err != nil {
+				return
+			}
+			if fr.Code == msp.MspAttitude {
+				writeMu.Lock()
+				f.board.WriteResponse(msp.MspAttitude, int16(10), int16(20), int16(30))
+				writeMu.Unlock()
+			}
+		}
+	}()
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		for ii := 0; ii < streamed; ii++ {
+			writeMu.Lock()
+			f.board.WriteResponse(msp.MspDebugMsg, []byte(fmt.Sprintf("msg %04d", ii)))
+			writeMu.Unlock()
+		}
+	}()
+
+	request := func() {
+		fr, err := f.request(msp.MspAttitude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var att msp.MSPAttitude
+		if err := fr.Read(&att); err != nil {
+			t.Fatal(err)
+		}
+		if att.Roll != 10 || att.Pitch != 20 || att.Yaw != 30 {
+			t.Fatalf("attitude = %+v, want {10 20 30}", att)
+		}
+	}
+	for ii := 0; ii < 20; ii++ {
+		request()
+	}
+	<-streamDone
+	// The response to this request arrives after all the streamed
+	// frames, so they've all been read when it returns.
+	request()
+
+	dropped := f.Stats().DroppedFrames
+	if dropped == 0 {
+		t.Fatalf("no frames were dropped while the output was blocked")
+	}
+
+	out.open()
+	// The handler might still be printing the queued frames
+	want := streamed - int(dropped)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(out.debugMessages()) < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkDebugMessages(t, out.debugMessages(), want)
+}
@@ -0,0 +1,51 @@
+package fc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// SetVerbose enables or disables printing every frame sent to and
+// received from the board, with timestamps, as well as the time taken
+// by each request. It can be called at any time, from any goroutine.
+func (f *FC) SetVerbose(verbose bool) {
+	var v int32
+	if verbose {
+		v = 1
+	}
+	atomic.StoreInt32(&f.verbose, v)
+}
+
+// Verbose returns true iff verbose output is enabled
+func (f *FC) Verbose() bool {
+	return atomic.LoadInt32(&f.verbose) != 0
+}
+
+// ToggleVerbose toggles verbose output, returning whether
+// it's now enabled.
+func (f *FC) ToggleVerbose() bool {
+	verbose := !f.Verbose()
+	f.SetVerbose(verbose)
+	return verbose
+}
+
+func (f *FC) tracef(format string, a ...interface{}) {
+	f.printf(time.Now().Format("15:04:05.000")+" "+format, a...)
+}
+
+// traceTX is called by the MSP for every frame written to the port
+func (f *FC) traceTX(fr *msp.MSPFrame) {
+	if f.Verbose() {
+		f.tracef("TX %s\n", describeFrame(fr, true))
+	}
+}
+
+// traceRX prints a received frame if verbose output is enabled and
+// the frame hasn't already been printed because of FCOptions.DecodeAll
+func (f *FC) traceRX(fr *msp.MSPFrame) {
+	if f.Verbose() && !f.opts.DecodeAll {
+		f.tracef("RX %s\n", describeFrame(fr, true))
+	}
+}
@@ -0,0 +1,94 @@
+package fc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+// Size of each MSPVoltageMeterConfig entry in MSP_VOLTAGE_METER_CONFIG
+const voltageMeterConfigSize = 5
+
+var errVoltageMeterUnsupported = errors.New("per meter voltage config is only supported by Betaflight")
+
+// GetVoltageMeterConfig returns the configuration for each of the
+// voltage meters in the board.
+func (f *FC) GetVoltageMeterConfig() ([]msp.MSPVoltageMeterConfig, error) {
+	if f.Info().Variant != "BTFL" {
+		return nil, errVoltageMeterUnsupported
+	}
+	fr, err := f.request(msp.MspVoltageMeterConfig)
+	if err != nil {
+		return nil, err
+	}
+	var count uint8
+	if err := fr.Read(&count); err != nil {
+		return nil, err
+	}
+	meters := make([]msp.MSPVoltageMeterConfig, 0, int(count))
+	for ii := 0; ii < int(count); ii++ {
+		// Each meter is prefixed by its size
+		var size uint8
+		if err := fr.Read(&size); err != nil {
+			return nil, err
+		}
+		if size < voltageMeterConfigSize {
+			return nil, fmt.Errorf("invalid voltage meter config size %d", size)
+		}
+		var cfg msp.MSPVoltageMeterConfig
+		if err := fr.Read(&cfg); err != nil {
+			return nil, err
+		}
+		// Skip any fields added by newer firmware versions
+		if extra := int(size) - voltageMeterConfigSize; extra > 0 {
+			if err := fr.Read(make([]uint8, extra)); err != nil {
+				return nil, err
+			}
+		}
+		meters = append(meters, cfg)
+	}
+	return meters, nil
+}
+
+// SetVoltageMeterConfig updates the scale and resistor divider of the
+// voltage meter identified by cfg.ID, and then writes the configuration
+// to the EEPROM. cfg.SensorType is ignored.
+func (f *FC) SetVoltageMeterConfig(cfg msp.MSPVoltageMeterConfig) error {
+	if f.Info().Variant != "BTFL" {
+		return errVoltageMeterUnsupported
+	}
+	if cfg.Scale == 0 {
+		return errors.New("voltage meter scale can't be zero")
+	}
+	if cfg.ResDivVal == 0 || cfg.ResDivMultiplier == 0 {
+		return errors.New("voltage meter divider and multiplier can't be zero")
+	}
+	m := f.port()
+	if m == nil {
+		return errNotConnected
+	}
+	_, err := m.WriteCmd(msp.MspSetVoltageMeterConfig, cfg.ID, cfg.Scale, cfg.ResDivVal, cfg.ResDivMultiplier)
+	if err != nil {
+		return err
+	}
+	_, err = m.WriteCmd(msp.MspEepromWrite)
+	return err
+}
+
+// PrintVoltageMeterConfig prints the configuration of each voltage meter
+func (f *FC) PrintVoltageMeterConfig() error {
+	meters, err := f.GetVoltageMeterConfig()
+	if err != nil {
+		return err
+	}
+	if len(meters) == 0 {
+		f.printf("No voltage meters\n")
+		return nil
+	}
+	for _, m := range meters {
+		f.printf("Voltage meter %d: scale %d, divider %d, multiplier %d\n",
+			m.ID, m.Scale, m.ResDivVal, m.ResDivMultiplier)
+	}
+	return nil
+}
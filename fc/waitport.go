@@ -0,0 +1,53 @@
+package fc
+
+import (
+	"os"
+	"time"
+
+	"github.com/fiam/msp-tool/msp"
+)
+
+const (
+	waitPortPollInterval     = 100 * time.Millisecond
+	waitPortProgressInterval = 5 * time.Second
+)
+
+// waitForPort waits up to timeout for the port in the options to
+// appear and opens it. Opening is also retried, since the port might
+// not be usable right after its device file is created.
+func (f *FC) waitForPort(timeout time.Duration) (*msp.MSP, error) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	lastProgress := start
+	waiting := false
+	delay := f.reconnectDelay()
+	if delay < waitPortPollInterval {
+		delay = waitPortPollInterval
+	}
+	for {
+		var err error
+		if f.portIsPresent() {
+			var m *msp.MSP
+			if m, err = f.openPort(); err == nil {
+				if waiting {
+					f.printf("Found %s after %v\n", f.opts.PortName, time.Since(start).Round(time.Second))
+				}
+				return m, nil
+			}
+		} else {
+			err = &os.PathError{Op: "open", Path: f.opts.PortName, Err: os.ErrNotExist}
+		}
+		now := time.Now()
+		if now.After(deadline) {
+			return nil, err
+		}
+		if !waiting {
+			f.printf("Waiting up to %v for %s...\n", timeout, f.opts.PortName)
+			waiting = true
+		} else if now.Sub(lastProgress) >= waitPortProgressInterval {
+			f.printf("Still waiting for %s (%v)...\n", f.opts.PortName, now.Sub(start).Round(time.Second))
+			lastProgress = now
+		}
+		time.Sleep(delay)
+	}
+}
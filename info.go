@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fiam/msp-tool/fc"
+	"github.com/fiam/msp-tool/msp"
+)
+
+// MyPIDReceiver prints the PIDs requested with the 'p' command
+type MyPIDReceiver struct {
+	w io.Writer
+}
+
+// ReceivedPID prints the PIDs received from the board
+func (r MyPIDReceiver) ReceivedPID(pids map[string]*fc.Pid) error {
+	names := make([]string, 0, len(pids))
+	for name := range pids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(r.w, "%s: %v\n", name, pids[name].Value)
+	}
+	return nil
+}
+
+// printInfo prints the board identity, for the 'i' command
+func printInfo(w io.Writer, info fc.Info) {
+	if info.Variant == "" {
+		fmt.Fprintf(w, "No board information received\n")
+		return
+	}
+	fmt.Fprintf(w, "%s %s, board %s", info.Variant, info.Version(), info.BoardID)
+	if info.TargetName != "" {
+		fmt.Fprintf(w, ", target %s", info.TargetName)
+	}
+	if info.CraftName != "" {
+		fmt.Fprintf(w, ", craft %q", info.CraftName)
+	}
+	if info.PIDLoopRate != 0 {
+		fmt.Fprintf(w, ", PID loop %dHz", info.PIDLoopRate)
+	}
+	if info.UID != "" {
+		fmt.Fprintf(w, ", UID %s", info.UID)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// printStats prints the link statistics, for the 's' command
+func printStats(w io.Writer, c *fc.FC) {
+	st := c.Stats()
+	fmt.Fprintf(w, "%d frames, %d checksum errors, %d out of band bytes, %d reconnections, %d dropped\n",
+		st.Frames, st.ChecksumErrors, st.OOBBytes, st.Reconnections, st.DroppedFrames)
+	printResponseVersions(w, c.ResponseVersions())
+}
+
+// printResponseVersions prints the commands answered by the board
+// grouped by the framing version of the responses
+func printResponseVersions(w io.Writer, versions map[uint16]uint8) {
+	byVersion := make(map[uint8][]string)
+	for code, v := range versions {
+		byVersion[v] = append(byVersion[v], msp.CommandName(code))
+	}
+	for _, v := range []uint8{1, 2} {
+		if names := byVersion[v]; len(names) > 0 {
+			sort.Strings(names)
+			fmt.Fprintf(w, "MSPv%d responses: %s\n", v, strings.Join(names, ", "))
+		}
+	}
+}
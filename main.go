@@ -1,25 +1,80 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fiam/msp-tool/fc"
+	"github.com/fiam/msp-tool/msp"
 	"github.com/fiam/msp-tool/rx"
 	"github.com/pkg/term"
 )
 
 var (
-	portName              = flag.String("p", "", "Serial port")
-	baudRate              = flag.Int("b", 115200, "Baud rate")
+	portName              = flag.String("p", "", "Serial port, or tcp://host:port for connecting over TCP (e.g. to SITL)")
+	baudRate              = flag.Int("b", 115200, "Baud rate, 0 to detect it")
+	serialFraming         = flag.String("serial-framing", "8N1", "Data bits, parity (N, E or O) and stop bits used by the port")
 	sourceDir             = flag.String("s", ".", "Path to the directory with the firmware source code")
+	buildSystemName       = flag.String("build-system", "auto", "Build system used for flashing: auto (detect from the source tree), inav or betaflight")
 	targetName            = flag.String("t", "", "Target name. Optional if the firmware reports it via MSP")
 	doNotEnableDebugTrace = flag.Bool("no-debug-trace", false, "Do not enable DEBUG_TRACE automatically")
+	decodeAll             = flag.Bool("decode-all", false, "Print every received MSP frame, decoded when possible")
+	readBufferSize        = flag.Int("read-buffer-size", 0, "Size of the buffer for reading from the serial port (default 4096)")
+	reuseFrames           = flag.Bool("reuse-frames", false, "Reuse the memory of received frames instead of allocating it for each one")
+	checksumThreshold     = flag.Float64("checksum-reconnect", 0, "Reopen the port when the ratio (0-1) of frames with checksum errors exceeds this value (0 = never)")
+	bluetooth             = flag.Bool("bluetooth", false, "The port is a Bluetooth serial port. Automatically enabled for /dev/rfcomm*")
+	keepalive             = flag.Duration("keepalive", 0, "Send a request when the link is idle for this long (default 0, 2s for Bluetooth)")
+	readTimeout           = flag.Duration("read-timeout", 0, "Check whether the board is still connected after receiving nothing for this long (default 1s, negative to disable)")
+	imuInterval           = flag.Duration("imu-interval", 20*time.Millisecond, "Interval between MSP_RAW_IMU requests while graphing the IMU")
+	rcKeepalive           = flag.Duration("rc-keepalive", 50*time.Millisecond, "Maximum interval between RC frames during RX simulation when the sticks don't move")
+	rangefinderMM         = flag.Int("emulate-rangefinder", 0, "Send this distance in mm to the board as an MSP rangefinder (INAV only, negative for out of range)")
+	waitForBoard          = flag.Duration("wait-for-board", 0, "Wait up to this long for the port to appear before connecting (e.g. 30s)")
+	resumeRX              = flag.Bool("resume-rx", false, "Resume RX simulation after reconnecting to the board if it was active")
+	adaptiveRCRate        = flag.Bool("adaptive-rc-rate", false, "Lower the RC frame rate during RX simulation when the board responds slowly")
+	armChannel            = flag.Int("arm-channel", 5, "RC channel used for arming during RX simulation")
+	verifyRevision        = flag.Bool("verify-revision", true, "After flashing, check that the board runs the source tree git revision")
+	trimRoll              = flag.Int("trim-roll", 0, "Trim offset for roll during RX simulation")
+	trimPitch             = flag.Int("trim-pitch", 0, "Trim offset for pitch during RX simulation")
+	trimYaw               = flag.Int("trim-yaw", 0, "Trim offset for yaw during RX simulation")
+	trimThrottle          = flag.Int("trim-throttle", 0, "Trim offset for throttle during RX simulation")
+	rxDeadband            = flag.Uint("deadband", 0, "Values within this many µs of the center are sent as the center for roll, pitch and yaw during RX simulation")
+	auxNames              = flag.String("aux-names", "", "Names for the AUX channels during RX simulation, e.g. 5=ARM,6=MODE,7=BEEPER")
+	channelOrder          = flag.String("channel-order", "", "Channel sent at each position during RX simulation, overriding RXMAP, e.g. throttle,roll,pitch,yaw,5,6")
+	rxCrossfade           = flag.Duration("rx-crossfade", 500*time.Millisecond, "Time for the channels to ramp to their new values when switching input sources during RX simulation (0 disables it)")
+	rxExpr                = flag.String("rx-expr", "", "Drive channels during RX simulation with expressions of the time t in seconds, e.g. \"throttle = 1500 + 500*sin(t); 5 = 2000\"")
+	captureFile           = flag.String("capture", "", "Record all the data exchanged with the board to this file, see -replay")
+	replayFile            = flag.String("replay", "", "Print the frames in a file recorded with -capture or -export-frames in timestamp order and exit")
+	exportFrames          = flag.String("export-frames", "", "Record the decoded frames exchanged with the board to this file. With -replay, convert the capture instead of printing it")
+	replayFilter          = flag.String("replay-filter", "", "Only print these frames with -replay: command names or codes, debug, rx or tx (e.g. debug,attitude)")
+	csvFile               = flag.String("csv", "", "Write the telemetry (attitude, analog, GPS and RC) as CSV to this file")
+	csvInterval           = flag.Duration("csv-interval", 100*time.Millisecond, "Interval between samples written to the CSV file")
+	lowCellVoltage        = flag.Float64("low-cell-voltage", 0, "Print an alert when the battery voltage per cell drops below this value (0 = disabled)")
+	bell                  = flag.Bool("bell", false, "Ring the terminal bell for all notifications, same as -bell-on=all")
+	bellOn                = flag.String("bell-on", "", "Ring the terminal bell for these events: disconnect, arm, battery, overrun or all")
+	overrunThreshold      = flag.Float64("overrun-threshold", 10, "Percentage over the expected cycle time considered a loop overrun")
+	menuMode              = flag.Bool("menu", false, "Use a menu instead of single key commands")
+	saveConfigFile        = flag.String("save-config", "", "Save the board configuration to this file")
+	compareConfigFile     = flag.String("compare-config", "", "Compare the board configuration against the one saved in this file")
+	dumpSettings          = flag.Bool("dump-settings", false, "Print all the settings exposed by the board via MSP and their values (INAV only)")
+	safeQuit              = flag.Bool("safe-quit", true, "When quitting during RX simulation, send low throttle, centered sticks and low AUX channels first")
+	probe                 = flag.Bool("probe", false, "Wait for any valid MSP frame, print the result and exit. Useful for checking the cable and port")
+	probeTimeout          = flag.Duration("probe-timeout", 3*time.Second, "Maximum time to wait for a valid frame with -probe")
+	benchmark             = flag.Duration("benchmark", 0, "Measure the link throughput for this long after connecting (e.g. 5s)")
+	cliReplayFile         = flag.String("cli-replay", "", "Send the commands in this CLI diff or dump file to the board and save them")
+	flashDiff             = flag.Bool("flash-diff", false, "Print the settings that changed after flashing")
+	requireVariant        = flag.String("require-variant", "", "Exit with an error if the board is not running this firmware variant (e.g. INAV, BTFL)")
+	requireVersion        = flag.String("require-version", "", "Exit with an error if the board is not running this firmware version (e.g. 2.1 or 2.1.0)")
+	listPorts             = flag.Bool("list", false, "List the serial ports which might be connected to a board and exit")
+	logFile               = flag.String("logfile", "", "Copy everything printed to the terminal to this file, with timestamps. Useful for bug reports")
 
 	inputSigInt = byte(3) // ctrl+c
 )
@@ -29,15 +84,23 @@ const (
 	kmArrowRight = 253
 	kmArrowDown  = 254
 	kmArrowUp    = 255
-)
 
-type MyPIDReceiver struct {
-}
+	// kmEscapeTimeout is the time to wait for the rest of an escape
+	// sequence before considering it a lone ESC key press.
+	kmEscapeTimeout = 50 * time.Millisecond
+)
 
 type keyboardMonitor struct {
 	t     *term.Term
 	isRaw bool
 	mu    sync.Mutex
+	// If non-nil, everything written is also copied here
+	log *transcript
+	// Only used by Get(). See readLoop().
+	reads   chan []byte
+	readErr error
+	// Bytes read but not returned by Get() yet
+	pending []byte
 }
 
 func (km *keyboardMonitor) Open() error {
@@ -57,24 +120,131 @@ func (km *keyboardMonitor) Open() error {
 	return nil
 }
 
+// Get returns the next key pressed. Input might arrive in chunks
+// with several keys (e.g. when pasting) or with escape sequences split
+// across them, so it's buffered and parsed by nextKey().
 func (km *keyboardMonitor) Get() (byte, error) {
 	km.mu.Lock()
 	t := km.t
 	isRaw := km.isRaw
 	km.mu.Unlock()
-	if t != nil && isRaw {
-		buf := make([]byte, 3)
+	if t == nil || !isRaw {
+		return 0, nil
+	}
+	if km.reads == nil {
+		km.reads = make(chan []byte)
+		go km.readLoop(t)
+	}
+	for {
+		key, n, ok := nextKey(km.pending)
+		if n > 0 {
+			km.pending = km.pending[n:]
+			if ok {
+				return key, nil
+			}
+			continue
+		}
+		// An incomplete escape sequence is either split across
+		// reads or a lone ESC key press, which can only be told
+		// apart by waiting a bit for the rest.
+		var timeout <-chan time.Time
+		if len(km.pending) > 0 {
+			timeout = time.After(kmEscapeTimeout)
+		}
+		select {
+		case data, ok := <-km.reads:
+			if !ok {
+				return 0, km.readErr
+			}
+			km.pending = append(km.pending, data...)
+		case <-timeout:
+			pending := km.pending
+			km.pending = nil
+			if len(pending) == 1 {
+				return kmEscape, nil
+			}
+			// Drop the truncated sequence
+		}
+	}
+}
+
+// readLoop reads from the terminal and sends the data to km.reads,
+// closing it after an error.
+func (km *keyboardMonitor) readLoop(t *term.Term) {
+	for {
+		buf := make([]byte, 64)
 		n, err := t.Read(buf)
+		if n > 0 {
+			km.reads <- buf[:n]
+		}
 		if err != nil {
-			return 0, err
+			km.readErr = err
+			close(km.reads)
+			return
 		}
-		if n == 3 && buf[0] == 27 && buf[1] == 91 {
-			// Arrow key
-			return 255 - (buf[2] - 65), nil
+	}
+}
+
+// nextKey parses the first key in buf, returning it and the number
+// of bytes it takes. n is zero if buf doesn't contain a whole key yet,
+// while ok is false for escape sequences which don't map to a key
+// (e.g. function keys or bracketed paste markers) and must be skipped.
+func nextKey(buf []byte) (key byte, n int, ok bool) {
+	if len(buf) == 0 {
+		return 0, 0, false
+	}
+	if buf[0] != kmEscape {
+		return buf[0], 1, true
+	}
+	if len(buf) < 2 {
+		return 0, 0, false
+	}
+	switch buf[1] {
+	case '[':
+		// CSI: parameter and intermediate bytes followed
+		// by a final byte in [0x40, 0x7e]
+		for ii := 2; ii < len(buf); ii++ {
+			c := buf[ii]
+			if c >= 0x40 && c <= 0x7e {
+				if ii == 2 {
+					if key, ok := arrowKey(c); ok {
+						return key, 3, true
+					}
+				}
+				return 0, ii + 1, false
+			}
+			if c < 0x20 || c > 0x3f {
+				// Not a valid sequence, skip ESC [
+				return 0, 2, false
+			}
 		}
-		return buf[0], nil
+		return 0, 0, false
+	case 'O':
+		// SS3, used for the arrows in application cursor mode
+		if len(buf) < 3 {
+			return 0, 0, false
+		}
+		if key, ok := arrowKey(buf[2]); ok {
+			return key, 3, true
+		}
+		return 0, 3, false
 	}
-	return 0, nil
+	// ESC pressed right before another key
+	return kmEscape, 1, true
+}
+
+func arrowKey(c byte) (byte, bool) {
+	switch c {
+	case 'A':
+		return kmArrowUp, true
+	case 'B':
+		return kmArrowDown, true
+	case 'C':
+		return kmArrowRight, true
+	case 'D':
+		return kmArrowLeft, true
+	}
+	return 0, false
 }
 
 func (km *keyboardMonitor) Close() error {
@@ -89,11 +259,27 @@ func (km *keyboardMonitor) Close() error {
 	return nil
 }
 
+func formatArmingBlockers(reasons []string) string {
+	if len(reasons) == 0 {
+		return "none"
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// isTerminal returns true iff f is a character device, like a tty
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
 func (km *keyboardMonitor) Write(p []byte) (int, error) {
 	if err := km.Close(); err != nil {
 		panic(err)
 	}
 	n, err := os.Stdout.Write(p)
+	if km.log != nil {
+		km.log.Write(p)
+	}
 	if err := km.Open(); err != nil {
 		panic(err)
 	}
@@ -104,16 +290,538 @@ func printHelp(w io.Writer) {
 	help := `
 Available commands:
 h	Print this help
+i	Print the board information
+p	Print the PIDs
+s	Print the link statistics
+T	Adjust the accelerometer trim with the arrow keys
+V	Toggle verbose output (every frame sent and received)
 f	Build the firmware and flash the board
+F	Flash the latest existing binary without building it
 r	Reboot the board
+M	Reboot the board into mass storage mode
 R	Toggle RX simulation
+A	Arm the board via RX simulation
+H	Hold a channel at a given value during RX simulation (e.g. throttle=60%)
+z	Beep for a moment via the BEEPER mode during RX simulation
+E	Switch between the keyboard and the -rx-expr expressions during RX simulation
+b	Print the reasons preventing the board from arming
+B	Clear the arming blockers that can be reset remotely (BF only)
+c	Toggle printing the RC channels (simulated/board while simulating RX)
+C	Print the RC channels once
+k	Print the motor outputs
+g	Toggle graphing the gyro and accelerometer magnitudes (see -imu-interval)
+L	Toggle monitoring loop time overruns (see -overrun-threshold)
+v	Print the voltage meters configuration
+S	Print the serial ports configuration
+y	Print the battery state (BF) or the battery profile (INAV)
+x	Print the battery voltage, current and RSSI
+Y	Switch to the next battery profile (INAV only)
+o	Print the OSD elements layout
+m	Print the motor output protocol
+Z	Print the beeper configuration (BF only)
+O	Print the OSD chip and check its font
+n	Print the navigation configuration (INAV only)
+e	Write the configuration to the EEPROM
+u	Print the config storage usage (reboots the board)
 q	Quit
 
 `
 	fmt.Fprint(w, help)
 }
 
-func handleRXSimulation(fc *fc.FC, key byte) bool {
+// handleAccTrim adjusts the accelerometer trim using the arrow keys,
+// returning true iff the key was handled.
+func handleAccTrim(w io.Writer, c *fc.FC, key byte) bool {
+	var pitch, roll int16
+	switch key {
+	case kmArrowUp:
+		pitch = 1
+	case kmArrowDown:
+		pitch = -1
+	case kmArrowLeft:
+		roll = -1
+	case kmArrowRight:
+		roll = 1
+	default:
+		return false
+	}
+	if pitch, roll, err := c.NudgeAccTrim(pitch, roll); err != nil {
+		fmt.Fprintf(w, "Error adjusting accelerometer trim: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "Accelerometer trim pitch %d, roll %d\n", pitch, roll)
+	}
+	return true
+}
+
+func printConfigStorage(w io.Writer, c *fc.FC) {
+	s, err := c.GetConfigStorage()
+	if err != nil {
+		fmt.Fprintf(w, "Error retrieving config storage usage: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Config storage: %d of %d bytes used, %d free\n", s.Used, s.Total, s.Free())
+	if s.NearlyFull() {
+		fmt.Fprintf(w, "WARNING: config storage is nearly full, writing the EEPROM might fail after adding settings\n")
+	}
+}
+
+// printAllSettings prints every setting in the same format used by
+// the CLI dump command, followed by its type.
+func printAllSettings(w io.Writer, c *fc.FC) {
+	settings, err := c.DumpAllSettings()
+	if err != nil {
+		fmt.Fprintf(w, "Error retrieving settings: %v\n", err)
+		return
+	}
+	for _, s := range settings {
+		fmt.Fprintf(w, "set %s = %s\t# %s\n", s.Name, s.ValueString(), s.Type)
+	}
+	fmt.Fprintf(w, "%d settings\n", len(settings))
+}
+
+func nextBatteryProfile(w io.Writer, c *fc.FC) {
+	index, count, err := c.BatteryProfile()
+	if err == nil {
+		index = (index + 1) % count
+		err = c.SelectBatteryProfile(index)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error switching battery profile: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Switched to battery profile %d of %d\n", index+1, count)
+}
+
+// runBenchmark measures the link throughput and prints the results
+func runBenchmark(w io.Writer, c *fc.FC, d time.Duration) {
+	fmt.Fprintf(w, "Measuring throughput for %v...\n", d)
+	res, err := c.Benchmark(d)
+	if err != nil {
+		fmt.Fprintf(w, "Error running benchmark: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "%d requests, %d timeouts: %.1f frames/s, %.0f bytes/s\n",
+		res.Requests, res.Timeouts, res.FramesPerSecond(), res.BytesPerSecond())
+	fmt.Fprintf(w, "Latency: avg %v, min %v, max %v\n",
+		res.AvgLatency.Round(time.Microsecond), res.MinLatency.Round(time.Microsecond), res.MaxLatency.Round(time.Microsecond))
+	if res.OtherFrames > 0 {
+		fmt.Fprintf(w, "%d other frames (e.g. DEBUG_TRACE messages) were received during the benchmark, "+
+			"use -no-debug-trace and disable DEBUG_TRACE in the board for accurate results\n", res.OtherFrames)
+	}
+}
+
+func saveConfig(w io.Writer, c *fc.FC, filename string) {
+	s, err := c.CaptureConfig()
+	if err == nil {
+		err = s.Save(filename)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error saving configuration: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Configuration saved to %s\n", filename)
+}
+
+// compareConfig prints the differences between the board configuration
+// and the one stored in filename, grouped by category.
+func compareConfig(w io.Writer, c *fc.FC, filename string) {
+	ref, err := fc.LoadConfigSnapshot(filename)
+	if err != nil {
+		fmt.Fprintf(w, "Error loading configuration: %v\n", err)
+		return
+	}
+	diffs, err := c.DiffConfig(*ref)
+	if err != nil {
+		fmt.Fprintf(w, "Error comparing configuration: %v\n", err)
+		return
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(w, "Configuration matches %s\n", filename)
+		return
+	}
+	fmt.Fprintf(w, "Differences with %s (board => file):\n", filename)
+	value := func(s string) string {
+		if s == "" {
+			return "<missing>"
+		}
+		return s
+	}
+	var category string
+	for _, d := range diffs {
+		if d.Category != category {
+			category = d.Category
+			fmt.Fprintf(w, "[%s]\n", category)
+		}
+		fmt.Fprintf(w, "  %s: %s => %s\n", d.Setting, value(d.Value), value(d.Other))
+	}
+}
+
+func replayCLI(w io.Writer, c *fc.FC, filename string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading CLI commands: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Sending CLI commands from %s...\n", filename)
+	rejected, err := c.ReplayCLI(string(data))
+	for _, r := range rejected {
+		fmt.Fprintf(w, "Rejected %s\n", r)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error sending CLI commands: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "CLI commands sent and saved, %d rejected\n", len(rejected))
+}
+
+// replay prints the frames in a capture file, see fc.Replay
+func replay(w io.Writer, filename string, filter string) error {
+	f, err := fc.ParseReplayFilter(filter)
+	if err != nil {
+		return err
+	}
+	r, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return fc.Replay(w, r, f)
+}
+
+// exportCapture converts a capture file to a frame record file, see
+// fc.ExportFrames
+func exportCapture(w io.Writer, captureFilename string, exportFilename string) error {
+	r, err := os.Open(captureFilename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.Create(exportFilename)
+	if err != nil {
+		return err
+	}
+	n, err := fc.ExportFrames(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Exported %d frames to %s\n", n, exportFilename)
+	return nil
+}
+
+func init() {
+	flag.BoolVar(listPorts, "l", false, "Same as -list")
+}
+
+// printSerialPorts prints the serial ports which might be connected
+// to a board
+func printSerialPorts(w io.Writer) error {
+	ports, err := fc.ListSerialPorts()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		fmt.Fprintf(w, "No serial ports found\n")
+		return nil
+	}
+	for _, p := range ports {
+		if p.Likely {
+			fmt.Fprintf(w, "%s (likely a board)\n", p.Name)
+		} else {
+			fmt.Fprintf(w, "%s\n", p.Name)
+		}
+	}
+	return nil
+}
+
+// suggestPort offers to use the port when there's exactly one which
+// is likely a board, returning it if the user accepts. It returns an
+// empty string otherwise.
+func suggestPort() string {
+	if !isTerminal(os.Stdin) {
+		return ""
+	}
+	ports, err := fc.ListSerialPorts()
+	if err != nil {
+		return ""
+	}
+	var likely []string
+	for _, p := range ports {
+		if p.Likely {
+			likely = append(likely, p.Name)
+		}
+	}
+	if len(likely) != 1 {
+		return ""
+	}
+	fmt.Fprintf(os.Stdout, "No port given, use %s? [Y/n] ", likely[0])
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return likely[0]
+	}
+	return ""
+}
+
+// runProbe waits for a valid frame on the port in the options,
+// printing the result and exiting with exitNoResponse if none arrives.
+func runProbe(km *keyboardMonitor, opts fc.FCOptions, timeout time.Duration) {
+	if opts.BaudRate == 0 {
+		fmt.Fprintf(km, "Probing %s for up to %v...\n", opts.PortName, timeout)
+	} else {
+		fmt.Fprintf(km, "Probing %s @ %dbps for up to %v...\n", opts.PortName, opts.BaudRate, timeout)
+	}
+	res, err := fc.Probe(opts, timeout)
+	if err != nil {
+		fatal(km, err)
+	}
+	fmt.Fprintf(km, "Received a valid %s frame after %v", msp.CommandName(res.Frame.Code), res.Elapsed.Round(time.Millisecond))
+	if res.Invalid > 0 {
+		fmt.Fprintf(km, " (%d invalid frames before it)", res.Invalid)
+	}
+	fmt.Fprintf(km, "\n")
+}
+
+// rebootIntoMSC reboots the board into mass storage mode, so
+// e.g. blackbox logs can be downloaded.
+func rebootIntoMSC(w io.Writer, c *fc.FC) {
+	fmt.Fprintf(w, "Rebooting into mass storage mode...\n")
+	if err := c.RebootInto(fc.RebootMSC); err != nil {
+		fmt.Fprintf(w, "Error rebooting board: %v\n", err)
+	}
+}
+
+// stopRXSimulation leaves the board disarmed with the sticks centered
+// before quitting, unless disabled with -safe-quit=false
+func stopRXSimulation(w io.Writer, c *fc.FC) {
+	if !*safeQuit || !c.IsSimulatingRX() {
+		return
+	}
+	fmt.Fprintf(w, "Disarming and centering the sticks before quitting...\n")
+	if err := c.StopRXSimulationSafely(); err != nil {
+		fmt.Fprintf(w, "Could not send the final RC frames: %v\n", err)
+	}
+}
+
+// lineEntry reads a line typed by the user while the terminal is in
+// raw mode, with optional tab completion.
+type lineEntry struct {
+	prompt string
+	buf    []byte
+	// complete returns the candidates for the typed text, if non-nil
+	complete func(s string) []string
+	// done is called with the line once Enter is pressed, returning
+	// the next entry or nil.
+	done func(line string) *lineEntry
+}
+
+func newLineEntry(w io.Writer, prompt string, initial string, complete func(string) []string, done func(string) *lineEntry) *lineEntry {
+	e := &lineEntry{prompt: prompt, buf: []byte(initial), complete: complete, done: done}
+	fmt.Fprintf(w, "%s%s", e.prompt, e.buf)
+	return e
+}
+
+// handleKey handles a key typed while the entry is active, returning
+// the entry that should receive the next key or nil once it's
+// finished or cancelled.
+func (e *lineEntry) handleKey(w io.Writer, key byte) *lineEntry {
+	switch key {
+	case kmEnter:
+		fmt.Fprintf(w, "\n")
+		return e.done(string(e.buf))
+	case kmEscape, inputSigInt:
+		fmt.Fprintf(w, "\n")
+		return nil
+	case kmBackspace:
+		if len(e.buf) > 0 {
+			fmt.Fprintf(w, "\b \b")
+			e.buf = e.buf[:len(e.buf)-1]
+		}
+		return e
+	case kmTab:
+		if e.complete != nil {
+			e.completeLine(w)
+		}
+		return e
+	}
+	if key >= ' ' && key < kmBackspace {
+		fmt.Fprintf(w, "%c", key)
+		e.buf = append(e.buf, key)
+	}
+	return e
+}
+
+// completeLine extends the typed text up to the longest prefix shared
+// by all the candidates, listing them if it can't be extended.
+func (e *lineEntry) completeLine(w io.Writer) {
+	s := string(e.buf)
+	candidates := e.complete(s)
+	if len(candidates) == 0 {
+		return
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(s) {
+		fmt.Fprintf(w, "%s", prefix[len(s):])
+		e.buf = []byte(prefix)
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(w, "\n%s\n%s%s", strings.Join(candidates, " "), e.prompt, e.buf)
+	}
+}
+
+// completePrefix returns the items starting with s, ignoring case
+func completePrefix(items []string, s string) []string {
+	var matches []string
+	for _, item := range items {
+		if strings.HasPrefix(strings.ToUpper(item), strings.ToUpper(s)) {
+			matches = append(matches, item)
+		}
+	}
+	return matches
+}
+
+// completeDir returns the directories starting with s
+func completeDir(s string) []string {
+	matches, _ := filepath.Glob(s + "*")
+	var dirs []string
+	for _, m := range matches {
+		if st, err := os.Stat(m); err == nil && st.IsDir() {
+			dirs = append(dirs, m+string(filepath.Separator))
+		}
+	}
+	return dirs
+}
+
+// startFlash flashes the board after pressing f or F. If the source
+// directory or the target are unknown, it asks for them first,
+// returning the entry for typing them. Typed values are kept for the
+// rest of the session.
+func startFlash(w io.Writer, c *fc.FC, key byte) *lineEntry {
+	if reason := sourceDirProblem(*sourceDir, key == 'F'); reason != "" {
+		fmt.Fprintf(w, "%s, enter the firmware source directory (ESC to cancel)\n", reason)
+		return newLineEntry(w, "Source directory: ", "", completeDir, func(dir string) *lineEntry {
+			if dir == "" {
+				return nil
+			}
+			*sourceDir = dir
+			return startFlash(w, c, key)
+		})
+	}
+	if *targetName == "" {
+		targets, _ := fc.ListTargets(*sourceDir)
+		detected := c.Info().TargetName
+		if detected == "" || (len(targets) > 0 && len(completePrefix(targets, detected)) == 0) {
+			if detected == "" {
+				fmt.Fprintf(w, "Could not detect the target, enter its name (TAB to complete, ESC to cancel)\n")
+			} else {
+				fmt.Fprintf(w, "Target %s not found in %s, enter its name (TAB to complete, ESC to cancel)\n", detected, *sourceDir)
+			}
+			complete := func(s string) []string { return completePrefix(targets, s) }
+			return newLineEntry(w, "Target: ", detected, complete, func(target string) *lineEntry {
+				if target == "" {
+					return nil
+				}
+				*targetName = target
+				return startFlash(w, c, key)
+			})
+		}
+	}
+	flash := c.Flash
+	if key == 'F' {
+		flash = c.FlashExisting
+	}
+	if err := flash(*sourceDir, *targetName); err != nil {
+		fmt.Fprintf(w, "Error flashing board: %v\n", err)
+	}
+	return nil
+}
+
+// sourceDirProblem returns why dir can't be used for flashing, or an
+// empty string if it can. Flashing existing binaries only requires
+// the obj directory.
+func sourceDirProblem(dir string, existing bool) string {
+	if existing {
+		if st, err := os.Stat(filepath.Join(dir, "obj")); err != nil || !st.IsDir() {
+			return fmt.Sprintf("No obj directory in %s", dir)
+		}
+		return ""
+	}
+	if *buildSystemName != "auto" {
+		if _, err := os.Stat(filepath.Join(dir, "Makefile")); err != nil {
+			return fmt.Sprintf("No Makefile in %s", dir)
+		}
+		return ""
+	}
+	if _, err := fc.DetectSourceTree(dir); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// crossfadeOption converts the -rx-crossfade flag to
+// fc.FCOptions.RXCrossfade, where zero means the default.
+func crossfadeOption(d time.Duration) time.Duration {
+	if d == 0 {
+		return -1
+	}
+	return d
+}
+
+// handleHoldEntry handles a key typed after pressing H, returning the
+// updated entry or nil once it's finished or cancelled.
+func handleHoldEntry(w io.Writer, c *fc.FC, entry []byte, key byte) []byte {
+	switch key {
+	case kmEnter:
+		fmt.Fprintf(w, "\n")
+		ch, value, err := rx.ParseChannelValue(string(entry))
+		if err == nil {
+			err = c.HoldRXChannel(ch, value)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "Error holding channel: %v\n", err)
+		} else {
+			fmt.Fprintf(w, "Holding CH%d at %d\n", ch, value)
+		}
+		return nil
+	case kmEscape, inputSigInt:
+		fmt.Fprintf(w, "\n")
+		return nil
+	case kmBackspace:
+		if len(entry) > 0 {
+			fmt.Fprintf(w, "\b \b")
+			entry = entry[:len(entry)-1]
+		}
+		return entry
+	}
+	if key >= ' ' && key < kmBackspace {
+		fmt.Fprintf(w, "%c", key)
+		entry = append(entry, key)
+	}
+	return entry
+}
+
+func handleRXSimulation(w io.Writer, fc *fc.FC, key byte) bool {
+	rxKey, ok := rxKeyFor(key)
+	if !ok {
+		return false
+	}
+	fc.RX().Keypress(rxKey)
+	if ch, ok := rx.KeyChannel(rxKey); ok {
+		fmt.Fprintf(w, "%s\n", fc.RXChannelState(ch))
+	}
+	return true
+}
+
+// rxKeyFor returns the RX key controlled by the given key
+func rxKeyFor(key byte) (rx.RXKey, bool) {
 	var rxKey rx.RXKey
 	switch key {
 	case 'w':
@@ -154,45 +862,237 @@ func handleRXSimulation(fc *fc.FC, key byte) bool {
 		rxKey = rx.RXKey0
 
 	default:
-		return false
+		return 0, false
 	}
-	fc.RX().Keypress(rxKey)
-	return true
+	return rxKey, true
 }
 
 func main() {
 	flag.Parse()
 
-	if *portName == "" {
-		fmt.Fprintf(os.Stderr, "Missing port\n")
+	if *replayFile != "" {
+		var err error
+		if *exportFrames != "" {
+			err = exportCapture(os.Stdout, *replayFile, *exportFrames)
+		} else {
+			err = replay(os.Stdout, *replayFile, *replayFilter)
+		}
+		if err != nil {
+			fatal(nil, err)
+		}
 		return
 	}
 
+	if *listPorts {
+		if err := printSerialPorts(os.Stdout); err != nil {
+			fatal(nil, err)
+		}
+		return
+	}
+
+	// Validate the flags before asking for the port and putting the
+	// terminal in raw mode, so usage errors are printed normally.
+	channelNames, err := rx.ParseChannelNames(*auxNames)
+	if err != nil {
+		fatal(nil, &usageError{"aux-names", err})
+	}
+
+	channelOrderValue, err := rx.ParseChannelOrder(*channelOrder)
+	if err != nil {
+		fatal(nil, &usageError{"channel-order", err})
+	}
+
+	buildSystem, err := fc.ParseBuildSystem(*buildSystemName)
+	if err != nil {
+		fatal(nil, &usageError{"build-system", err})
+	}
+
+	// Check the range before converting, since out of range values
+	// would wrap around
+	var rxTrim [4]int16
+	trimFlags := []struct {
+		name  string
+		value int
+	}{
+		{"trim-roll", *trimRoll},
+		{"trim-pitch", *trimPitch},
+		{"trim-yaw", *trimYaw},
+		{"trim-throttle", *trimThrottle},
+	}
+	for ii, t := range trimFlags {
+		if t.value > rx.MaxTrim || t.value < -rx.MaxTrim {
+			fatal(nil, &usageError{t.name, fmt.Errorf("%d must be within [-%d, %d]", t.value, rx.MaxTrim, rx.MaxTrim)})
+		}
+		rxTrim[ii] = int16(t.value)
+	}
+
+	var rxExprSource *rx.ExprSource
+	if *rxExpr != "" {
+		if rxExprSource, err = rx.ParseExprSource(*rxExpr); err != nil {
+			fatal(nil, &usageError{"rx-expr", err})
+		}
+	}
+
+	notifications, err := fc.ParseNotifications(*bellOn)
+	if err != nil {
+		fatal(nil, &usageError{"bell-on", err})
+	}
+	if *bell {
+		notifications = fc.NotifyAll
+	}
+	if !isTerminal(os.Stdout) {
+		// Don't write bells into files or pipes
+		notifications = 0
+	}
+
+	framing, err := msp.ParseSerialFraming(*serialFraming)
+	if err != nil {
+		fatal(nil, &usageError{"serial-framing", err})
+	}
+
+	if *portName == "" {
+		*portName = suggestPort()
+	}
+	if *portName == "" {
+		fmt.Fprintf(os.Stderr, "Missing port, use -l to list the available ones\n")
+		os.Exit(exitUsage)
+	}
+
 	km := &keyboardMonitor{}
 	if err := km.Open(); err != nil {
-		log.Fatal(err)
+		// Don't report errors opening the terminal as a missing port
+		fatal(nil, fmt.Errorf("opening terminal: %v", err))
 	}
 
 	defer km.Close()
 
+	if *logFile != "" {
+		tr, err := openTranscript(*logFile)
+		if err != nil {
+			fatal(km, err)
+		}
+		km.log = tr
+		defer tr.Close()
+	}
+
+	var csvLog io.Writer
+	if *csvFile != "" {
+		f, err := os.Create(*csvFile)
+		if err != nil {
+			fatal(km, err)
+		}
+		defer f.Close()
+		csvLog = f
+	}
+
+	var capture *msp.CaptureWriter
+	if *captureFile != "" {
+		f, err := os.Create(*captureFile)
+		if err != nil {
+			fatal(km, err)
+		}
+		defer f.Close()
+		if capture, err = msp.NewCaptureWriter(f); err != nil {
+			fatal(km, err)
+		}
+	}
+
+	var frameExport *msp.FrameRecordWriter
+	if *exportFrames != "" {
+		f, err := os.Create(*exportFrames)
+		if err != nil {
+			fatal(km, err)
+		}
+		defer f.Close()
+		if frameExport, err = msp.NewFrameRecordWriter(f); err != nil {
+			fatal(km, err)
+		}
+	}
+
 	opts := fc.FCOptions{
-		PortName:         *portName,
-		BaudRate:         *baudRate,
-		Stdout:           km,
-		EnableDebugTrace: !*doNotEnableDebugTrace,
+		PortName:               *portName,
+		BaudRate:               *baudRate,
+		SerialFraming:          framing,
+		Stdout:                 km,
+		EnableDebugTrace:       !*doNotEnableDebugTrace,
+		DecodeAll:              *decodeAll,
+		ReadBufferSize:         *readBufferSize,
+		ReuseFrames:            *reuseFrames,
+		ChecksumErrorThreshold: *checksumThreshold,
+		Bluetooth:              *bluetooth,
+		KeepaliveInterval:      *keepalive,
+		ReadTimeout:            *readTimeout,
+		ArmChannel:             *armChannel,
+		WaitForBoard:           *waitForBoard,
+		EmulateRangefinder:     *rangefinderMM != 0,
+		RangefinderDistance:    int32(*rangefinderMM),
+		RCKeepaliveInterval:    *rcKeepalive,
+		IMUSampleInterval:      *imuInterval,
+		AdaptiveRCRate:         *adaptiveRCRate,
+		ResumeRXSimulation:     *resumeRX,
+		VerifyFlashedRevision:  *verifyRevision,
+		DiffConfigAfterFlash:   *flashDiff,
+		LowCellVoltage:         *lowCellVoltage,
+		Notifications:          notifications,
+		RXTrim:                 rxTrim,
+		RXDeadband:             uint16(*rxDeadband),
+		ChannelNames:           channelNames,
+		ChannelOrder:           channelOrderValue,
+		RXExpressions:          rxExprSource,
+		RXCrossfade:            crossfadeOption(*rxCrossfade),
+		LoopOverrunThreshold:   *overrunThreshold / 100,
+		CSVLog:                 csvLog,
+		CSVLogInterval:         *csvInterval,
+		Capture:                capture,
+		BuildSystem:            buildSystem,
+		FrameExport:            frameExport,
+	}
+	if *probe {
+		runProbe(km, opts, *probeTimeout)
+		return
 	}
 	fc, err := fc.NewFC(opts)
 	if err != nil {
-		km.Close()
-		log.Fatal(err)
+		fatal(km, err)
 	}
 
-	fmt.Fprintf(km, "Connected to %s @ %dbps. Press 'h' for help.\n", *portName, *baudRate)
+	var ms *menuState
+	if *menuMode {
+		ms = newMenuState(km)
+		fmt.Fprintf(km, "Connected to %s @ %dbps.\n", *portName, fc.BaudRate())
+	} else {
+		fmt.Fprintf(km, "Connected to %s @ %dbps. Press 'h' for help.\n", *portName, fc.BaudRate())
+	}
 
 	go func() {
 		defer km.Close()
-		fc.StartUpdating(MyPIDReceiver{})
+		fc.StartUpdating(MyPIDReceiver{w: km})
 	}()
+	if *requireVariant != "" || *requireVersion != "" {
+		if err := fc.WaitForInfo().Check(*requireVariant, *requireVersion); err != nil {
+			fatal(km, err)
+		}
+	}
+	if *saveConfigFile != "" {
+		fc.WaitForInfo()
+		saveConfig(km, fc, *saveConfigFile)
+	}
+	if *compareConfigFile != "" {
+		fc.WaitForInfo()
+		compareConfig(km, fc, *compareConfigFile)
+	}
+	if *cliReplayFile != "" {
+		fc.WaitForInfo()
+		replayCLI(km, fc, *cliReplayFile)
+	}
+	if *dumpSettings {
+		fc.WaitForInfo()
+		printAllSettings(km, fc)
+	}
+	if *benchmark > 0 {
+		fc.WaitForInfo()
+		runBenchmark(km, fc, *benchmark)
+	}
 	input := make(chan byte)
 	go func() {
 		for {
@@ -202,45 +1102,229 @@ func main() {
 			}
 		}
 	}()
+	if ms != nil {
+		ms.render()
+	}
+	// Whether the arrow keys adjust the accelerometer trim
+	var trimming bool
+	// Channel value being typed after pressing H, nil if none
+	var holdEntry []byte
+	// Line being typed, e.g. the target after pressing f. Nil if none.
+	var entry *lineEntry
 	// main loop
 	loop := func() {
 		for {
 			select {
 			case k := <-input:
-				if fc.IsSimulatingRX() && handleRXSimulation(fc, k) {
+				if entry != nil {
+					entry = entry.handleKey(km, k)
+					if entry == nil && ms != nil {
+						ms.render()
+					}
+					break
+				}
+				if holdEntry != nil {
+					holdEntry = handleHoldEntry(km, fc, holdEntry, k)
+					if holdEntry == nil && ms != nil {
+						ms.render()
+					}
+					break
+				}
+				if trimming {
+					if k == 'T' {
+						trimming = false
+						if err := fc.SaveToEEPROM(); err != nil {
+							fmt.Fprintf(km, "Error saving accelerometer trim: %v\n", err)
+						} else {
+							fmt.Fprintf(km, "Accelerometer trim saved\n")
+						}
+						if ms != nil {
+							ms.render()
+						}
+						break
+					}
+					if handleAccTrim(km, fc, k) {
+						break
+					}
+				}
+				if ms != nil {
+					var ok bool
+					if k, ok = ms.handleKey(k, fc.IsSimulatingRX()); !ok {
+						break
+					}
+				}
+				if fc.IsSimulatingRX() && handleRXSimulation(km, fc, k) {
 					break
 				}
 				switch k {
 				case inputSigInt:
+					stopRXSimulation(km, fc)
 					km.Close()
 					syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 				case 'h':
 					printHelp(km)
-				case 'f':
-					if *targetName == "" && !fc.HasDetectedTargetName() {
-						fmt.Fprintf(km, "missing target name, specify one with -t\n")
+				case 'i':
+					printInfo(km, fc.Info())
+				case 'p':
+					if err := fc.GetPIDs(); err != nil {
+						fmt.Fprintf(km, "Error retrieving PIDs: %v\n", err)
+					}
+				case 'H':
+					if !fc.IsSimulatingRX() {
+						fmt.Fprintf(km, "RX simulation is not active, press R to start it\n")
 						break
 					}
-					if err := fc.Flash(*sourceDir, *targetName); err != nil {
-						fmt.Fprintf(km, "Error flashing board: %v\n", err)
+					fmt.Fprintf(km, "Hold channel at (e.g. throttle=1600, 4=60%%), ESC to cancel: ")
+					holdEntry = []byte{}
+				case 'T':
+					trimming = true
+					if pitch, roll, err := fc.GetAccTrim(); err != nil {
+						fmt.Fprintf(km, "Error retrieving accelerometer trim: %v\n", err)
+						trimming = false
+					} else {
+						fmt.Fprintf(km, "Accelerometer trim pitch %d, roll %d. Use the arrow keys to adjust it, T to save it.\n", pitch, roll)
 					}
+				case 'V':
+					if fc.ToggleVerbose() {
+						fmt.Fprintf(km, "Verbose output enabled. Press V again to disable.\n")
+					} else {
+						fmt.Fprintf(km, "Verbose output disabled\n")
+					}
+				case 's':
+					printStats(km, fc)
+				case 'f', 'F':
+					entry = startFlash(km, fc, k)
 				case 'r':
 					// Reboot the board
-					fc.Reboot()
+					if err := fc.Reboot(); err != nil {
+						fmt.Fprintf(km, "Error rebooting board: %v\n", err)
+					}
+				case 'M':
+					rebootIntoMSC(km, fc)
 				case 'R':
 					enabled, err := fc.ToggleRXSimulation()
 					if err != nil {
-						log.Fatal(err)
+						fatal(km, err)
 					}
 					if enabled {
 						fmt.Fprintf(km, "Starting RX simulation. Use WASD and arrow keys to control sticks. Press R again to disable.\n")
 					} else {
 						fmt.Fprintf(km, "Stopping RX simulation\n")
 					}
+				case 'A':
+					fmt.Fprintf(km, "Arming...\n")
+					if err := fc.ArmSequence(); err != nil {
+						fmt.Fprintf(km, "Error arming: %v\n", err)
+					} else {
+						fmt.Fprintf(km, "Board armed\n")
+					}
+				case 'b':
+					if reasons, err := fc.ArmingBlockers(); err != nil {
+						fmt.Fprintf(km, "Error retrieving arming blockers: %v\n", err)
+					} else {
+						fmt.Fprintf(km, "Arming blockers: %s\n", formatArmingBlockers(reasons))
+					}
+				case 'B':
+					if before, after, err := fc.ClearArmingBlockers(); err != nil {
+						fmt.Fprintf(km, "Error clearing arming blockers: %v\n", err)
+					} else {
+						fmt.Fprintf(km, "Arming blockers before: %s\n", formatArmingBlockers(before))
+						fmt.Fprintf(km, "Arming blockers after: %s\n", formatArmingBlockers(after))
+					}
+				case 'c':
+					if fc.ToggleRCMonitor() {
+						fmt.Fprintf(km, "Printing RC channels. Press c again to stop.\n")
+					} else {
+						fmt.Fprintf(km, "Stopped printing RC channels\n")
+					}
+				case 'C':
+					if err := fc.PrintRC(); err != nil {
+						fmt.Fprintf(km, "Error reading RC channels: %v\n", err)
+					}
+				case 'k':
+					if err := fc.PrintMotors(); err != nil {
+						fmt.Fprintf(km, "Error reading motor outputs: %v\n", err)
+					}
+				case 'L':
+					if enabled, err := fc.ToggleOverrunMonitor(); err != nil {
+						fmt.Fprintf(km, "Error monitoring loop overruns: %v\n", err)
+					} else if enabled {
+						fmt.Fprintf(km, "Monitoring loop overruns. Press L again to stop.\n")
+					} else {
+						fmt.Fprintf(km, "Stopped monitoring loop overruns\n")
+					}
+				case 'g':
+					if fc.ToggleIMUMonitor() {
+						fmt.Fprintf(km, "Graphing the IMU. Press g again to stop.\n")
+					} else {
+						fmt.Fprintf(km, "Stopped graphing the IMU\n")
+					}
+				case 'S':
+					if err := fc.PrintSerialConfig(); err != nil {
+						fmt.Fprintf(km, "Error retrieving serial ports configuration: %v\n", err)
+					}
+				case 'v':
+					if err := fc.PrintVoltageMeterConfig(); err != nil {
+						fmt.Fprintf(km, "Error retrieving voltage meters: %v\n", err)
+					}
+				case 'y':
+					if err := fc.PrintBattery(); err != nil {
+						fmt.Fprintf(km, "Error retrieving battery: %v\n", err)
+					}
+				case 'x':
+					if err := fc.PrintAnalog(); err != nil {
+						fmt.Fprintf(km, "Error retrieving battery: %v\n", err)
+					}
+				case 'Y':
+					nextBatteryProfile(km, fc)
+				case 'o':
+					if err := fc.PrintOSDConfig(); err != nil {
+						fmt.Fprintf(km, "Error retrieving OSD config: %v\n", err)
+					}
+				case 'm':
+					if err := fc.PrintMotorProtocol(); err != nil {
+						fmt.Fprintf(km, "Error retrieving motor protocol: %v\n", err)
+					}
+				case 'E':
+					if enabled, err := fc.ToggleRXExpressions(); err != nil {
+						fmt.Fprintf(km, "Error switching input source: %v\n", err)
+					} else if enabled {
+						fmt.Fprintf(km, "Channels driven by the expressions. Press E again to switch to the keyboard.\n")
+					} else {
+						fmt.Fprintf(km, "Channels driven by the keyboard\n")
+					}
+				case 'z':
+					if err := fc.Beep(); err != nil {
+						fmt.Fprintf(km, "Error beeping: %v\n", err)
+					}
+				case 'O':
+					if err := fc.CheckOSDFont(); err != nil {
+						fmt.Fprintf(km, "Error checking OSD: %v\n", err)
+					}
+				case 'Z':
+					if err := fc.PrintBeeperConfig(); err != nil {
+						fmt.Fprintf(km, "Error retrieving beeper configuration: %v\n", err)
+					}
+				case 'n':
+					if err := fc.PrintNavConfig(); err != nil {
+						fmt.Fprintf(km, "Error retrieving navigation config: %v\n", err)
+					}
+				case 'u':
+					printConfigStorage(km, fc)
+				case 'e':
+					if err := fc.SaveToEEPROM(); err != nil {
+						fmt.Fprintf(km, "Error saving to EEPROM: %v\n", err)
+					} else {
+						fmt.Fprintf(km, "Configuration saved to EEPROM\n")
+					}
 				case 'q':
 					// Quit
+					stopRXSimulation(km, fc)
 					return
 				}
+				if ms != nil {
+					ms.render()
+				}
 				/*case frame := <-mspFrames:
 				// Close the keyboard monitor while handling
 				// a frame, since it might require printing
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	kmTab       = 9
+	kmEnter     = 13
+	kmEscape    = 27
+	kmBackspace = 127
+)
+
+// menuItem is either a submenu or a command, identified by the key
+// which runs it in the single key mode.
+type menuItem struct {
+	label string
+	key   byte
+	sub   *menu
+}
+
+type menu struct {
+	title string
+	items []menuItem
+	// Forward keys to the RX simulation while it's active
+	rx bool
+}
+
+func newMainMenu() *menu {
+	return &menu{
+		title: "Main menu",
+		items: []menuItem{
+			{label: "Info", sub: &menu{
+				title: "Info",
+				items: []menuItem{
+					{label: "Board information", key: 'i'},
+					{label: "Voltage meters", key: 'v'},
+					{label: "Serial ports", key: 'S'},
+					{label: "Battery", key: 'y'},
+					{label: "Battery and RSSI summary", key: 'x'},
+					{label: "OSD elements layout", key: 'o'},
+					{label: "OSD chip and font", key: 'O'},
+					{label: "Motor protocol", key: 'm'},
+					{label: "Beeper configuration", key: 'Z'},
+					{label: "Navigation configuration", key: 'n'},
+				},
+			}},
+			{label: "RC Simulation", sub: &menu{
+				title: "RC Simulation",
+				items: []menuItem{
+					{label: "Toggle RX simulation", key: 'R'},
+					{label: "Arm", key: 'A'},
+					{label: "Hold a channel at a value", key: 'H'},
+					{label: "Beep", key: 'z'},
+					{label: "Switch between keyboard and expressions", key: 'E'},
+					{label: "Print the arming blockers", key: 'b'},
+					{label: "Clear the arming blockers", key: 'B'},
+					{label: "Toggle printing the RC channels", key: 'c'},
+				},
+				rx: true,
+			}},
+			{label: "PID/Rates", sub: &menu{
+				title: "PID/Rates",
+				items: []menuItem{
+					{label: "Print PIDs", key: 'p'},
+				},
+			}},
+			{label: "Flash", sub: &menu{
+				title: "Flash",
+				items: []menuItem{
+					{label: "Build and flash", key: 'f'},
+					{label: "Flash the latest existing binary", key: 'F'},
+				},
+			}},
+			{label: "Settings", sub: &menu{
+				title: "Settings",
+				items: []menuItem{
+					{label: "Write the configuration to the EEPROM", key: 'e'},
+					{label: "Switch to the next battery profile", key: 'Y'},
+					{label: "Config storage usage", key: 'u'},
+					{label: "Adjust the accelerometer trim", key: 'T'},
+					{label: "Reboot", key: 'r'},
+					{label: "Reboot into mass storage mode", key: 'M'},
+				},
+			}},
+			{label: "Diagnostics", sub: &menu{
+				title: "Diagnostics",
+				items: []menuItem{
+					{label: "Link statistics", key: 's'},
+					{label: "Toggle verbose output", key: 'V'},
+					{label: "Toggle printing the RC channels", key: 'c'},
+					{label: "Print the RC channels once", key: 'C'},
+					{label: "Print the motor outputs", key: 'k'},
+					{label: "Toggle graphing the IMU", key: 'g'},
+					{label: "Toggle monitoring loop overruns", key: 'L'},
+				},
+			}},
+			{label: "Quit", key: 'q'},
+		},
+	}
+}
+
+// menuState routes keys according to the active menu. Keys that run
+// a command are translated to their key in the single key mode, so the
+// main loop handles them the same way in both modes.
+type menuState struct {
+	w        io.Writer
+	stack    []*menu
+	selected int
+}
+
+func newMenuState(w io.Writer) *menuState {
+	return &menuState{
+		w:     w,
+		stack: []*menu{newMainMenu()},
+	}
+}
+
+func (s *menuState) current() *menu {
+	return s.stack[len(s.stack)-1]
+}
+
+// render prints the active menu. Since the MSP output is printed as
+// it arrives, the menu is printed again after each command.
+func (s *menuState) render() {
+	m := s.current()
+	var titles []string
+	for _, mm := range s.stack {
+		titles = append(titles, mm.title)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "\n== %s ==\n", strings.Join(titles, " > "))
+	for ii, item := range m.items {
+		marker := " "
+		if ii == s.selected {
+			marker = ">"
+		}
+		if item.sub != nil {
+			fmt.Fprintf(&buf, "%s   %s...\n", marker, item.label)
+		} else {
+			fmt.Fprintf(&buf, "%s %c %s\n", marker, item.key, item.label)
+		}
+	}
+	if m.rx {
+		buf.WriteString("While simulating RX, WASD and arrow keys control the sticks.\n")
+	}
+	if len(s.stack) > 1 {
+		buf.WriteString("Up/Down to move, Enter to select, Esc to go back\n")
+	} else {
+		buf.WriteString("Up/Down to move, Enter to select\n")
+	}
+	fmt.Fprint(s.w, buf.String())
+}
+
+// handleKey handles a key pressed while in menu mode, returning the
+// key to handle as in single key mode, if any.
+func (s *menuState) handleKey(k byte, simulatingRX bool) (byte, bool) {
+	m := s.current()
+	switch k {
+	case inputSigInt:
+		return k, true
+	case kmEscape, kmBackspace:
+		if len(s.stack) > 1 {
+			s.stack = s.stack[:len(s.stack)-1]
+			s.selected = 0
+			s.render()
+		}
+		return 0, false
+	}
+	if m.rx && simulatingRX {
+		// Arrows control the sticks, so menu navigation is
+		// disabled. Only RX keys and the commands in this menu
+		// can be used.
+		if _, ok := rxKeyFor(k); ok {
+			return k, true
+		}
+		for _, item := range m.items {
+			if item.key == k {
+				return k, true
+			}
+		}
+		return 0, false
+	}
+	switch k {
+	case kmArrowUp:
+		if s.selected > 0 {
+			s.selected--
+		}
+		s.render()
+	case kmArrowDown:
+		if s.selected < len(m.items)-1 {
+			s.selected++
+		}
+		s.render()
+	case kmEnter:
+		item := m.items[s.selected]
+		if item.sub != nil {
+			s.stack = append(s.stack, item.sub)
+			s.selected = 0
+			s.render()
+			return 0, false
+		}
+		return item.key, true
+	default:
+		// Allow using the command keys as shortcuts
+		for _, item := range m.items {
+			if item.sub == nil && item.key == k {
+				return k, true
+			}
+		}
+	}
+	return 0, false
+}
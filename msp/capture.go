@@ -0,0 +1,152 @@
+package msp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Capture files store the raw bytes exchanged with a board. They
+// start with captureMagic, followed by a record for each read from or
+// write to the port:
+//
+//	timestamp  int64, nanoseconds since the Unix epoch
+//	direction  byte, CaptureSent or CaptureReceived
+//	length     uint32
+//	data       length bytes
+//
+// Integers are little endian, like in MSP.
+const captureMagic = "MSPCAP1\n"
+
+// Capture record directions
+const (
+	CaptureSent     = 'T'
+	CaptureReceived = 'R'
+)
+
+var errInvalidCapture = errors.New("not an MSP capture file")
+
+// CaptureRecord is a chunk of data read from or written to the port
+type CaptureRecord struct {
+	Time      time.Time
+	Direction byte
+	Data      []byte
+}
+
+// CaptureWriter writes capture files, see MSP.SetCapture(). It's safe
+// for concurrent use.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewCaptureWriter writes the capture file header to w and returns
+// a CaptureWriter for adding records to it.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// Write appends a record to the capture. Once a write fails, all the
+// following ones return the same error.
+func (c *CaptureWriter) Write(rec CaptureRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	var header [13]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(rec.Time.UnixNano()))
+	header[8] = rec.Direction
+	binary.LittleEndian.PutUint32(header[9:], uint32(len(rec.Data)))
+	if _, c.err = c.w.Write(header[:]); c.err == nil {
+		_, c.err = c.w.Write(rec.Data)
+	}
+	return c.err
+}
+
+func (c *CaptureWriter) record(direction byte, data []byte) {
+	c.Write(CaptureRecord{Time: time.Now(), Direction: direction, Data: data})
+}
+
+// CaptureReader reads the records in a capture file
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader checks the capture file header in r and returns
+// a CaptureReader for reading its records.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != captureMagic {
+		return nil, errInvalidCapture
+	}
+	return &CaptureReader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (c *CaptureReader) Next() (*CaptureRecord, error) {
+	var header [13]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated capture record")
+		}
+		return nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(header[9:]))
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, errors.New("truncated capture record")
+	}
+	return &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[:8]))),
+		Direction: header[8],
+		Data:      data,
+	}, nil
+}
+
+// SetCapture records all the data read from and written to the port
+// in c. It must be called before reading or writing any frames.
+func (m *MSP) SetCapture(c *CaptureWriter) {
+	m.capture = c
+}
+
+// NewFromReader returns an MSP which reads frames from r instead of a
+// port, e.g. for decoding captured data. It can't write frames.
+func NewFromReader(r io.Reader) *MSP {
+	m := &MSP{stream: r}
+	m.r = bufio.NewReaderSize(m.captureReader(r), DefaultReadBufferSize)
+	return m
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// captureReader returns a reader which reads from src, recording the
+// data if capturing. m.r must wrap it.
+func (m *MSP) captureReader(src io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := src.Read(p)
+		if n > 0 && m.capture != nil {
+			m.capture.record(CaptureReceived, p[:n])
+		}
+		return n, err
+	})
+}
+
+// writePort writes to the port, recording the data if capturing
+func (m *MSP) writePort(p []byte) (int, error) {
+	if m.capture != nil {
+		m.capture.record(CaptureSent, p)
+	}
+	return m.port.Write(p)
+}
@@ -0,0 +1,89 @@
+package msp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadFrameFromConn(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		remote.Write(mspV1Encode('>', MspAPIVersion, []byte{0, 2, 4}))
+		remote.Write(mspV2Encode('>', Msp2INAVStatus, []byte{5, 6}))
+	}()
+
+	m := NewWithConn(local)
+	frames := []struct {
+		code    uint16
+		version uint8
+		payload []byte
+	}{
+		{MspAPIVersion, 1, []byte{0, 2, 4}},
+		{Msp2INAVStatus, 2, []byte{5, 6}},
+	}
+	for _, want := range frames {
+		fr, err := m.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fr.Code != want.code || fr.Version != want.version || !bytes.Equal(fr.Payload, want.payload) {
+			t.Errorf("ReadFrame() = code %d, version %d, payload %v, want code %d, version %d, payload %v",
+				fr.Code, fr.Version, fr.Payload, want.code, want.version, want.payload)
+		}
+	}
+}
+
+func TestReadFrameFromConnTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	m := NewWithConn(local)
+	if err := m.SetReadTimeout(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadFrame(); err != ErrReadTimeout {
+		t.Fatalf("ReadFrame() = %v, want ErrReadTimeout", err)
+	}
+
+	// The connection must still be usable after a timeout
+	go remote.Write(mspV1Encode('>', MspFCVariant, []byte("INAV")))
+	fr, err := m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspFCVariant || string(fr.Payload) != "INAV" {
+		t.Errorf("ReadFrame() = code %d, payload %q, want code %d, payload \"INAV\"", fr.Code, fr.Payload, MspFCVariant)
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	m := NewWithConn(local)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := m.ReadFrame()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err == nil {
+		t.Error("ReadFrame() on a closed connection returned no error")
+	}
+	if _, err := m.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame() after Close() = %v, want io.EOF", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close() = %v, want nil", err)
+	}
+}
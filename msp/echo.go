@@ -0,0 +1,57 @@
+package msp
+
+import (
+	"bytes"
+	"time"
+)
+
+const (
+	// Number of sent frames remembered for echo detection and
+	// how long they're remembered for.
+	maxSentFrames = 16
+	echoWindow    = 500 * time.Millisecond
+)
+
+type sentFrame struct {
+	code    uint16
+	payload []byte
+	at      time.Time
+}
+
+// trackSent records a frame sent to the board, so its echo can be
+// detected by IsEcho()
+func (m *MSP) trackSent(code uint16, payload []byte) {
+	m.sentMu.Lock()
+	defer m.sentMu.Unlock()
+	if len(m.sent) >= maxSentFrames {
+		m.sent = m.sent[1:]
+	}
+	m.sent = append(m.sent, sentFrame{
+		code:    code,
+		payload: append([]byte(nil), payload...),
+		at:      time.Now(),
+	})
+}
+
+// IsEcho returns true iff fr is a request that exactly matches a frame
+// we've just sent, which happens when the line is half-duplex or TX and
+// RX are shorted. The matching sent frame is forgotten, so each sent
+// frame is only considered echoed once.
+func (m *MSP) IsEcho(fr *MSPFrame) bool {
+	if fr.Direction != '<' {
+		return false
+	}
+	m.sentMu.Lock()
+	defer m.sentMu.Unlock()
+	now := time.Now()
+	for ii, s := range m.sent {
+		if now.Sub(s.at) > echoWindow {
+			continue
+		}
+		if s.code == fr.Code && bytes.Equal(s.payload, fr.Payload) {
+			m.sent = append(m.sent[:ii], m.sent[ii+1:]...)
+			return true
+		}
+	}
+	return false
+}
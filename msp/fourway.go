@@ -0,0 +1,90 @@
+package msp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// BLHeli 4-way interface protocol, used to talk to the ESCs after
+// sending MSP_SET_4WAY_IF. See 4way-if.c in the firmware sources.
+const (
+	fourWayPCSync = 0x2F
+	fourWayFCSync = 0x2E
+
+	fourWayCmdInterfaceExit = 0x34
+
+	fourWayAckOK = 0x00
+)
+
+func crc16XModem(crc uint16, b byte) uint16 {
+	crc ^= uint16(b) << 8
+	for ii := 0; ii < 8; ii++ {
+		if (crc & 0x8000) != 0 {
+			crc = (crc << 1) ^ 0x1021
+		} else {
+			crc = crc << 1
+		}
+	}
+	return crc
+}
+
+func fourWayEncode(cmd byte, addr uint16, params []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(fourWayPCSync)
+	buf.WriteByte(cmd)
+	buf.WriteByte(byte(addr >> 8))
+	buf.WriteByte(byte(addr))
+	// A length of 0 means 256 parameters
+	buf.WriteByte(byte(len(params)))
+	buf.Write(params)
+	crc := uint16(0)
+	for _, v := range buf.Bytes() {
+		crc = crc16XModem(crc, v)
+	}
+	buf.WriteByte(byte(crc >> 8))
+	buf.WriteByte(byte(crc))
+	return buf.Bytes()
+}
+
+// Exit4WayInterface sends cmd_InterfaceExit to a port in 4-way interface
+// mode, returning it to MSP mode. Nothing else must be reading from the
+// port while this function runs, since it reads the response.
+func (m *MSP) Exit4WayInterface() error {
+	frame := fourWayEncode(fourWayCmdInterfaceExit, 0, []byte{0})
+	if _, err := m.writePort(frame); err != nil {
+		return err
+	}
+	// sync, cmd, address (2 bytes), param count
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(m.r, header); err != nil {
+		return err
+	}
+	if header[0] != fourWayFCSync {
+		return fmt.Errorf("invalid 4-way response sync byte 0x%02x", header[0])
+	}
+	paramCount := int(header[4])
+	if paramCount == 0 {
+		paramCount = 256
+	}
+	// params, ack, crc (2 bytes)
+	rest := make([]byte, paramCount+3)
+	if _, err := io.ReadFull(m.r, rest); err != nil {
+		return err
+	}
+	ccrc := uint16(0)
+	for _, v := range header {
+		ccrc = crc16XModem(ccrc, v)
+	}
+	for _, v := range rest[:paramCount+1] {
+		ccrc = crc16XModem(ccrc, v)
+	}
+	crc := uint16(rest[paramCount+1])<<8 | uint16(rest[paramCount+2])
+	if crc != ccrc {
+		return fmt.Errorf("invalid 4-way response CRC 0x%04x, expecting 0x%04x", crc, ccrc)
+	}
+	if ack := rest[paramCount]; ack != fourWayAckOK {
+		return fmt.Errorf("4-way interface exit failed with ack 0x%02x", ack)
+	}
+	return nil
+}
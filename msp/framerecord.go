@@ -0,0 +1,133 @@
+package msp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Frame record files store decoded frames, for exchanging them with
+// other tools without requiring an MSP parser. Unlike captures (see
+// CaptureWriter), they contain only complete frames with a valid
+// checksum. They start with frameRecordMagic, followed by a record for
+// each frame:
+//
+//	timestamp  int64, nanoseconds since the Unix epoch
+//	direction  byte, '<' for requests, '>' for responses, '!' for errors
+//	version    uint8, framing the frame was sent with (1 or 2)
+//	code       uint16
+//	length     uint32
+//	payload    length bytes
+//
+// Integers are little endian, like in MSP.
+const frameRecordMagic = "MSPFRM1\n"
+
+const frameRecordHeaderSize = 16
+
+var errInvalidFrameRecords = errors.New("not an MSP frame record file")
+
+// FrameRecordMagicSize is the number of bytes needed by
+// IsFrameRecordFile()
+const FrameRecordMagicSize = len(frameRecordMagic)
+
+// IsFrameRecordFile returns true iff header is the beginning of a
+// frame record file
+func IsFrameRecordFile(header []byte) bool {
+	return string(header) == frameRecordMagic
+}
+
+// FrameRecord is a frame sent or received at the given time
+type FrameRecord struct {
+	Time  time.Time
+	Frame *MSPFrame
+}
+
+// FrameRecordWriter writes frame record files, see MSP.SetFrameExport().
+// It's safe for concurrent use.
+type FrameRecordWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewFrameRecordWriter writes the frame record file header to w and
+// returns a FrameRecordWriter for adding records to it.
+func NewFrameRecordWriter(w io.Writer) (*FrameRecordWriter, error) {
+	if _, err := io.WriteString(w, frameRecordMagic); err != nil {
+		return nil, err
+	}
+	return &FrameRecordWriter{w: w}, nil
+}
+
+// Write appends a record to the file. Once a write fails, all the
+// following ones return the same error.
+func (fw *FrameRecordWriter) Write(rec FrameRecord) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.err != nil {
+		return fw.err
+	}
+	var header [frameRecordHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(rec.Time.UnixNano()))
+	header[8] = rec.Frame.Direction
+	header[9] = rec.Frame.Version
+	binary.LittleEndian.PutUint16(header[10:12], rec.Frame.Code)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(rec.Frame.Payload)))
+	if _, fw.err = fw.w.Write(header[:]); fw.err == nil {
+		_, fw.err = fw.w.Write(rec.Frame.Payload)
+	}
+	return fw.err
+}
+
+func (fw *FrameRecordWriter) record(fr *MSPFrame) {
+	fw.Write(FrameRecord{Time: time.Now(), Frame: fr})
+}
+
+// FrameRecordReader reads the records in a frame record file
+type FrameRecordReader struct {
+	r *bufio.Reader
+}
+
+// NewFrameRecordReader checks the frame record file header in r and
+// returns a FrameRecordReader for reading its records.
+func NewFrameRecordReader(r io.Reader) (*FrameRecordReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(frameRecordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != frameRecordMagic {
+		return nil, errInvalidFrameRecords
+	}
+	return &FrameRecordReader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (fr *FrameRecordReader) Next() (*FrameRecord, error) {
+	var header [frameRecordHeaderSize]byte
+	if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated frame record")
+		}
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header[12:]))
+	if _, err := io.ReadFull(fr.r, payload); err != nil {
+		return nil, errors.New("truncated frame record")
+	}
+	return &FrameRecord{
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(header[:8]))),
+		Frame: &MSPFrame{
+			Code:      binary.LittleEndian.Uint16(header[10:12]),
+			Payload:   payload,
+			Direction: header[8],
+			Version:   header[9],
+		},
+	}, nil
+}
+
+// SetFrameExport records all the valid frames read from and written
+// to the port in fw.
+func (m *MSP) SetFrameExport(fw *FrameRecordWriter) {
+	m.frameExport = fw
+}
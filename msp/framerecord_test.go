@@ -0,0 +1,75 @@
+package msp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFrameRecordRoundTrip(t *testing.T) {
+	records := []FrameRecord{
+		{
+			Time:  time.Unix(1500000000, 123),
+			Frame: &MSPFrame{Code: MspAPIVersion, Direction: '<', Version: 1},
+		},
+		{
+			Time:  time.Unix(1500000001, 456),
+			Frame: &MSPFrame{Code: Msp2INAVStatus, Payload: []byte{1, 2, 3}, Direction: '>', Version: 2},
+		},
+	}
+	var buf bytes.Buffer
+	fw, err := NewFrameRecordWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err := fw.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !IsFrameRecordFile(buf.Bytes()[:FrameRecordMagicSize]) {
+		t.Errorf("IsFrameRecordFile() = false for % x", buf.Bytes()[:FrameRecordMagicSize])
+	}
+	data := buf.Bytes()
+
+	fr, err := NewFrameRecordReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range records {
+		rec, err := fr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Time.Equal(want.Time) {
+			t.Errorf("Time = %v, want %v", rec.Time, want.Time)
+		}
+		// Empty payloads are read back as empty slices
+		if len(want.Frame.Payload) == 0 {
+			rec.Frame.Payload = want.Frame.Payload
+		}
+		if !reflect.DeepEqual(rec.Frame, want.Frame) {
+			t.Errorf("Frame = %+v, want %+v", rec.Frame, want.Frame)
+		}
+	}
+	if _, err := fr.Next(); err != io.EOF {
+		t.Errorf("Next() after the last record = %v, want io.EOF", err)
+	}
+
+	truncated, err := NewFrameRecordReader(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated.Next()
+	if _, err := truncated.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next() with a truncated record = %v, want an error", err)
+	}
+}
+
+func TestFrameRecordInvalidMagic(t *testing.T) {
+	if _, err := NewFrameRecordReader(bytes.NewReader([]byte("$M>\x00\x01\x01"))); err != errInvalidFrameRecords {
+		t.Errorf("NewFrameRecordReader() = %v, want %v", err, errInvalidFrameRecords)
+	}
+}
@@ -0,0 +1,81 @@
+package msp
+
+import (
+	"fmt"
+
+	"github.com/tarm/serial"
+)
+
+// SerialFraming contains the data bits, parity and stop bits used by
+// the serial port. The zero value means 8N1.
+type SerialFraming struct {
+	DataBits byte // 5 to 8
+	Parity   byte // 'N' (none), 'E' (even) or 'O' (odd)
+	StopBits byte // 1 or 2
+}
+
+// ParseSerialFraming parses a framing in the usual notation, e.g. 8N1
+// or 7E2.
+func ParseSerialFraming(s string) (SerialFraming, error) {
+	if len(s) != 3 {
+		return SerialFraming{}, fmt.Errorf("invalid serial framing %q, must be e.g. 8N1", s)
+	}
+	fr := SerialFraming{
+		DataBits: s[0] - '0',
+		Parity:   s[1],
+		StopBits: s[2] - '0',
+	}
+	if fr.Parity >= 'a' && fr.Parity <= 'z' {
+		fr.Parity -= 'a' - 'A'
+	}
+	if err := fr.Validate(); err != nil {
+		return SerialFraming{}, err
+	}
+	return fr, nil
+}
+
+func (fr SerialFraming) withDefaults() SerialFraming {
+	if fr.DataBits == 0 {
+		fr.DataBits = 8
+	}
+	if fr.Parity == 0 {
+		fr.Parity = 'N'
+	}
+	if fr.StopBits == 0 {
+		fr.StopBits = 1
+	}
+	return fr
+}
+
+// Validate returns an error if the framing is not supported
+func (fr SerialFraming) Validate() error {
+	fr = fr.withDefaults()
+	if fr.DataBits < 5 || fr.DataBits > 8 {
+		return fmt.Errorf("invalid data bits %d, must be within [5, 8]", fr.DataBits)
+	}
+	switch fr.Parity {
+	case 'N', 'E', 'O':
+	default:
+		return fmt.Errorf("invalid parity %q, must be N, E or O", fr.Parity)
+	}
+	if fr.StopBits != 1 && fr.StopBits != 2 {
+		return fmt.Errorf("invalid stop bits %d, must be 1 or 2", fr.StopBits)
+	}
+	// MSP payloads use all 8 bits
+	if fr.DataBits < 8 {
+		return fmt.Errorf("%d data bits can't carry MSP frames, use 8", fr.DataBits)
+	}
+	return nil
+}
+
+func (fr SerialFraming) String() string {
+	fr = fr.withDefaults()
+	return fmt.Sprintf("%d%c%d", fr.DataBits, fr.Parity, fr.StopBits)
+}
+
+func (fr SerialFraming) apply(cfg *serial.Config) {
+	fr = fr.withDefaults()
+	cfg.Size = fr.DataBits
+	cfg.Parity = serial.Parity(fr.Parity)
+	cfg.StopBits = serial.StopBits(fr.StopBits)
+}
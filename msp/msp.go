@@ -1,11 +1,15 @@
 package msp
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/tarm/serial"
 )
@@ -17,37 +21,121 @@ const (
 	MspBoardInfo  = 4
 	MspBuildInfo  = 5
 
+	MspName = 10
+
+	// INAV only
+	MspNavPoshold          = 12
+	MspSetNavPoshold       = 13
+	MspRTHAndLandConfig    = 21
+	MspSetRTHAndLandConfig = 22
+
+	MspModeRanges = 34
+
 	MspFeature    = 36
 	MspSetFeature = 37
 
+	MspRXConfig    = 44
+	MspSetRXConfig = 45
+
 	MspCFSerialConfig    = 54
 	MspSetCFSerialConfig = 55
 
+	MspVoltageMeterConfig    = 56
+	MspSetVoltageMeterConfig = 57
+
 	MspRXMap = 64
 
 	MspReboot = 68
 
-	MspPID = 112
+	MspLoopTime = 73
+
+	MspOSDConfig    = 84
+	MspSetOSDConfig = 85
+
+	MspAdvancedConfig = 90
+
+	// BF only
+	MspArmingDisable = 99
+
+	MspStatus   = 101
+	MspRawIMU   = 102
+	MspMotor    = 104
+	MspRC       = 105
+	MspRawGPS   = 106
+	MspAttitude = 108
+	MspAnalog   = 110
+
+	MspPID      = 112
+	MspBoxNames = 116
+
+	MspBatteryState = 130
 
 	MspSetRawRC = 200
 
+	MspStatusEx = 150
+
+	// BF only
+	MspBeeperConfig = 184
+
+	MspUID = 160
+
 	MspSetPID = 202
 
+	MspSetMotor = 214
+
+	MspSetAccTrim = 239
+	MspAccTrim    = 240
+
+	MspSet4WayIF = 245
+
 	MspEepromWrite = 250
 
 	MspDebugMsg = 253
+
+	// MspV2Frame encapsulates an MSPv2 frame in an MSPv1 one
+	MspV2Frame = 255
+)
+
+// MSPv2 only commands
+const (
+	Msp2CommonSettingInfo = 0x1007
+
+	Msp2INAVStatus   = 0x2000
+	Msp2INAVMixer    = 0x2010
+	Msp2INAVSetMixer = 0x2011
+
+	Msp2INAVSelectBatteryProfile = 0x2018
+
+	// Sent by MSP sensors to the board, which doesn't reply
+	Msp2SensorRangefinder = 0x1F01
+	Msp2SensorOpticFlow   = 0x1F02
 )
 
 const (
+	// INAV only, other firmwares might use the bit for other
+	// features.
 	MspFCFeatureDebugTrace = 1 << 31
 )
 
+// Serial port functions shared by all the variants. Higher bits
+// depend on the variant.
 const (
-	SerialFunctionMSP        = 1 << 0
+	SerialFunctionMSP                = 1 << 0
+	SerialFunctionGPS                = 1 << 1
+	SerialFunctionTelemetryFrSky     = 1 << 2
+	SerialFunctionTelemetryHoTT      = 1 << 3
+	SerialFunctionTelemetryLTM       = 1 << 4
+	SerialFunctionTelemetrySmartPort = 1 << 5
+	SerialFunctionRXSerial           = 1 << 6
+	SerialFunctionBlackbox           = 1 << 7
+
+	// INAV only
 	SerialFunctionDebugTrace = 1 << 15
 )
 
-func mspV1Encode(cmd byte, data []byte) []byte {
+// mspV1Encode returns an MSPv1 frame. direction must be '<' for
+// requests to the board, '>' for responses from it or '!' for errors.
+func mspV1Encode(direction byte, cmd byte, data []byte) []byte {
 	var payloadLength byte
 	if len(data) > 0 {
 		payloadLength = byte(len(data))
@@ -55,7 +143,7 @@ func mspV1Encode(cmd byte, data []byte) []byte {
 	var buf bytes.Buffer
 	buf.WriteByte('$')
 	buf.WriteByte('M')
-	buf.WriteByte('<')
+	buf.WriteByte(direction)
 	buf.WriteByte(payloadLength)
 	buf.WriteByte(cmd)
 	if payloadLength > 0 {
@@ -69,23 +157,19 @@ func mspV1Encode(cmd byte, data []byte) []byte {
 	return buf.Bytes()
 }
 
-func mspV2Encode(cmd byte, totalLength int) []byte {
-	var payloadLength byte
-	if totalLength > 6 {
-		payloadLength = byte(totalLength) - 9
-	}
+// mspV2Encode returns an MSPv2 frame with the given payload: a header
+// with the direction, flags (always zero), code and payload length
+// (both little endian uint16), followed by the payload and a DVB-S2
+// CRC of everything after the direction.
+func mspV2Encode(direction byte, cmd uint16, data []byte) []byte {
 	var buf bytes.Buffer
 	buf.WriteByte('$')
 	buf.WriteByte('X')
-	buf.WriteByte('<')
-	buf.WriteByte(0)
-	buf.WriteByte(cmd)
+	buf.WriteByte(direction)
 	buf.WriteByte(0)
-	buf.WriteByte(byte(payloadLength))
-	buf.WriteByte(0)
-	for ii := byte(0); ii < payloadLength; ii++ {
-		buf.WriteByte(0)
-	}
+	binary.Write(&buf, binary.LittleEndian, cmd)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(data)))
+	buf.Write(data)
 	crc := byte(0)
 	for _, v := range buf.Bytes()[3:] {
 		crc = crc8DvbS2(crc, v)
@@ -106,15 +190,58 @@ func crc8DvbS2(crc, a byte) byte {
 	return crc
 }
 
+// DefaultReadBufferSize is the size of the buffer used for reading
+// from the port, unless changed with MSP.SetReadBufferSize()
+const DefaultReadBufferSize = 4096
+
 type MSP struct {
 	portName string
 	baudRate int
-	port     *serial.Port
+	// Either a *serial.Port or a connection, see NewWithConn()
+	port io.ReadWriteCloser
+	// Non-zero after Close(). Accessed atomically, since Close() might
+	// be called while another goroutine is reading.
+	closed int32
+	// Used for reopening the port, see SetReadTimeout()
+	config serial.Config
+	// All reads must go through r, otherwise
+	// buffered data would be lost.
+	r *bufio.Reader
+	// Set by NewFromReader() instead of port
+	stream io.Reader
+	// See SetCapture()
+	capture *CaptureWriter
+	// See SetFrameExport()
+	frameExport *FrameRecordWriter
+	// Called for every written frame, see SetWriteTrace()
+	writeTrace func(fr *MSPFrame)
+	// Recently sent frames, see IsEcho()
+	sentMu sync.Mutex
+	sent   []sentFrame
+	// Framing version of the last request by code, see RequestVersion()
+	requestVersions map[uint16]uint8
+	// Buffers reused between frames, see SetReusePayloads()
+	header        [6]byte
+	reusePayloads bool
+	payload       []byte
+	frame         MSPFrame
+	// See Request()
+	request requestState
+	// Set for connections supporting deadlines, see SetReadTimeout()
+	deadline *deadlineReader
+	// Reported by the board, see APIVersion()
+	versionMu     sync.Mutex
+	apiVersion    APIVersion
+	hasAPIVersion bool
 }
 
 type MSPFrame struct {
-	Code       uint16
-	Payload    []byte
+	Code      uint16
+	Payload   []byte
+	Direction byte // '<' for requests, '>' for responses
+	// Version is the framing the frame was sent with: 1 for $M
+	// (including MSPv2 encapsulated in MSPv1) or 2 for $X.
+	Version    uint8
 	payloadPos int
 }
 
@@ -132,6 +259,24 @@ func (f *MSPFrame) Read(out interface{}) error {
 		}
 		*x = f.Payload[f.payloadPos]
 		f.payloadPos++
+	case *int8:
+		var v uint8
+		if err := f.Read(&v); err != nil {
+			return err
+		}
+		*x = int8(v)
+	case *int16:
+		var v uint16
+		if err := f.Read(&v); err != nil {
+			return err
+		}
+		*x = int16(v)
+	case *int32:
+		var v uint32
+		if err := f.Read(&v); err != nil {
+			return err
+		}
+		*x = int32(v)
 	case *uint16:
 		if f.BytesRemaining() < 2 {
 			return io.EOF
@@ -147,16 +292,12 @@ func (f *MSPFrame) Read(out interface{}) error {
 	default:
 		v := reflect.ValueOf(out)
 		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
-			elem := v.Elem()
-			for ii := 0; ii < elem.NumField(); ii++ {
-				field := elem.Field(ii).Addr()
-				if err := f.Read(field.Interface()); err != nil {
-					return err
-				}
-			}
-			return nil
+			return f.readStruct(v.Elem())
 		}
-		if v.Kind() == reflect.Slice {
+		if v.Kind() == reflect.Ptr && (v.Elem().Kind() == reflect.Array || v.Elem().Kind() == reflect.Slice) {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
 			for ii := 0; ii < v.Len(); ii++ {
 				elem := v.Index(ii)
 				if err := f.Read(elem.Addr().Interface()); err != nil {
@@ -165,7 +306,7 @@ func (f *MSPFrame) Read(out interface{}) error {
 			}
 			return nil
 		}
-		panic(fmt.Errorf("can't decode MSP payload into type %T", out))
+		panic(fmt.Errorf("can't decode %s payload into type %T", CommandName(f.Code), out))
 	}
 	return nil
 }
@@ -174,6 +315,16 @@ func (f *MSPFrame) BytesRemaining() int {
 	return len(f.Payload) - f.payloadPos
 }
 
+// Clone returns a copy of the frame which doesn't share its payload,
+// see MSP.SetReusePayloads().
+func (f *MSPFrame) Clone() *MSPFrame {
+	c := *f
+	if f.Payload != nil {
+		c.Payload = append([]byte(nil), f.Payload...)
+	}
+	return &c
+}
+
 type MSPError interface {
 	error
 	IsMSPError() bool
@@ -182,6 +333,8 @@ type MSPError interface {
 type mspChecksumErr struct {
 	code             uint16
 	payload          []byte
+	direction        byte
+	version          uint8
 	checksum         uint8
 	expectedChecksum uint8
 }
@@ -189,34 +342,201 @@ type mspChecksumErr struct {
 func (e *mspChecksumErr) Checksum() uint8         { return e.checksum }
 func (e *mspChecksumErr) ExpectedChecksum() uint8 { return e.expectedChecksum }
 func (e *mspChecksumErr) IsMSPError() bool        { return true }
+
+// Frame returns the frame as it was received, with its invalid checksum.
+func (e *mspChecksumErr) Frame() *MSPFrame {
+	return &MSPFrame{Code: e.code, Payload: e.payload, Direction: e.direction, Version: e.version}
+}
+
 func (e *mspChecksumErr) Error() string {
 	return fmt.Sprintf("invalid CRC 0x%02x, expecting 0x%02x in cmd %v with payload %v",
 		e.checksum, e.expectedChecksum, e.code, e.payload)
 }
 
+// mspTruncatedErr is returned when the port stops returning data
+// before the declared payload length was read
+type mspTruncatedErr struct {
+	code      uint16
+	direction byte
+	expected  int
+	partial   []byte
+	err       error
+}
+
+// IsMSPError returns true when the port returned EOF or timed out in
+// the middle of the payload, rather than failing.
+func (e *mspTruncatedErr) IsMSPError() bool {
+	return e.err == io.ErrUnexpectedEOF || e.err == ErrReadTimeout
+}
+
+// Partial returns the part of the payload that was received
+func (e *mspTruncatedErr) Partial() []byte { return e.partial }
+
+func (e *mspTruncatedErr) Error() string {
+	return fmt.Sprintf("truncated payload in %s (%c): expected %d bytes, received %d (%v)",
+		CommandName(e.code), e.direction, e.expected, len(e.partial), e.err)
+}
+
+// readPayload reads a payload of the given size, returning
+// *mspTruncatedErr if it can't be fully read.
+func (m *MSP) readPayload(code uint16, direction byte, size int) ([]byte, error) {
+	payload := m.payloadBuffer(size)
+	n, err := io.ReadFull(m.r, payload)
+	if err != nil {
+		return nil, &mspTruncatedErr{
+			code:      code,
+			direction: direction,
+			expected:  size,
+			partial:   append([]byte(nil), payload[:n]...),
+			err:       err,
+		}
+	}
+	return payload, nil
+}
+
+// mspCommandErr is returned when the board answers with the '!'
+// direction, because it doesn't support the command or rejected it
+type mspCommandErr struct {
+	code uint16
+}
+
+func (e *mspCommandErr) IsMSPError() bool { return true }
+
+// RejectedCode returns the code of the command rejected by the board
+func (e *mspCommandErr) RejectedCode() uint16 { return e.code }
+
+func (e *mspCommandErr) Error() string {
+	return fmt.Sprintf("command %d (%s) rejected by board", e.code, CommandName(e.code))
+}
+
 type mspOOBErr struct {
 	b byte
 }
 
 func (e *mspOOBErr) IsMSPError() bool { return true }
+func (e *mspOOBErr) OOBByte() byte    { return e.b }
 func (e *mspOOBErr) Error() string {
 	return fmt.Sprintf("out of band MSP byte 0x%02x", e.b)
 }
 
 func New(portName string, baudRate int) (*MSP, error) {
+	return NewWithFraming(portName, baudRate, SerialFraming{})
+}
+
+// NewWithFraming is like New, but allows using a serial framing
+// other than 8N1.
+func NewWithFraming(portName string, baudRate int, framing SerialFraming) (*MSP, error) {
+	if err := framing.Validate(); err != nil {
+		return nil, err
+	}
 	opts := &serial.Config{
 		Name: portName,
 		Baud: baudRate,
 	}
+	framing.apply(opts)
 	port, err := serial.OpenPort(opts)
 	if err != nil {
 		return nil, err
 	}
-	return &MSP{
+	m := &MSP{
 		portName: portName,
 		baudRate: baudRate,
 		port:     port,
-	}, nil
+		config:   *opts,
+	}
+	m.r = bufio.NewReaderSize(m.captureReader(port), DefaultReadBufferSize)
+	return m, nil
+}
+
+// NewWithConn returns an MSP which talks to the board over conn
+// instead of a serial port, e.g. a TCP connection to a simulator or
+// a WiFi bridge. If conn is a net.Conn, SetReadTimeout() is supported.
+func NewWithConn(conn io.ReadWriteCloser) *MSP {
+	m := &MSP{port: conn}
+	var r io.Reader = conn
+	if c, ok := conn.(net.Conn); ok {
+		m.portName = c.RemoteAddr().String()
+		m.deadline = &deadlineReader{conn: c}
+		r = m.deadline
+	}
+	m.r = bufio.NewReaderSize(m.captureReader(r), DefaultReadBufferSize)
+	return m
+}
+
+// SetReadBufferSize changes the size of the buffer used for reading
+// from the port. Any data already buffered is discarded, so it should
+// be called before reading any frames.
+func (m *MSP) SetReadBufferSize(size int) {
+	var r io.Reader = m.port
+	if m.deadline != nil {
+		r = m.deadline
+	}
+	m.r = bufio.NewReaderSize(m.captureReader(r), size)
+}
+
+// SetReusePayloads controls whether frames returned by ReadFrame() are
+// allocated for each frame (the default) or reused. When reusing them,
+// the returned *MSPFrame and its Payload, including the ones returned
+// by checksum errors, are only valid until the next call to ReadFrame().
+// Callers that need to retain a frame past that point must use
+// MSPFrame.Clone(). This avoids allocating memory for every frame,
+// which matters at high frame rates (e.g. with DEBUG_TRACE).
+func (m *MSP) SetReusePayloads(reuse bool) {
+	m.reusePayloads = reuse
+	m.payload = nil
+}
+
+// SetWriteTrace sets a function called with every frame written to the
+// port. The frame must not be retained after fn returns. It must be
+// called before writing any frames.
+func (m *MSP) SetWriteTrace(fn func(fr *MSPFrame)) {
+	m.writeTrace = fn
+}
+
+// payloadBuffer returns a buffer for reading a payload of the given size
+func (m *MSP) payloadBuffer(size int) []byte {
+	if !m.reusePayloads {
+		return make([]byte, size)
+	}
+	if cap(m.payload) < size {
+		m.payload = make([]byte, size)
+	}
+	return m.payload[:size]
+}
+
+// newFrame returns the frame to be returned by ReadFrame()
+func (m *MSP) newFrame(code uint16, payload []byte, direction byte, version uint8) *MSPFrame {
+	if m.reusePayloads {
+		m.frame = MSPFrame{
+			Code:      code,
+			Payload:   payload,
+			Direction: direction,
+			Version:   version,
+		}
+		return &m.frame
+	}
+	return &MSPFrame{
+		Code:      code,
+		Payload:   payload,
+		Direction: direction,
+		Version:   version,
+	}
+}
+
+// Write writes raw bytes to the port. Use it to talk to the board
+// when it's not speaking MSP.
+func (m *MSP) Write(p []byte) (int, error) {
+	return m.writePort(p)
+}
+
+// Read reads raw bytes from the port, including any data already
+// buffered while reading frames. Use it to talk to the board when it's
+// not speaking MSP.
+func (m *MSP) Read(p []byte) (int, error) {
+	if m.isClosed() {
+		return 0, io.EOF
+	}
+	return m.r.Read(p)
 }
 
 func (m *MSP) encodeArgs(w *bytes.Buffer, args ...interface{}) error {
@@ -228,23 +548,27 @@ func (m *MSP) encodeArgs(w *bytes.Buffer, args ...interface{}) error {
 			binary.Write(w, binary.LittleEndian, x)
 		case uint32:
 			binary.Write(w, binary.LittleEndian, x)
+		case int8:
+			w.WriteByte(byte(x))
+		case int16:
+			binary.Write(w, binary.LittleEndian, x)
+		case int32:
+			binary.Write(w, binary.LittleEndian, x)
 		default:
 			v := reflect.ValueOf(arg)
-			if v.Kind() == reflect.Slice {
+			if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
 				for ii := 0; ii < v.Len(); ii++ {
 					if err := m.encodeArgs(w, v.Index(ii).Interface()); err != nil {
 						return err
 					}
 				}
-				return nil
+				continue
 			}
 			if v.Kind() == reflect.Struct {
-				for ii := 0; ii < v.NumField(); ii++ {
-					if err := m.encodeArgs(w, v.Field(ii).Interface()); err != nil {
-						return err
-					}
+				if err := m.encodeStruct(w, v); err != nil {
+					return err
 				}
-				return nil
+				continue
 			}
 			panic(fmt.Errorf("can't encode MSP value of type %T", arg))
 		}
@@ -252,24 +576,78 @@ func (m *MSP) encodeArgs(w *bytes.Buffer, args ...interface{}) error {
 	return nil
 }
 
+// WriteCmd sends cmd with the given arguments, choosing the framing
+// according to the command and the API version reported by the board.
+// Commands above 255 and the ones only available via MSPv2 are sent
+// with MSPv2 framing.
+// If the board is too old for the command, *UnsupportedCommandError is
+// returned.
 func (m *MSP) WriteCmd(cmd uint16, args ...interface{}) (int, error) {
+	return m.writeFrame('<', cmd, args...)
+}
+
+// WriteResponse sends a response to cmd, as a board would. It's
+// useful for emulating a board, e.g. for testing configurators.
+func (m *MSP) WriteResponse(cmd uint16, args ...interface{}) (int, error) {
+	return m.writeFrame('>', cmd, args...)
+}
+
+// WriteCmdV2 is like WriteCmd, but always uses MSPv2 framing, which
+// allows command codes above 255.
+func (m *MSP) WriteCmdV2(cmd uint16, args ...interface{}) (int, error) {
+	if _, err := m.useV2Framing(cmd); err != nil {
+		return -1, err
+	}
+	if version, ok := m.APIVersion(); ok && !version.AtLeast(v2FramingAPIVersion) {
+		return -1, &UnsupportedCommandError{Code: cmd, Required: v2FramingAPIVersion, APIVersion: version}
+	}
+	return m.encodeFrame('<', cmd, true, args...)
+}
+
+func (m *MSP) writeFrame(direction byte, cmd uint16, args ...interface{}) (int, error) {
+	v2, err := m.useV2Framing(cmd)
+	if err != nil {
+		return -1, err
+	}
+	return m.encodeFrame(direction, cmd, v2, args...)
+}
+
+func (m *MSP) encodeFrame(direction byte, cmd uint16, v2 bool, args ...interface{}) (int, error) {
 	var buf bytes.Buffer
 	if err := m.encodeArgs(&buf, args...); err != nil {
 		return -1, err
 	}
 	data := buf.Bytes()
-	frame := mspV1Encode(byte(cmd), data)
-	return m.port.Write(frame)
+	var frame []byte
+	version := uint8(1)
+	if v2 {
+		frame = mspV2Encode(direction, cmd, data)
+		version = 2
+	} else {
+		frame = mspV1Encode(direction, byte(cmd), data)
+	}
+	m.trackSent(cmd, data)
+	if direction == '<' {
+		m.trackRequestVersion(cmd, version)
+	}
+	if m.writeTrace != nil {
+		m.writeTrace(&MSPFrame{Code: cmd, Payload: data, Direction: direction, Version: version})
+	}
+	if m.frameExport != nil {
+		m.frameExport.record(&MSPFrame{Code: cmd, Payload: data, Direction: direction, Version: version})
+	}
+	return m.writePort(frame)
 }
 
 func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
-	buf := make([]byte, 3)
-	if _, err := m.port.Read(buf); err != nil {
+	buf := m.header[:3]
+	if _, err := io.ReadFull(m.r, buf); err != nil {
 		return nil, err
 	}
-	if buf[0] != '<' && buf[0] != '>' {
+	if buf[0] != '<' && buf[0] != '>' && buf[0] != '!' {
 		return nil, fmt.Errorf("invalid MSP direction char 0x%02x", buf[0])
 	}
+	direction := buf[0]
 	ccrc := byte(0)
 	ccrc ^= buf[1]
 	ccrc ^= buf[2]
@@ -277,111 +655,165 @@ func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
 	payloadLength := int(buf[1])
 	cmd := buf[2]
 	if payloadLength > 0 {
-		payload = make([]byte, payloadLength)
-		if _, err := io.ReadFull(m.port, payload); err != nil {
+		var err error
+		if payload, err = m.readPayload(uint16(cmd), direction, payloadLength); err != nil {
 			return nil, err
 		}
 		for _, b := range payload {
 			ccrc ^= b
 		}
 	}
-	buf = buf[:1]
-	if _, err := m.port.Read(buf); err != nil {
+	crc, err := m.r.ReadByte()
+	if err != nil {
 		return nil, err
 	}
-	crc := buf[0]
 	if crc != ccrc {
 		return nil, &mspChecksumErr{
 			code:             uint16(cmd),
 			payload:          payload,
+			direction:        direction,
+			version:          1,
 			checksum:         crc,
 			expectedChecksum: ccrc,
 		}
 	}
-	return &MSPFrame{
-		Code:       uint16(cmd),
-		Payload:    payload,
-		payloadPos: 0,
-	}, nil
+	if cmd == MspV2Frame {
+		return m.decodeV2OverV1(direction, payload)
+	}
+	return m.newFrame(uint16(cmd), payload, direction, 1), nil
+}
+
+// decodeV2OverV1 decodes an MSPv2 frame encapsulated in the payload of
+// an MSPv1 frame with the MSP_V2_FRAME command. The encapsulated frame
+// has the same layout as an MSPv2 frame after the direction byte.
+func (m *MSP) decodeV2OverV1(direction byte, payload []byte) (*MSPFrame, error) {
+	// flags(1) + code(2) + payload length(2) + crc(1)
+	if len(payload) < 6 {
+		return nil, fmt.Errorf("MSPv2 over MSPv1 frame too short (%d bytes)", len(payload))
+	}
+	code := uint16(payload[1]) | uint16(payload[2])<<8
+	payloadLength := int(uint16(payload[3]) | uint16(payload[4])<<8)
+	if len(payload) != 5+payloadLength+1 {
+		return nil, fmt.Errorf("invalid MSPv2 over MSPv1 payload length %d in %d bytes", payloadLength, len(payload))
+	}
+	ccrc := byte(0)
+	for _, b := range payload[:5+payloadLength] {
+		ccrc = crc8DvbS2(ccrc, b)
+	}
+	var inner []byte
+	if payloadLength > 0 {
+		inner = payload[5 : 5+payloadLength]
+	}
+	if crc := payload[5+payloadLength]; crc != ccrc {
+		return nil, &mspChecksumErr{
+			code:             code,
+			payload:          inner,
+			direction:        direction,
+			version:          1,
+			checksum:         crc,
+			expectedChecksum: ccrc,
+		}
+	}
+	return m.newFrame(code, inner, direction, 1), nil
 }
 
 func (m *MSP) readMSPV2Frame() (*MSPFrame, error) {
-	buf := make([]byte, 6)
-	if _, err := m.port.Read(buf); err != nil {
+	buf := m.header[:6]
+	if _, err := io.ReadFull(m.r, buf); err != nil {
 		return nil, err
 	}
-	if buf[0] != '<' && buf[0] != '>' {
+	if buf[0] != '<' && buf[0] != '>' && buf[0] != '!' {
 		return nil, fmt.Errorf("invalid MSP direction char 0x%02x", buf[0])
 	}
+	direction := buf[0]
 	// flags := buf[1]
+	ccrc := byte(0)
+	for _, b := range buf[1:] {
+		ccrc = crc8DvbS2(ccrc, b)
+	}
 	code := uint16(buf[2]) | uint16(buf[3])<<8
 	payloadLength := int(uint16(buf[4]) | uint16(buf[5])<<8)
 	var payload []byte
 	if payloadLength > 0 {
-		payload = make([]byte, payloadLength)
-		if _, err := io.ReadFull(m.port, payload); err != nil {
+		var err error
+		if payload, err = m.readPayload(code, direction, payloadLength); err != nil {
 			return nil, err
 		}
+		for _, b := range payload {
+			ccrc = crc8DvbS2(ccrc, b)
+		}
 	}
 
-	buf = make([]byte, 1)
-	if _, err := m.port.Read(buf); err != nil {
+	crc, err := m.r.ReadByte()
+	if err != nil {
 		return nil, err
 	}
-	// crc := buf[0]
-	return &MSPFrame{
-		Code:       code,
-		Payload:    payload,
-		payloadPos: 0,
-	}, nil
+	if crc != ccrc {
+		return nil, &mspChecksumErr{
+			code:             code,
+			payload:          payload,
+			direction:        direction,
+			version:          2,
+			checksum:         crc,
+			expectedChecksum: ccrc,
+		}
+	}
+	return m.newFrame(code, payload, direction, 2), nil
 }
 
 func (m *MSP) ReadFrame() (*MSPFrame, error) {
-	port := m.port
-	if port == nil {
+	if m.isClosed() {
 		return nil, io.EOF
 	}
-	buf := make([]byte, 1)
-	for {
-		_, err := port.Read(buf)
-		if err != nil {
-			return nil, err
-		}
-		if buf[0] == '$' {
-			// Frame start
-			break
-		}
-		return nil, &mspOOBErr{b: buf[0]}
+	b, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if b != '$' {
+		return nil, &mspOOBErr{b: b}
 	}
-	_, err := port.Read(buf)
+	b, err = m.r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	switch buf[0] {
+	var fr *MSPFrame
+	switch b {
 	case 'M':
-		return m.readMSPV1Frame()
+		fr, err = m.readMSPV1Frame()
 	case 'X':
-		return m.readMSPV2Frame()
+		fr, err = m.readMSPV2Frame()
 	default:
-		return nil, fmt.Errorf("unknown MSP char %c", buf[0])
+		return nil, fmt.Errorf("unknown MSP char %c", b)
 	}
+	if err == nil {
+		if m.frameExport != nil {
+			m.frameExport.record(fr)
+		}
+		if fr.Direction == '!' {
+			return nil, &mspCommandErr{code: fr.Code}
+		}
+		m.updateAPIVersion(fr)
+	}
+	return fr, err
 }
 
 // RebootIntoBootloader reboots the board into bootloader mode
 func (m *MSP) RebootIntoBootloader() (int, error) {
 	// reboot_character is 'R' by default, but it can be changed
 	// TODO: Retrieve it if possible (in inav it can be done via MSPv2)
-	return m.port.Write([]byte{'R'})
+	return m.writePort([]byte{'R'})
 }
 
-// Close closes the underlying serial port. Note that reading from or
-// writing to a closed MSP will cause a panic.
+// Close closes the underlying serial port or connection. It might be
+// called while another goroutine is reading, which will return an
+// error. Reading from a closed MSP returns io.EOF.
 func (m *MSP) Close() error {
-	var err error
-	if m.port != nil {
-		if err = m.port.Close(); err == nil {
-			m.port = nil
-		}
+	if m.port == nil || !atomic.CompareAndSwapInt32(&m.closed, 0, 1) {
+		return nil
 	}
-	return err
+	return m.port.Close()
+}
+
+func (m *MSP) isClosed() bool {
+	return atomic.LoadInt32(&m.closed) != 0 || (m.port == nil && m.stream == nil)
 }
@@ -0,0 +1,245 @@
+package msp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMSPV2RoundTrip(t *testing.T) {
+	payload := []byte{0x01, 0x02, 0x03, 0xfe, 0xff}
+	data := mspV2Encode('>', 0x2010, payload)
+
+	var crc byte
+	for _, v := range data[3 : len(data)-1] {
+		crc = crc8DvbS2(crc, v)
+	}
+	if got := data[len(data)-1]; got != crc {
+		t.Fatalf("CRC = 0x%02x, want 0x%02x", got, crc)
+	}
+
+	m := NewFromReader(bytes.NewReader(data))
+	fr, err := m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != 0x2010 {
+		t.Errorf("Code = 0x%04x, want 0x2010", fr.Code)
+	}
+	if fr.Version != 2 {
+		t.Errorf("Version = %d, want 2", fr.Version)
+	}
+	if !bytes.Equal(fr.Payload, payload) {
+		t.Errorf("Payload = %v, want %v", fr.Payload, payload)
+	}
+}
+
+func TestMSPV2BadCRC(t *testing.T) {
+	data := mspV2Encode('>', 0x2010, []byte{1, 2, 3})
+	data[len(data)-1] ^= 0xff
+
+	m := NewFromReader(bytes.NewReader(data))
+	_, err := m.ReadFrame()
+	if _, ok := err.(*mspChecksumErr); !ok {
+		t.Fatalf("ReadFrame() = %v, want a checksum error", err)
+	}
+}
+
+// bufferConn records the data written to it, for checking the wire
+// bytes of written frames
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (c *bufferConn) Close() error { return nil }
+
+func TestWriteCmdV2WireBytes(t *testing.T) {
+	want := []byte{'$', 'X', '<', 0x00, 0x03, 0x10, 0x02, 0x00, 0x34, 0x12, 0x10}
+
+	var conn bufferConn
+	m := NewWithConn(&conn)
+	if _, err := m.WriteCmdV2(0x1003, uint16(0x1234)); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("WriteCmdV2(0x1003) wrote % x, want % x", got, want)
+	}
+
+	empty := []byte{'$', 'X', '<', 0x00, 0x03, 0x10, 0x00, 0x00, 0xd6}
+	if got := mspV2Encode('<', 0x1003, nil); !bytes.Equal(got, empty) {
+		t.Errorf("mspV2Encode(0x1003) = % x, want % x", got, empty)
+	}
+}
+
+// loopReader returns data over and over, without allocating
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	// A DEBUG_TRACE sized frame, which is what's usually received at
+	// high rates
+	data := mspV1Encode('>', MspDebugMsg, bytes.Repeat([]byte{0x55}, 64))
+	for _, reuse := range []bool{false, true} {
+		name := "alloc"
+		if reuse {
+			name = "reuse"
+		}
+		b.Run(name, func(b *testing.B) {
+			m := NewFromReader(&loopReader{data: data})
+			m.SetReusePayloads(reuse)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for ii := 0; ii < b.N; ii++ {
+				if _, err := m.ReadFrame(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReusePayloads(t *testing.T) {
+	var data []byte
+	data = append(data, mspV1Encode('>', MspDebugMsg, []byte("first"))...)
+	data = append(data, mspV1Encode('>', MspDebugMsg, []byte("other"))...)
+	for _, reuse := range []bool{false, true} {
+		m := NewFromReader(bytes.NewReader(data))
+		m.SetReusePayloads(reuse)
+		first, err := m.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clone := first.Clone()
+		if _, err := m.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(clone.Payload); got != "first" {
+			t.Errorf("reuse = %v: cloned payload = %q, want \"first\"", reuse, got)
+		}
+		want := "first"
+		if reuse {
+			want = "other"
+		}
+		if got := string(first.Payload); got != want {
+			t.Errorf("reuse = %v: first payload = %q, want %q", reuse, got, want)
+		}
+	}
+}
+
+func TestMSPV2OverV1(t *testing.T) {
+	payload := []byte{0x10, 0x20, 0x30}
+	// The encapsulated frame has the MSPv2 layout after the direction
+	inner := mspV2Encode('>', Msp2INAVMixer, payload)[3:]
+	truncated := inner[:len(inner)-2]
+
+	var data []byte
+	data = append(data, mspV1Encode('>', MspV2Frame, inner)...)
+	data = append(data, mspV1Encode('>', MspV2Frame, truncated)...)
+	data = append(data, mspV1Encode('>', MspAPIVersion, []byte{0, 2, 4})...)
+
+	m := NewFromReader(bytes.NewReader(data))
+	fr, err := m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != Msp2INAVMixer {
+		t.Errorf("Code = 0x%04x, want 0x%04x", fr.Code, Msp2INAVMixer)
+	}
+	if fr.Version != 1 {
+		t.Errorf("Version = %d, want 1", fr.Version)
+	}
+	if !bytes.Equal(fr.Payload, payload) {
+		t.Errorf("Payload = %v, want %v", fr.Payload, payload)
+	}
+
+	if fr, err := m.ReadFrame(); err == nil {
+		t.Errorf("ReadFrame() with a truncated inner frame = %+v, want an error", fr)
+	}
+
+	// The whole outer frame was consumed, so the next one must be read
+	fr, err = m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspAPIVersion {
+		t.Errorf("Code after truncated frame = %d, want %d", fr.Code, MspAPIVersion)
+	}
+}
+
+func TestEncodeDirection(t *testing.T) {
+	payload := []byte{0xaa, 0x55, 0x01}
+	for _, direction := range []byte{'<', '>'} {
+		v1 := mspV1Encode(direction, MspStatus, payload)
+		var checksum byte
+		for _, v := range v1[3 : len(v1)-1] {
+			checksum ^= v
+		}
+		v2 := mspV2Encode(direction, Msp2INAVStatus, payload)
+		var crc byte
+		for _, v := range v2[3 : len(v2)-1] {
+			crc = crc8DvbS2(crc, v)
+		}
+		frames := []struct {
+			data    []byte
+			code    uint16
+			version uint8
+			check   byte
+		}{
+			{v1, MspStatus, 1, checksum},
+			{v2, Msp2INAVStatus, 2, crc},
+		}
+		for _, f := range frames {
+			if f.data[2] != direction {
+				t.Errorf("v%d: direction byte = %c, want %c", f.version, f.data[2], direction)
+			}
+			if got := f.data[len(f.data)-1]; got != f.check {
+				t.Errorf("v%d %c: checksum = 0x%02x, want 0x%02x", f.version, direction, got, f.check)
+			}
+			fr, err := NewFromReader(bytes.NewReader(f.data)).ReadFrame()
+			if err != nil {
+				t.Errorf("v%d %c: %v", f.version, direction, err)
+				continue
+			}
+			if fr.Direction != direction || fr.Code != f.code || fr.Version != f.version || !bytes.Equal(fr.Payload, payload) {
+				t.Errorf("v%d %c: decoded %+v", f.version, direction, fr)
+			}
+		}
+	}
+}
+
+func TestRejectedCommand(t *testing.T) {
+	var data []byte
+	data = append(data, mspV1Encode('!', MspOSDConfig, nil)...)
+	data = append(data, mspV2Encode('!', Msp2INAVMixer, nil)...)
+	data = append(data, mspV1Encode('>', MspAPIVersion, []byte{0, 2, 4})...)
+
+	m := NewFromReader(bytes.NewReader(data))
+	for _, code := range []uint16{MspOSDConfig, Msp2INAVMixer} {
+		_, err := m.ReadFrame()
+		cerr, ok := err.(*mspCommandErr)
+		if !ok {
+			t.Fatalf("ReadFrame() = %v, want a rejected command error", err)
+		}
+		if cerr.RejectedCode() != code {
+			t.Errorf("RejectedCode() = %d, want %d", cerr.RejectedCode(), code)
+		}
+	}
+	fr, err := m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspAPIVersion {
+		t.Errorf("Code after rejected commands = %d, want %d", fr.Code, MspAPIVersion)
+	}
+}
@@ -8,3 +8,102 @@ type MSPSerialConfig struct {
 	TelemetryBaudRateIndex  uint8
 	PeripheralBaudRateIndex uint8 // Actually blackboxBaudRateIndex in BF
 }
+
+type MSPVoltageMeterConfig struct {
+	ID               uint8
+	SensorType       uint8
+	Scale            uint8
+	ResDivVal        uint8
+	ResDivMultiplier uint8
+}
+
+// MSPRXConfig is the payload of MSP_RX_CONFIG. Older firmware
+// versions send only a prefix of it, see fc.FC.GetRXConfig().
+type MSPRXConfig struct {
+	SerialRXProvider         uint8
+	MaxCheck                 uint16
+	MidRC                    uint16
+	MinCheck                 uint16
+	SpektrumSatBind          uint8
+	RXMinUsec                uint16
+	RXMaxUsec                uint16
+	RCInterpolation          uint8 // Always zero in INAV
+	RCInterpolationInterval  uint8 // Always zero in INAV
+	AirModeActivateThreshold uint16
+	RXSPIProtocol            uint8
+	RXSPIID                  uint32
+	RXSPIRFChannelCount      uint8
+}
+
+// MSPNavPoshold is the payload of MSP_NAV_POSHOLD (INAV only). Speeds
+// are in cm/s.
+type MSPNavPoshold struct {
+	UserControlMode    uint8 // 0 = attitude, 1 = cruise
+	MaxAutoSpeed       uint16
+	MaxAutoClimbRate   uint16
+	MaxManualSpeed     uint16
+	MaxManualClimbRate uint16
+	MCMaxBankAngle     uint8 // Degrees
+	MCAltHoldThrottle  uint8 // 0 = stick, 1 = mid stick, 2 = hover
+	MCHoverThrottle    uint16
+}
+
+// MSPRTHAndLandConfig is the payload of MSP_RTH_AND_LAND_CONFIG (INAV
+// only). Distances and altitudes are in cm, rates in cm/s.
+type MSPRTHAndLandConfig struct {
+	MinRTHDistance       uint16
+	RTHClimbFirst        uint8
+	RTHClimbIgnoreEmerg  uint8
+	RTHTailFirst         uint8
+	RTHAllowLanding      uint8
+	RTHAltControlMode    uint8
+	RTHAbortThreshold    uint16
+	RTHAltitude          uint16
+	LandMinAltVSpeed     uint16
+	LandMaxAltVSpeed     uint16
+	LandSlowdownMinAlt   uint16
+	LandSlowdownMaxAlt   uint16
+	EmergencyDescentRate uint16
+}
+
+// MSP2SensorRangefinder is the payload of MSP2_SENSOR_RANGEFINDER, see
+// mspSensorRangefinderDataMessage_t in INAV
+type MSP2SensorRangefinder struct {
+	Quality    uint8 // 0-255
+	DistanceMM int32 // Negative when out of range
+}
+
+// MSP2SensorOpticFlow is the payload of MSP2_SENSOR_OPTIC_FLOW, see
+// mspSensorOpflowDataMessage_t in INAV
+type MSP2SensorOpticFlow struct {
+	Quality uint8 // 0-255
+	MotionX int32
+	MotionY int32
+}
+
+// MSPRawIMU is the payload of MSP_RAW_IMU. Units depend on the
+// sensors and the firmware, but they're comparable between samples.
+type MSPRawIMU struct {
+	Acc  [3]int16
+	Gyro [3]int16
+	Mag  [3]int16
+}
+
+// MSPAttitude is the payload of MSP_ATTITUDE
+type MSPAttitude struct {
+	Roll  int16 // Decidegrees
+	Pitch int16 // Decidegrees
+	Yaw   int16 // Degrees, as a heading
+}
+
+// MSPAdvancedConfig is the beginning of the MSP_ADVANCED_CONFIG
+// payload, which is the same in BF and INAV. Newer versions append
+// more fields.
+type MSPAdvancedConfig struct {
+	GyroSyncDenom    uint8
+	PIDProcessDenom  uint8
+	UseUnsyncedPWM   uint8
+	MotorPWMProtocol uint8
+	MotorPWMRate     uint16 // Hz, only used by analog protocols
+	MotorIdle        uint16 // Digital idle in percent * 100 for BF
+}
@@ -0,0 +1,107 @@
+package msp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var commandNamesMu sync.RWMutex
+
+var commandNames = map[uint16]string{
+	MspAPIVersion:            "MSP_API_VERSION",
+	MspFCVariant:             "MSP_FC_VARIANT",
+	MspFCVersion:             "MSP_FC_VERSION",
+	MspBoardInfo:             "MSP_BOARD_INFO",
+	MspBuildInfo:             "MSP_BUILD_INFO",
+	MspName:                  "MSP_NAME",
+	MspNavPoshold:            "MSP_NAV_POSHOLD",
+	MspSetNavPoshold:         "MSP_SET_NAV_POSHOLD",
+	MspRTHAndLandConfig:      "MSP_RTH_AND_LAND_CONFIG",
+	MspSetRTHAndLandConfig:   "MSP_SET_RTH_AND_LAND_CONFIG",
+	MspFeature:               "MSP_FEATURE",
+	MspSetFeature:            "MSP_SET_FEATURE",
+	MspRXConfig:              "MSP_RX_CONFIG",
+	MspSetRXConfig:           "MSP_SET_RX_CONFIG",
+	MspCFSerialConfig:        "MSP_CF_SERIAL_CONFIG",
+	MspSetCFSerialConfig:     "MSP_SET_CF_SERIAL_CONFIG",
+	MspVoltageMeterConfig:    "MSP_VOLTAGE_METER_CONFIG",
+	MspSetVoltageMeterConfig: "MSP_SET_VOLTAGE_METER_CONFIG",
+	MspRXMap:                 "MSP_RX_MAP",
+	MspReboot:                "MSP_REBOOT",
+	MspLoopTime:              "MSP_LOOP_TIME",
+	MspOSDConfig:             "MSP_OSD_CONFIG",
+	MspSetOSDConfig:          "MSP_SET_OSD_CONFIG",
+	MspAdvancedConfig:        "MSP_ADVANCED_CONFIG",
+	MspArmingDisable:         "MSP_ARMING_DISABLE",
+	MspModeRanges:            "MSP_MODE_RANGES",
+	MspBeeperConfig:          "MSP_BEEPER_CONFIG",
+	MspStatus:                "MSP_STATUS",
+	MspRawIMU:                "MSP_RAW_IMU",
+	MspMotor:                 "MSP_MOTOR",
+	MspRC:                    "MSP_RC",
+	MspRawGPS:                "MSP_RAW_GPS",
+	MspAttitude:              "MSP_ATTITUDE",
+	MspAnalog:                "MSP_ANALOG",
+	MspPID:                   "MSP_PID",
+	MspBoxNames:              "MSP_BOXNAMES",
+	MspBatteryState:          "MSP_BATTERY_STATE",
+	MspStatusEx:              "MSP_STATUS_EX",
+	MspUID:                   "MSP_UID",
+	MspSetRawRC:              "MSP_SET_RAW_RC",
+	MspSetPID:                "MSP_SET_PID",
+	MspSetMotor:              "MSP_SET_MOTOR",
+	MspSetAccTrim:            "MSP_SET_ACC_TRIM",
+	MspAccTrim:               "MSP_ACC_TRIM",
+	MspSet4WayIF:             "MSP_SET_4WAY_IF",
+	MspEepromWrite:           "MSP_EEPROM_WRITE",
+	MspDebugMsg:              "MSP_DEBUGMSG",
+	MspV2Frame:               "MSP_V2_FRAME",
+
+	Msp2CommonSettingInfo: "MSP2_COMMON_SETTING_INFO",
+
+	Msp2INAVStatus:   "MSP2_INAV_STATUS",
+	Msp2INAVMixer:    "MSP2_INAV_MIXER",
+	Msp2INAVSetMixer: "MSP2_INAV_SET_MIXER",
+
+	Msp2INAVSelectBatteryProfile: "MSP2_INAV_SELECT_BATTERY_PROFILE",
+
+	Msp2SensorRangefinder: "MSP2_SENSOR_RANGEFINDER",
+	Msp2SensorOpticFlow:   "MSP2_SENSOR_OPTIC_FLOW",
+}
+
+// CommandName returns the name of the given MSP command code, as used
+// in the firmware sources. Unknown codes return UNKNOWN(code).
+func CommandName(code uint16) string {
+	commandNamesMu.RLock()
+	name, ok := commandNames[code]
+	commandNamesMu.RUnlock()
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", code)
+}
+
+// RegisterCommandName adds or replaces the name returned by
+// CommandName for the given code, e.g. for commands which are not
+// defined in this package.
+func RegisterCommandName(code uint16, name string) {
+	commandNamesMu.Lock()
+	commandNames[code] = name
+	commandNamesMu.Unlock()
+}
+
+// CommandCode returns the code of the command with the given name, as
+// returned by CommandName. The name is case insensitive and the MSP_
+// prefix might be omitted.
+func CommandCode(name string) (uint16, bool) {
+	name = strings.ToUpper(name)
+	commandNamesMu.RLock()
+	defer commandNamesMu.RUnlock()
+	for code, n := range commandNames {
+		if n == name || n == "MSP_"+name {
+			return code, true
+		}
+	}
+	return 0, false
+}
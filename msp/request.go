@@ -0,0 +1,67 @@
+package msp
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultRequestTimeout is the maximum time Request() waits for the
+// response, unless changed with SetRequestTimeout().
+const DefaultRequestTimeout = time.Second
+
+type requestState struct {
+	timeout time.Duration
+}
+
+// SetRequestTimeout sets the maximum time Request() waits for the
+// response. Values <= 0 restore DefaultRequestTimeout.
+func (m *MSP) SetRequestTimeout(timeout time.Duration) {
+	m.request.timeout = timeout
+}
+
+// Request sends the given command and reads frames until the response
+// to it arrives, which is returned. If the board rejects the command,
+// an MSPError is returned. Out of band bytes, frames with
+// checksum errors and frames with other codes are skipped. Errors
+// reading from the port are returned.
+//
+// Since Request() reads from the port, it must not be used while
+// something else is calling ReadFrame() (e.g. fc.FC.StartUpdating).
+// Connections stop reading when the timeout expires, while serial
+// ports need a read timeout (see SetReadTimeout()) to notice it.
+func (m *MSP) Request(cmd uint16, args ...interface{}) (*MSPFrame, error) {
+	timeout := m.request.timeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	if _, err := m.WriteCmd(cmd, args...); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	if m.deadline != nil {
+		m.deadline.limit = deadline
+		defer func() { m.deadline.limit = time.Time{} }()
+	}
+	for time.Now().Before(deadline) {
+		fr, err := m.ReadFrame()
+		if err != nil {
+			if err == ErrReadTimeout {
+				continue
+			}
+			if cerr, ok := err.(*mspCommandErr); ok && cerr.code == cmd {
+				return nil, err
+			}
+			if merr, ok := err.(MSPError); ok && merr.IsMSPError() {
+				continue
+			}
+			return nil, err
+		}
+		if fr.Code == cmd && fr.Direction == '>' {
+			if m.reusePayloads {
+				fr = fr.Clone()
+			}
+			return fr, nil
+		}
+	}
+	return nil, fmt.Errorf("timed out waiting for %s response after %v", CommandName(cmd), timeout)
+}
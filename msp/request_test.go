@@ -0,0 +1,103 @@
+package msp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// requestBoard returns an MSP talking to a board which calls respond
+// with each request it receives, until the connection is closed.
+func requestBoard(t *testing.T, respond func(board *MSP, fr *MSPFrame)) *MSP {
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	go func() {
+		board := NewWithConn(remote)
+		for {
+			fr, err := board.ReadFrame()
+			if err != nil {
+				return
+			}
+			respond(board, fr)
+		}
+	}()
+	return NewWithConn(local)
+}
+
+func TestRequest(t *testing.T) {
+	m := requestBoard(t, func(board *MSP, fr *MSPFrame) {
+		// Unrelated frames and noise must be skipped
+		board.WriteResponse(MspStatus, uint16(1))
+		board.Write([]byte{0x00})
+		board.WriteResponse(fr.Code, uint8(0), uint8(2), uint8(4))
+	})
+	fr, err := m.Request(MspAPIVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspAPIVersion || string(fr.Payload) != "\x00\x02\x04" {
+		t.Errorf("Request() = code %d, payload %v, want code %d, payload [0 2 4]", fr.Code, fr.Payload, MspAPIVersion)
+	}
+}
+
+func TestRequestRejected(t *testing.T) {
+	m := requestBoard(t, func(board *MSP, fr *MSPFrame) {
+		board.Write(mspV1Encode('!', byte(fr.Code), nil))
+	})
+	_, err := m.Request(MspOSDConfig)
+	cerr, ok := err.(*mspCommandErr)
+	if !ok {
+		t.Fatalf("Request() = %v, want a rejected command error", err)
+	}
+	if cerr.RejectedCode() != MspOSDConfig {
+		t.Errorf("RejectedCode() = %d, want %d", cerr.RejectedCode(), MspOSDConfig)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	boards := make(chan *MSP, 1)
+	m := requestBoard(t, func(board *MSP, fr *MSPFrame) {
+		boards <- board
+	})
+	const timeout = 100 * time.Millisecond
+	m.SetRequestTimeout(timeout)
+	start := time.Now()
+	if fr, err := m.Request(MspAPIVersion); err == nil {
+		t.Fatalf("Request() without a response = %+v, want an error", fr)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 5*timeout {
+		t.Errorf("Request() returned after %v, want ~%v", elapsed, timeout)
+	}
+	board := <-boards
+
+	// Request() must not read after returning, so a late response is
+	// left for the next reader
+	go board.WriteResponse(MspAPIVersion, uint8(0), uint8(2), uint8(4))
+	fr, err := m.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspAPIVersion {
+		t.Errorf("ReadFrame() after timeout = code %d, want %d", fr.Code, MspAPIVersion)
+	}
+
+	// Later requests get their own response
+	go func() {
+		board := <-boards
+		board.WriteResponse(MspAPIVersion, uint8(0), uint8(2), uint8(4))
+		board.WriteResponse(MspFCVariant, []byte("INAV"))
+	}()
+	fr, err = m.Request(MspFCVariant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Code != MspFCVariant || string(fr.Payload) != "INAV" {
+		t.Errorf("Request() = code %d, payload %q, want code %d, payload \"INAV\"", fr.Code, fr.Payload, MspFCVariant)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+package msp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldTag contains the options in the msp tag of a struct field,
+// separated by commas:
+//
+//	be       the field (or its elements) is big endian
+//	count=N  the slice or string field has exactly N elements
+//	len      the slice or string field is prefixed by its length,
+//	         as an uint8
+type fieldTag struct {
+	bigEndian bool
+	count     int
+	lenPrefix bool
+}
+
+func parseFieldTag(field reflect.StructField) fieldTag {
+	tag := fieldTag{count: -1}
+	s, ok := field.Tag.Lookup("msp")
+	if !ok {
+		return tag
+	}
+	for _, opt := range strings.Split(s, ",") {
+		switch {
+		case opt == "be":
+			tag.bigEndian = true
+		case opt == "len":
+			tag.lenPrefix = true
+		case strings.HasPrefix(opt, "count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(opt, "count="))
+			if err != nil || n < 0 {
+				panic(fmt.Errorf("invalid count in msp tag of field %s: %q", field.Name, opt))
+			}
+			tag.count = n
+		default:
+			panic(fmt.Errorf("invalid msp tag option in field %s: %q", field.Name, opt))
+		}
+	}
+	if tag.lenPrefix && tag.count >= 0 {
+		panic(fmt.Errorf("field %s can't use both len and count in its msp tag", field.Name))
+	}
+	return tag
+}
+
+// readStruct decodes the fields of the struct pointed by v, honoring
+// their msp tags
+func (f *MSPFrame) readStruct(v reflect.Value) error {
+	t := v.Type()
+	for ii := 0; ii < v.NumField(); ii++ {
+		if err := f.readField(v.Field(ii), parseFieldTag(t.Field(ii))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *MSPFrame) readField(v reflect.Value, tag fieldTag) error {
+	n := tag.count
+	if tag.lenPrefix {
+		var size uint8
+		if err := f.Read(&size); err != nil {
+			return err
+		}
+		n = int(size)
+	}
+	if n >= 0 {
+		switch v.Kind() {
+		case reflect.String:
+			if f.BytesRemaining() < n {
+				return io.EOF
+			}
+			b := f.Payload[f.payloadPos : f.payloadPos+n]
+			f.payloadPos += n
+			// Fixed size strings are padded with NULs
+			v.SetString(string(bytes.TrimRight(b, "\x00")))
+			return nil
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		default:
+			panic(fmt.Errorf("msp len and count tags are only valid for slices and strings, not %s", v.Type()))
+		}
+	}
+	return f.readValue(v, tag.bigEndian)
+}
+
+func (f *MSPFrame) readValue(v reflect.Value, bigEndian bool) error {
+	if !bigEndian {
+		return f.Read(v.Addr().Interface())
+	}
+	switch v.Kind() {
+	case reflect.Uint16, reflect.Int16:
+		if f.BytesRemaining() < 2 {
+			return io.EOF
+		}
+		x := binary.BigEndian.Uint16(f.Payload[f.payloadPos:])
+		f.payloadPos += 2
+		if v.Kind() == reflect.Int16 {
+			v.SetInt(int64(int16(x)))
+		} else {
+			v.SetUint(uint64(x))
+		}
+	case reflect.Uint32, reflect.Int32:
+		if f.BytesRemaining() < 4 {
+			return io.EOF
+		}
+		x := binary.BigEndian.Uint32(f.Payload[f.payloadPos:])
+		f.payloadPos += 4
+		if v.Kind() == reflect.Int32 {
+			v.SetInt(int64(int32(x)))
+		} else {
+			v.SetUint(uint64(x))
+		}
+	case reflect.Slice, reflect.Array:
+		for ii := 0; ii < v.Len(); ii++ {
+			if err := f.readValue(v.Index(ii), true); err != nil {
+				return err
+			}
+		}
+	default:
+		return f.Read(v.Addr().Interface())
+	}
+	return nil
+}
+
+// encodeStruct encodes the fields of the struct v, honoring their
+// msp tags
+func (m *MSP) encodeStruct(w *bytes.Buffer, v reflect.Value) error {
+	t := v.Type()
+	for ii := 0; ii < v.NumField(); ii++ {
+		field := t.Field(ii)
+		if err := m.encodeField(w, v.Field(ii), field.Name, parseFieldTag(field)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MSP) encodeField(w *bytes.Buffer, v reflect.Value, name string, tag fieldTag) error {
+	if tag.lenPrefix || tag.count >= 0 {
+		if v.Kind() != reflect.String && v.Kind() != reflect.Slice {
+			panic(fmt.Errorf("msp len and count tags are only valid for slices and strings, not %s", v.Type()))
+		}
+		n := v.Len()
+		if tag.lenPrefix {
+			if n > 0xff {
+				return fmt.Errorf("field %s is too long (%d elements)", name, n)
+			}
+			w.WriteByte(byte(n))
+		} else if v.Kind() == reflect.String {
+			if n > tag.count {
+				return fmt.Errorf("field %s is too long (%d bytes, max %d)", name, n, tag.count)
+			}
+			w.WriteString(v.String())
+			// Pad fixed size strings with NULs
+			w.Write(make([]byte, tag.count-n))
+			return nil
+		} else if n != tag.count {
+			return fmt.Errorf("field %s must have %d elements, got %d", name, tag.count, n)
+		}
+		if v.Kind() == reflect.String {
+			w.WriteString(v.String())
+			return nil
+		}
+	}
+	return m.encodeValue(w, v, tag.bigEndian)
+}
+
+func (m *MSP) encodeValue(w *bytes.Buffer, v reflect.Value, bigEndian bool) error {
+	if !bigEndian {
+		return m.encodeArgs(w, v.Interface())
+	}
+	switch v.Kind() {
+	case reflect.Uint16, reflect.Int16, reflect.Uint32, reflect.Int32:
+		return binary.Write(w, binary.BigEndian, v.Interface())
+	case reflect.Slice, reflect.Array:
+		for ii := 0; ii < v.Len(); ii++ {
+			if err := m.encodeValue(w, v.Index(ii), true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return m.encodeArgs(w, v.Interface())
+}
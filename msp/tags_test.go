@@ -0,0 +1,91 @@
+package msp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type beTagged struct {
+	Value  uint16 `msp:"be"`
+	Signed int16  `msp:"be"`
+	Little uint16
+}
+
+type countTagged struct {
+	Values []uint16 `msp:"count=3"`
+	Name   string   `msp:"count=4"`
+}
+
+type lenTagged struct {
+	Name  string `msp:"len"`
+	After uint8
+}
+
+func TestFrameReadTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		out     interface{}
+		want    interface{}
+		err     error
+	}{
+		{
+			name:    "be",
+			payload: []byte{0x12, 0x34, 0xff, 0xfe, 0x34, 0x12},
+			out:     &beTagged{},
+			want:    &beTagged{Value: 0x1234, Signed: -2, Little: 0x1234},
+		},
+		{
+			name:    "count",
+			payload: []byte{1, 0, 2, 0, 3, 0, 'I', 'N', 0, 0},
+			out:     &countTagged{},
+			want:    &countTagged{Values: []uint16{1, 2, 3}, Name: "IN"},
+		},
+		{
+			name:    "len",
+			payload: []byte{4, 'I', 'N', 'A', 'V', 7},
+			out:     &lenTagged{},
+			want:    &lenTagged{Name: "INAV", After: 7},
+		},
+		{
+			name:    "short be",
+			payload: []byte{0x12, 0x34, 0xff},
+			out:     &beTagged{},
+			err:     io.EOF,
+		},
+		{
+			name:    "short count",
+			payload: []byte{1, 0, 2, 0},
+			out:     &countTagged{},
+			err:     io.EOF,
+		},
+		{
+			name:    "short len",
+			payload: []byte{4, 'I', 'N'},
+			out:     &lenTagged{},
+			err:     io.EOF,
+		},
+	}
+	for _, tt := range tests {
+		fr := &MSPFrame{Payload: tt.payload}
+		err := fr.Read(tt.out)
+		if err != tt.err {
+			t.Errorf("%s: Read() = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(tt.out, tt.want) {
+			t.Errorf("%s: Read() decoded %+v, want %+v", tt.name, tt.out, tt.want)
+		}
+		var buf bytes.Buffer
+		if err := (&MSP{}).encodeArgs(&buf, reflect.ValueOf(tt.want).Elem().Interface()); err != nil {
+			t.Errorf("%s: encodeArgs() = %v", tt.name, err)
+		} else if !bytes.Equal(buf.Bytes(), tt.payload) {
+			t.Errorf("%s: encodeArgs() = %v, want %v", tt.name, buf.Bytes(), tt.payload)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package msp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// ErrReadTimeout is returned by ReadFrame() when no data arrived within
+// the timeout set with SetReadTimeout(). The port is still open, the
+// board just didn't send anything.
+var ErrReadTimeout = errors.New("timed out reading from the port")
+
+// timeoutReader turns the empty reads returned by the port when the
+// read timeout expires into ErrReadTimeout. Reads returning EOF right
+// away still return EOF, since that's how some systems report a
+// disconnected port.
+type timeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	// The port timeout has a granularity of 100ms, so allow some
+	// slack when deciding whether it expired.
+	if n == 0 && (err == nil || err == io.EOF) && time.Since(start) >= t.timeout/2 {
+		return 0, ErrReadTimeout
+	}
+	return n, err
+}
+
+// SetReadTimeout makes ReadFrame() return ErrReadTimeout when no data
+// arrives for the given time, which must be at least 100ms. By default
+// (zero) reads block until data arrives, which might be forever if the
+// board stops responding without the port going away. Serial ports
+// are reopened to apply the timeout, so it must be called before
+// reading any frames and any data already buffered is discarded.
+func (m *MSP) SetReadTimeout(timeout time.Duration) error {
+	var r io.Reader
+	switch port := m.port.(type) {
+	case *serial.Port:
+		if err := port.Close(); err != nil {
+			return err
+		}
+		m.config.ReadTimeout = timeout
+		reopened, err := serial.OpenPort(&m.config)
+		if err != nil {
+			m.port = nil
+			return err
+		}
+		m.port = reopened
+		r = reopened
+		if timeout > 0 {
+			r = &timeoutReader{r: reopened, timeout: timeout}
+		}
+	case net.Conn:
+		m.deadline = &deadlineReader{conn: port, timeout: timeout}
+		r = m.deadline
+	default:
+		return errors.New("read timeouts are only supported by serial ports and network connections")
+	}
+	m.r = bufio.NewReaderSize(m.captureReader(r), m.r.Size())
+	return nil
+}
+
+// deadlineReader is the equivalent of timeoutReader for connections,
+// which support deadlines instead of timeouts. Reads also stop at
+// limit, which Request() sets to its own deadline. Zero values
+// disable either of them.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+	limit   time.Time
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	var deadline time.Time
+	if d.timeout > 0 {
+		deadline = time.Now().Add(d.timeout)
+	}
+	if !d.limit.IsZero() && (deadline.IsZero() || d.limit.Before(deadline)) {
+		deadline = d.limit
+	}
+	if err := d.conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := d.conn.Read(p)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() && n == 0 {
+		return 0, ErrReadTimeout
+	}
+	return n, err
+}
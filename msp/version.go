@@ -0,0 +1,117 @@
+package msp
+
+import (
+	"fmt"
+)
+
+// APIVersion is the MSP API version reported by the board in
+// MSP_API_VERSION
+type APIVersion struct {
+	Major uint8
+	Minor uint8
+}
+
+// AtLeast returns true iff v is the same or newer than other
+func (v APIVersion) AtLeast(other APIVersion) bool {
+	return v.Major > other.Major || (v.Major == other.Major && v.Minor >= other.Minor)
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// First API version whose firmwares understand MSPv2 framing
+var v2FramingAPIVersion = APIVersion{1, 40}
+
+// commandInfo describes the requirements of an MSP command
+type commandInfo struct {
+	// Oldest API version supporting the command
+	minAPIVersion APIVersion
+	// The command must be sent using MSPv2 framing
+	v2 bool
+}
+
+// Commands not listed here are assumed to be supported by any board
+// and are sent with MSPv1 framing, unless their code doesn't fit in it.
+var commandInfos = map[uint16]commandInfo{
+	Msp2CommonSettingInfo: {minAPIVersion: APIVersion{2, 0}, v2: true},
+
+	Msp2INAVStatus:   {minAPIVersion: APIVersion{2, 0}, v2: true},
+	Msp2INAVMixer:    {minAPIVersion: APIVersion{2, 0}, v2: true},
+	Msp2INAVSetMixer: {minAPIVersion: APIVersion{2, 0}, v2: true},
+
+	Msp2INAVSelectBatteryProfile: {minAPIVersion: APIVersion{2, 0}, v2: true},
+
+	Msp2SensorRangefinder: {minAPIVersion: APIVersion{2, 0}, v2: true},
+	Msp2SensorOpticFlow:   {minAPIVersion: APIVersion{2, 0}, v2: true},
+}
+
+// UnsupportedCommandError is returned by MSP.WriteCmd when the
+// board's API version is too old for the command.
+type UnsupportedCommandError struct {
+	Code       uint16
+	Required   APIVersion
+	APIVersion APIVersion
+}
+
+func (e *UnsupportedCommandError) Error() string {
+	return fmt.Sprintf("%s requires MSP API %s, board has %s", CommandName(e.Code), e.Required, e.APIVersion)
+}
+
+// APIVersion returns the API version reported by the board, if it
+// has been received. It's updated every time MSP_API_VERSION is read.
+func (m *MSP) APIVersion() (APIVersion, bool) {
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	return m.apiVersion, m.hasAPIVersion
+}
+
+func (m *MSP) updateAPIVersion(fr *MSPFrame) {
+	if fr.Code != MspAPIVersion || fr.Direction != '>' || len(fr.Payload) < 3 {
+		return
+	}
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	m.apiVersion = APIVersion{Major: fr.Payload[1], Minor: fr.Payload[2]}
+	m.hasAPIVersion = true
+}
+
+// useV2Framing returns whether cmd must be sent using MSPv2 framing,
+// or an error if the board doesn't support it. Until the board reports
+// its API version, commands are assumed to be supported.
+func (m *MSP) useV2Framing(cmd uint16) (bool, error) {
+	info := commandInfos[cmd]
+	v2 := info.v2 || cmd > 0xff
+	version, ok := m.APIVersion()
+	if ok {
+		required := info.minAPIVersion
+		if v2 && !required.AtLeast(v2FramingAPIVersion) {
+			required = v2FramingAPIVersion
+		}
+		if !version.AtLeast(required) {
+			return false, &UnsupportedCommandError{Code: cmd, Required: required, APIVersion: version}
+		}
+	}
+	return v2, nil
+}
+
+// trackRequestVersion records the framing version used for the last
+// request with the given code, see RequestVersion().
+func (m *MSP) trackRequestVersion(code uint16, version uint8) {
+	m.sentMu.Lock()
+	defer m.sentMu.Unlock()
+	if m.requestVersions == nil {
+		m.requestVersions = make(map[uint16]uint8)
+	}
+	m.requestVersions[code] = version
+}
+
+// RequestVersion returns the framing version (1 or 2) used for the
+// last request sent with the given code, or zero if none was sent.
+// Boards usually answer with the same framing, so it can be used to
+// detect unexpected responses.
+func (m *MSP) RequestVersion(code uint16) uint8 {
+	m.sentMu.Lock()
+	defer m.sentMu.Unlock()
+	return m.requestVersions[code]
+}
@@ -0,0 +1,335 @@
+package rx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr is an arithmetic expression of the elapsed time t, in seconds.
+// It supports numbers, t, pi, the + - * / % operators, parentheses and
+// the functions in exprFuncs.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(t float64) float64
+}
+
+type exprNum float64
+
+func (n exprNum) eval(t float64) float64 { return float64(n) }
+
+type exprTime struct{}
+
+func (exprTime) eval(t float64) float64 { return t }
+
+type exprNeg struct{ x exprNode }
+
+func (n exprNeg) eval(t float64) float64 { return -n.x.eval(t) }
+
+type exprBinary struct {
+	op   byte
+	l, r exprNode
+}
+
+func (n exprBinary) eval(t float64) float64 {
+	l, r := n.l.eval(t), n.r.eval(t)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		return l / r
+	case '%':
+		return math.Mod(l, r)
+	}
+	panic("unknown operator")
+}
+
+type exprCall struct {
+	fn   exprFunc
+	args []exprNode
+}
+
+func (n exprCall) eval(t float64) float64 {
+	args := make([]float64, len(n.args))
+	for ii, a := range n.args {
+		args[ii] = a.eval(t)
+	}
+	return n.fn.fn(args)
+}
+
+type exprFunc struct {
+	argc int
+	fn   func(args []float64) float64
+}
+
+var exprFuncs = map[string]exprFunc{
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	// Square wave, 1 during the first half of each period of 2pi
+	"square": {1, func(a []float64) float64 {
+		m := math.Mod(a[0], 2*math.Pi)
+		if m < 0 {
+			m += 2 * math.Pi
+		}
+		if m < math.Pi {
+			return 1
+		}
+		return -1
+	}},
+	"min": {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max": {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+// ParseExpr parses an expression, see Expr
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{src: s}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// Eval returns the value of the expression at the given elapsed time
+func (e *Expr) Eval(elapsed time.Duration) float64 {
+	return e.root.eval(elapsed.Seconds())
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok string
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression %q at %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+// next advances to the next token, leaving it in p.tok. An empty
+// token indicates the end of the input.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsLetter(c):
+		for p.pos < len(p.src) && unicode.IsLetter(rune(p.src[p.pos])) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" || p.tok == "%" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.tok == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{x: x}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end of expression")
+	case tok == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.next()
+		return x, nil
+	case tok == "t":
+		p.next()
+		return exprTime{}, nil
+	case tok == "pi":
+		p.next()
+		return exprNum(math.Pi), nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok)
+		}
+		p.next()
+		return exprNum(v), nil
+	case unicode.IsLetter(rune(tok[0])):
+		fn, ok := exprFuncs[tok]
+		if !ok {
+			return nil, p.errorf("unknown function %q", tok)
+		}
+		p.next()
+		if p.tok != "(" {
+			return nil, p.errorf("missing ( after %s", tok)
+		}
+		p.next()
+		var args []exprNode
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.tok != "," {
+				break
+			}
+			p.next()
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("missing ) after arguments to %s", tok)
+		}
+		p.next()
+		if len(args) != fn.argc {
+			return nil, p.errorf("%s takes %d arguments, got %d", tok, fn.argc, len(args))
+		}
+		return exprCall{fn: fn, args: args}, nil
+	}
+	return nil, p.errorf("unexpected %q", tok)
+}
+
+var stickChannels = map[string]int{
+	"roll":     1,
+	"pitch":    2,
+	"yaw":      3,
+	"throttle": 4,
+}
+
+// ExprSource drives RC channels using an Expr per channel
+type ExprSource struct {
+	exprs map[int]*Expr
+}
+
+// ParseExprSource parses a list of channel expressions separated by
+// semicolons, e.g. "throttle = 1000 + 500*sin(t); 5 = 2000". Channels
+// might be given by number or by stick name (roll, pitch, yaw and
+// throttle).
+func ParseExprSource(s string) (*ExprSource, error) {
+	src := &ExprSource{exprs: make(map[int]*Expr)}
+	for _, item := range strings.Split(s, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid channel expression %q, must be channel = expression", item)
+		}
+		ch, err := parseChannel(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := src.exprs[ch]; ok {
+			return nil, fmt.Errorf("channel %q has more than one expression", strings.TrimSpace(parts[0]))
+		}
+		e, err := ParseExpr(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		src.exprs[ch] = e
+	}
+	if len(src.exprs) == 0 {
+		return nil, fmt.Errorf("no channel expressions in %q", s)
+	}
+	return src, nil
+}
+
+// Channels returns the channels driven by the source, sorted
+func (s *ExprSource) Channels() []int {
+	var channels []int
+	for ch := range s.exprs {
+		channels = append(channels, ch)
+	}
+	sort.Ints(channels)
+	return channels
+}
+
+// Apply evaluates the expressions at the given elapsed time and sets
+// the results in r, clamped to [RxLow, RxHigh].
+func (s *ExprSource) Apply(r *RxSticks, elapsed time.Duration) {
+	for ch, e := range s.exprs {
+		v := e.Eval(elapsed)
+		switch {
+		case math.IsNaN(v) || v < RxLow:
+			v = RxLow
+		case v > RxHigh:
+			v = RxHigh
+		}
+		r.SetChannel(ch, uint16(math.Round(v)))
+	}
+}
@@ -1,6 +1,9 @@
 package rx
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,6 +16,10 @@ const (
 
 const (
 	keyTimeout = 100 * time.Millisecond
+	// Maximum trim offset, in either direction
+	MaxTrim = (RxHigh - RxLow) / 2
+	// Maximum deadband around the center, in either direction
+	MaxDeadband = 100
 )
 
 type RXKey uint8
@@ -49,13 +56,31 @@ type RX interface {
 }
 
 type RxSticks struct {
-	Roll      uint16
-	Pitch     uint16
-	Yaw       uint16
-	Throttle  uint16
-	Channels  [14]uint16 // Channels 5-18
-	mu        sync.Mutex
-	lastPress [rxKeyCount]time.Time
+	Roll     uint16
+	Pitch    uint16
+	Yaw      uint16
+	Throttle uint16
+	Channels [14]uint16 // Channels 5-18
+	// Trim offsets for roll, pitch, yaw and throttle, applied
+	// when building the MSP_SET_RAW_RC payload.
+	Trim [4]int16
+	// Deadband around RxMid for roll, pitch and yaw. Values closer
+	// than this to the center are sent as the center (plus its trim),
+	// which hides the noise from analog inputs.
+	Deadband uint16
+	// Names for the AUX channels, by channel number (starting at 1)
+	ChannelNames map[int]string
+	// ChannelOrder, if not empty, overrides the channel map passed
+	// to ToMSP: position ii of the payload carries the channel
+	// ChannelOrder[ii] (1-4 for roll, pitch, yaw and throttle, 5 and
+	// up for AUX). See ParseChannelOrder.
+	ChannelOrder []int
+	mu           sync.Mutex
+	lastPress    [rxKeyCount]time.Time
+	// Values set via SetChannel for roll, pitch, yaw and throttle,
+	// which the sticks return to after a keypress instead of the
+	// center. Zero if the stick isn't held.
+	held [4]uint16
 }
 
 func (r *RxSticks) Reset() {
@@ -66,22 +91,93 @@ func (r *RxSticks) Reset() {
 	for ii := range r.Channels {
 		r.Channels[ii] = RxLow
 	}
+	r.held = [4]uint16{}
 }
 
-func (r *RxSticks) ToMSP(channelMap []uint8) rxPayload {
+// ToMSP returns the payload for MSP_SET_RAW_RC, placing the sticks
+// according to channelMap and the AUX channels after them, unless
+// ChannelOrder is set. If channelCount is non zero, exactly that
+// many channels are included, truncating the ones not used by the
+// board or adding low channels as needed.
+func (r *RxSticks) ToMSP(channelMap []uint8, channelCount int) rxPayload {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	channels := make([]uint16, 4)
-	channels[channelMap[0]] = r.Roll
-	channels[channelMap[1]] = r.Pitch
-	channels[channelMap[2]] = r.Yaw
-	channels[channelMap[3]] = r.Throttle
-	channels = append(channels, r.Channels[:]...)
+	var channels []uint16
+	if len(r.ChannelOrder) > 0 {
+		logical := []uint16{
+			trimmed(r.deadband(r.Roll), r.Trim[0]),
+			trimmed(r.deadband(r.Pitch), r.Trim[1]),
+			trimmed(r.deadband(r.Yaw), r.Trim[2]),
+			trimmed(r.Throttle, r.Trim[3]),
+		}
+		logical = append(logical, r.Channels[:]...)
+		channels = make([]uint16, len(r.ChannelOrder))
+		for ii, ch := range r.ChannelOrder {
+			channels[ii] = logical[ch-1]
+		}
+	} else {
+		channels = make([]uint16, 4)
+		channels[channelMap[0]] = trimmed(r.deadband(r.Roll), r.Trim[0])
+		channels[channelMap[1]] = trimmed(r.deadband(r.Pitch), r.Trim[1])
+		channels[channelMap[2]] = trimmed(r.deadband(r.Yaw), r.Trim[2])
+		channels[channelMap[3]] = trimmed(r.Throttle, r.Trim[3])
+		channels = append(channels, r.Channels[:]...)
+	}
+	if channelCount > 0 {
+		for len(channels) < channelCount {
+			channels = append(channels, RxLow)
+		}
+		channels = channels[:channelCount]
+	}
 	return rxPayload{
 		Channels: channels,
 	}
 }
 
+// ValidateTrim returns an error if any of the trim offsets
+// exceeds MaxTrim.
+func ValidateTrim(trim [4]int16) error {
+	axes := [...]string{"roll", "pitch", "yaw", "throttle"}
+	for ii, t := range trim {
+		if t > MaxTrim || t < -MaxTrim {
+			return fmt.Errorf("invalid %s trim %d, must be within [-%d, %d]", axes[ii], t, MaxTrim, MaxTrim)
+		}
+	}
+	return nil
+}
+
+// ValidateDeadband returns an error if the deadband exceeds
+// MaxDeadband.
+func ValidateDeadband(deadband uint16) error {
+	if deadband > MaxDeadband {
+		return fmt.Errorf("invalid deadband %d, must be within [0, %d]", deadband, MaxDeadband)
+	}
+	return nil
+}
+
+// deadband returns RxMid if value is within the deadband around it,
+// otherwise value.
+func (r *RxSticks) deadband(value uint16) uint16 {
+	d := int(value) - RxMid
+	if d <= int(r.Deadband) && d >= -int(r.Deadband) {
+		return RxMid
+	}
+	return value
+}
+
+// trimmed returns value offset by trim, without exceeding
+// the stick endpoints.
+func trimmed(value uint16, trim int16) uint16 {
+	v := int(value) + int(trim)
+	if v < RxLow {
+		return RxLow
+	}
+	if v > RxHigh {
+		return RxHigh
+	}
+	return uint16(v)
+}
+
 func (r *RxSticks) Keypress(key RXKey) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -147,18 +243,269 @@ func (r *RxSticks) Update() {
 			r.lastPress[ii] = time.Time{}
 			switch RXKey(ii) {
 			case RXKeyW, RXKeyS:
-				r.Throttle = RxMid
+				r.Throttle = r.rest(4)
 			case RXKeyA, RXKeyD:
-				r.Yaw = RxMid
+				r.Yaw = r.rest(3)
 			case RXKeyUp, RXKeyDown:
-				r.Pitch = RxMid
+				r.Pitch = r.rest(2)
 			case RXKeyLeft, RXKeyRight:
-				r.Roll = RxMid
+				r.Roll = r.rest(1)
 			}
 		}
 	}
 }
 
+// rest returns the position of the given stick (1-4) when no keys
+// are pressed: the value it's held at or the center.
+func (r *RxSticks) rest(ch int) uint16 {
+	if v := r.held[ch-1]; v != 0 {
+		return v
+	}
+	return RxMid
+}
+
+// SetChannel sets the given channel to value, clamped to [RxLow,
+// RxHigh], until it's changed again. Channels are numbered from 1,
+// with 1-4 being roll, pitch, yaw and throttle. Sticks are held at
+// the value, returning to it after a keypress instead of the center.
+func (r *RxSticks) SetChannel(ch int, value uint16) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value < RxLow {
+		value = RxLow
+	} else if value > RxHigh {
+		value = RxHigh
+	}
+	if ch >= 1 && ch <= 4 {
+		r.held[ch-1] = value
+	}
+	// Make sure a previous keypress doesn't recenter the stick
+	switch ch {
+	case 1:
+		r.Roll = value
+		r.lastPress[RXKeyLeft] = time.Time{}
+		r.lastPress[RXKeyRight] = time.Time{}
+	case 2:
+		r.Pitch = value
+		r.lastPress[RXKeyUp] = time.Time{}
+		r.lastPress[RXKeyDown] = time.Time{}
+	case 3:
+		r.Yaw = value
+		r.lastPress[RXKeyA] = time.Time{}
+		r.lastPress[RXKeyD] = time.Time{}
+	case 4:
+		r.Throttle = value
+		r.lastPress[RXKeyW] = time.Time{}
+		r.lastPress[RXKeyS] = time.Time{}
+	default:
+		idx := ch - 5
+		if idx < 0 || idx >= len(r.Channels) {
+			return fmt.Errorf("invalid RC channel %d", ch)
+		}
+		r.Channels[idx] = value
+	}
+	return nil
+}
+
+// Channel returns the current value of the given channel, numbered
+// as in SetChannel. It returns zero for invalid channels.
+func (r *RxSticks) Channel(ch int) uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ch {
+	case 1:
+		return r.Roll
+	case 2:
+		return r.Pitch
+	case 3:
+		return r.Yaw
+	case 4:
+		return r.Throttle
+	}
+	if idx := ch - 5; idx >= 0 && idx < len(r.Channels) {
+		return r.Channels[idx]
+	}
+	return 0
+}
+
+// ParseChannelValue parses a channel assignment like "throttle=1600",
+// "4=1600" or "throttle=60%", where percentages go from RxLow to
+// RxHigh. Channels might be given by number or by stick name (roll,
+// pitch, yaw and throttle).
+func ParseChannelValue(s string) (ch int, value uint16, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid channel value %q, must be channel=value", s)
+	}
+	if ch, err = parseChannel(parts[0]); err != nil {
+		return 0, 0, err
+	}
+	v := strings.TrimSpace(parts[1])
+	if pct := strings.TrimSuffix(v, "%"); pct != v {
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, 0, fmt.Errorf("invalid percentage %q, must be within [0, 100]", v)
+		}
+		return ch, uint16(RxLow + p*(RxHigh-RxLow)/100 + 0.5), nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < RxLow || n > RxHigh {
+		return 0, 0, fmt.Errorf("invalid channel value %q, must be within [%d, %d]", v, RxLow, RxHigh)
+	}
+	return ch, uint16(n), nil
+}
+
+// parseChannel parses a channel number (starting at 1) or stick name
+func parseChannel(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if ch, ok := stickChannels[name]; ok {
+		return ch, nil
+	}
+	ch, err := strconv.Atoi(name)
+	if err != nil || ch < 1 || ch > 4+len(RxSticks{}.Channels) {
+		return 0, fmt.Errorf("invalid channel %q", name)
+	}
+	return ch, nil
+}
+
+// ParseChannelOrder parses the channel carried by each position of the
+// MSP_SET_RAW_RC payload, separated by commas, e.g.
+// "throttle,roll,pitch,yaw,6,5". Channels might be given by number
+// (starting at 1) or by stick name. Every stick must be included and
+// channels can't be repeated.
+func ParseChannelOrder(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var order []int
+	for _, item := range strings.Split(s, ",") {
+		ch, err := parseChannel(item)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, ch)
+	}
+	if err := ValidateChannelOrder(order, 0); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// ValidateChannelOrder returns an error if the order (see
+// ParseChannelOrder) is not valid. If channelCount is non zero, it
+// also fails when the order places a channel after the number of
+// channels used by the board, which would never reach it.
+func ValidateChannelOrder(order []int, channelCount int) error {
+	if len(order) == 0 {
+		return nil
+	}
+	seen := make(map[int]bool)
+	for _, ch := range order {
+		if ch < 1 || ch > 4+len(RxSticks{}.Channels) {
+			return fmt.Errorf("invalid channel %d in channel order", ch)
+		}
+		if seen[ch] {
+			return fmt.Errorf("channel %d appears more than once in the channel order", ch)
+		}
+		seen[ch] = true
+	}
+	for ch := 1; ch <= 4; ch++ {
+		if !seen[ch] {
+			return fmt.Errorf("channel order must include %s", ChannelFunction(ch))
+		}
+	}
+	if channelCount > 0 && len(order) > channelCount {
+		return fmt.Errorf("channel order has %d channels but the board uses %d, channels %v won't be sent",
+			len(order), channelCount, order[channelCount:])
+	}
+	return nil
+}
+
+// ChannelFunction returns the stick name for channels 1-4 and
+// AUX<n> for the rest.
+func ChannelFunction(ch int) string {
+	for name, c := range stickChannels {
+		if c == ch {
+			return name
+		}
+	}
+	return fmt.Sprintf("AUX%d", ch-4)
+}
+
+// ParseChannelNames parses AUX channel names in the form
+// "5=ARM,6=MODE", validating that channels are AUX channels and that
+// neither channels nor names are repeated.
+func ParseChannelNames(s string) (map[int]string, error) {
+	names := make(map[int]string)
+	if s == "" {
+		return names, nil
+	}
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid channel name %q, must be channel=name", item)
+		}
+		ch, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel in %q: %v", item, err)
+		}
+		name := strings.TrimSpace(parts[1])
+		if ch < 5 || ch >= 5+len(RxSticks{}.Channels) {
+			return nil, fmt.Errorf("invalid AUX channel %d, must be within [5, %d]", ch, 4+len(RxSticks{}.Channels))
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty name for channel %d", ch)
+		}
+		if _, ok := names[ch]; ok {
+			return nil, fmt.Errorf("channel %d is named more than once", ch)
+		}
+		if seen[strings.ToUpper(name)] {
+			return nil, fmt.Errorf("name %q is used for more than one channel", name)
+		}
+		seen[strings.ToUpper(name)] = true
+		names[ch] = name
+	}
+	return names, nil
+}
+
+// ChannelName returns the name of the given channel (starting at 1),
+// or CH<n> if it doesn't have a name.
+func (r *RxSticks) ChannelName(ch int) string {
+	if name := r.ChannelNames[ch]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("CH%d", ch)
+}
+
+// KeyChannel returns the AUX channel toggled by the given key
+func KeyChannel(key RXKey) (int, bool) {
+	if key < RXKey1 || key > RXKey0 {
+		return 0, false
+	}
+	return 5 + int(key-RXKey1), true
+}
+
+// DescribeChannel returns the name and the state of the given AUX
+// channel, e.g. "ARM (CH5): HIGH"
+func (r *RxSticks) DescribeChannel(ch int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx := ch - 5
+	if idx < 0 || idx >= len(r.Channels) {
+		return fmt.Sprintf("invalid channel %d", ch)
+	}
+	state := "LOW"
+	if r.Channels[idx] != RxLow {
+		state = "HIGH"
+	}
+	name := r.ChannelName(ch)
+	if _, ok := r.ChannelNames[ch]; ok {
+		name = fmt.Sprintf("%s (CH%d)", name, ch)
+	}
+	return fmt.Sprintf("%s: %s", name, state)
+}
+
 func (r *RxSticks) switchChannel(ch int) {
 	idx := ch - 5
 	if idx >= 0 && idx < len(r.Channels) {
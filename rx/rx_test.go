@@ -0,0 +1,178 @@
+package rx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// aetr is the channel map for AETR boards: roll, pitch, yaw and
+// throttle go to positions 0, 1, 3 and 2.
+var aetr = []uint8{0, 1, 3, 2}
+
+func TestToMSPChannelCount(t *testing.T) {
+	var r RxSticks
+	r.Reset()
+	r.Roll = 1100
+	r.Pitch = 1200
+	r.Yaw = 1300
+	r.Throttle = 1400
+	r.Channels[0] = RxHigh
+	r.Channels[13] = RxHigh
+
+	full := []uint16{1100, 1200, 1400, 1300, RxHigh}
+	for ii := 0; ii < 12; ii++ {
+		full = append(full, RxLow)
+	}
+	full = append(full, RxHigh)
+
+	tests := []struct {
+		count int
+		want  []uint16
+	}{
+		// Zero sends all the channels
+		{0, full},
+		{4, []uint16{1100, 1200, 1400, 1300}},
+		{8, []uint16{1100, 1200, 1400, 1300, RxHigh, RxLow, RxLow, RxLow}},
+		{len(full), full},
+		{20, append(append([]uint16(nil), full...), RxLow, RxLow)},
+	}
+	for _, tt := range tests {
+		got := r.ToMSP(aetr, tt.count).Channels
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToMSP(%d) = %v, want %v", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestTrimmed(t *testing.T) {
+	tests := []struct {
+		value uint16
+		trim  int16
+		want  uint16
+	}{
+		{RxMid, 0, RxMid},
+		{RxMid, 25, RxMid + 25},
+		{RxMid, -25, RxMid - 25},
+		{RxLow, 10, RxLow + 10},
+		{RxLow, -10, RxLow},
+		{RxLow + 5, -10, RxLow},
+		{RxHigh, -10, RxHigh - 10},
+		{RxHigh, 10, RxHigh},
+		{RxHigh - 5, 10, RxHigh},
+	}
+	for _, tt := range tests {
+		if got := trimmed(tt.value, tt.trim); got != tt.want {
+			t.Errorf("trimmed(%d, %d) = %d, want %d", tt.value, tt.trim, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTrim(t *testing.T) {
+	tests := []struct {
+		trim [4]int16
+		ok   bool
+	}{
+		{[4]int16{}, true},
+		{[4]int16{MaxTrim, -MaxTrim, 0, 10}, true},
+		{[4]int16{MaxTrim + 1, 0, 0, 0}, false},
+		{[4]int16{0, 0, 0, -MaxTrim - 1}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateTrim(tt.trim); (err == nil) != tt.ok {
+			t.Errorf("ValidateTrim(%v) = %v, want ok = %v", tt.trim, err, tt.ok)
+		}
+	}
+}
+
+func TestToMSPTrim(t *testing.T) {
+	var r RxSticks
+	r.Reset()
+	r.Trim = [4]int16{20, -20, 0, 30}
+	r.Pitch = RxLow
+	got := r.ToMSP(aetr, 4).Channels
+	// Pitch is clamped at RxLow, throttle starts at RxLow
+	want := []uint16{RxMid + 20, RxLow, RxLow + 30, RxMid}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMSP() with trim = %v, want %v", got, want)
+	}
+}
+
+func TestToMSPChannelOrder(t *testing.T) {
+	// TAER1234 with AUX2 before AUX1
+	order, err := ParseChannelOrder("throttle,roll,pitch,yaw,6,5,7,8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r RxSticks
+	r.Reset()
+	r.ChannelOrder = order
+	r.Roll = 1100
+	r.Pitch = 1200
+	r.Yaw = 1300
+	r.Throttle = 1400
+	r.Channels[0] = 1501 // AUX1
+	r.Channels[1] = 1502 // AUX2
+	r.Channels[3] = 1504 // AUX4
+
+	// The channel map is ignored when ChannelOrder is set
+	got := r.ToMSP(aetr, 0).Channels
+	want := []uint16{1400, 1100, 1200, 1300, 1502, 1501, RxLow, 1504}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMSP() = %v, want %v", got, want)
+	}
+	got = r.ToMSP(aetr, 10).Channels
+	want = append(want, RxLow, RxLow)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMSP(10) = %v, want %v", got, want)
+	}
+}
+
+func TestDeadband(t *testing.T) {
+	r := RxSticks{Deadband: 10}
+	tests := []struct {
+		value uint16
+		want  uint16
+	}{
+		{RxMid, RxMid},
+		{RxMid + 5, RxMid},
+		{RxMid - 5, RxMid},
+		{RxMid + 10, RxMid},
+		{RxMid - 10, RxMid},
+		{RxMid + 11, RxMid + 11},
+		{RxMid - 11, RxMid - 11},
+		{RxLow, RxLow},
+		{RxHigh, RxHigh},
+	}
+	for _, tt := range tests {
+		if got := r.deadband(tt.value); got != tt.want {
+			t.Errorf("deadband(%d) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDeadband(t *testing.T) {
+	for _, d := range []uint16{0, 10, MaxDeadband} {
+		if err := ValidateDeadband(d); err != nil {
+			t.Errorf("ValidateDeadband(%d) = %v", d, err)
+		}
+	}
+	if err := ValidateDeadband(MaxDeadband + 1); err == nil {
+		t.Errorf("ValidateDeadband(%d) succeeded", MaxDeadband+1)
+	}
+}
+
+func TestToMSPDeadband(t *testing.T) {
+	var r RxSticks
+	r.Reset()
+	r.Deadband = 10
+	r.Roll = RxMid + 5
+	r.Pitch = RxMid - 10
+	r.Yaw = RxMid + 20
+	// Throttle has no center, so it's exempt
+	r.Throttle = RxMid + 5
+	got := r.ToMSP(aetr, 4).Channels
+	want := []uint16{RxMid, RxMid, RxMid + 5, RxMid + 20}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMSP() with deadband = %v, want %v", got, want)
+	}
+}
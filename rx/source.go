@@ -0,0 +1,78 @@
+package rx
+
+import "time"
+
+// Source drives RC channels during RX simulation, on top of the
+// keyboard controlled sticks.
+type Source interface {
+	// Apply sets the channels driven by the source in r. elapsed is
+	// the time since the source became active.
+	Apply(r *RxSticks, elapsed time.Duration)
+	// Release returns the channels driven by the source to the
+	// keyboard once another source becomes active.
+	Release(r *RxSticks)
+}
+
+// Release implements Source. Sticks driven by the expressions are
+// centered, like when RX simulation starts, while AUX channels keep
+// their last value, so switches don't flip.
+func (s *ExprSource) Release(r *RxSticks) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range s.exprs {
+		if ch > 4 {
+			continue
+		}
+		r.held[ch-1] = 0
+		switch ch {
+		case 1:
+			r.Roll = RxMid
+		case 2:
+			r.Pitch = RxMid
+		case 3:
+			r.Yaw = RxMid
+		case 4:
+			r.Throttle = RxMid
+		}
+	}
+}
+
+// Crossfade ramps the channels sent to the board from their values
+// before the input source changed to the ones produced by the new
+// source, avoiding sudden jumps.
+type Crossfade struct {
+	from     []uint16
+	start    time.Time
+	duration time.Duration
+}
+
+// NewCrossfade returns a Crossfade starting now from the given
+// channels (as sent in MSP_SET_RAW_RC) and lasting duration.
+func NewCrossfade(from []uint16, duration time.Duration) *Crossfade {
+	c := &Crossfade{
+		from:     make([]uint16, len(from)),
+		start:    time.Now(),
+		duration: duration,
+	}
+	copy(c.from, from)
+	return c
+}
+
+// Apply blends channels in place with the starting values, according
+// to the time elapsed since the crossfade started. It returns false
+// once the crossfade is over and channels are left untouched.
+func (c *Crossfade) Apply(channels []uint16, now time.Time) bool {
+	elapsed := now.Sub(c.start)
+	if elapsed >= c.duration {
+		return false
+	}
+	progress := float64(elapsed) / float64(c.duration)
+	for ii := range channels {
+		if ii >= len(c.from) {
+			break
+		}
+		from := float64(c.from[ii])
+		channels[ii] = uint16(from + (float64(channels[ii])-from)*progress + 0.5)
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transcript is a log of everything written to the terminal, with
+// each line prefixed by the time it was written at. Writes go straight
+// to the file without buffering, so nothing is lost if msp-tool
+// crashes.
+type transcript struct {
+	mu sync.Mutex
+	f  *os.File
+	// True iff the next byte written starts a line
+	lineStart bool
+}
+
+// openTranscript creates the file at path, truncating it if it exists
+func openTranscript(path string) (*transcript, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &transcript{f: f, lineStart: true}
+	header := fmt.Sprintf("# msp-tool %s, started at %s\n", strings.Join(os.Args[1:], " "), time.Now().Format(time.RFC3339))
+	if _, err := f.WriteString(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf bytes.Buffer
+	for _, c := range p {
+		if t.lineStart {
+			buf.WriteString(time.Now().Format("15:04:05.000 "))
+			t.lineStart = false
+		}
+		buf.WriteByte(c)
+		if c == '\n' {
+			t.lineStart = true
+		}
+	}
+	if _, err := t.f.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *transcript) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.lineStart {
+		t.f.Write([]byte{'\n'})
+	}
+	return t.f.Close()
+}